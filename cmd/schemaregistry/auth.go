@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"schemaregistry/internal/auth"
+	"schemaregistry/internal/rest"
+)
+
+// Authentication methods
+const (
+	authBasic  = "basic"  // users and bcrypt hashes of an htpasswd file
+	authJWT    = "jwt"    // bearer tokens signed with the keys of a JWKS file
+	authAPIKey = "apikey" // API keys stored in a KV bucket
+	authMTLS   = "mtls"   // client certificates verified by the HTTPS listener
+)
+
+// setupAuth creates the authenticators of the configured methods, in order,
+// and installs them on the REST handlers along with the authorization settings. The API key authenticator, if any,
+// is returned so that its bucket can be bound once storage is set up.
+func setupAuth(cfg config) (*auth.APIKeys, error) {
+	var authenticators []auth.Authenticator
+	var apiKeys *auth.APIKeys
+	for _, method := range strings.Split(cfg.Auth, ",") {
+		switch strings.TrimSpace(method) {
+		case "":
+		case authBasic:
+			if cfg.HtpasswdFile == "" {
+				return nil, fmt.Errorf("basic authentication requires an htpasswd file")
+			}
+			htpasswd, err := auth.LoadHtpasswd(cfg.HtpasswdFile)
+			if err != nil {
+				return nil, fmt.Errorf("load htpasswd file: %w", err)
+			}
+			authenticators = append(authenticators, htpasswd)
+		case authJWT:
+			if cfg.JWKSFile == "" {
+				return nil, fmt.Errorf("JWT authentication requires a JWKS file")
+			}
+			jwt, err := auth.LoadJWKS(cfg.JWKSFile, auth.JWTOptions{
+				Issuer:         cfg.JWTIssuer,
+				Audience:       cfg.JWTAudience,
+				PrincipalClaim: cfg.JWTPrincipalClaim,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("load JWKS file: %w", err)
+			}
+			authenticators = append(authenticators, jwt)
+		case authAPIKey:
+			apiKeys = auth.NewAPIKeys()
+			authenticators = append(authenticators, apiKeys)
+		case authMTLS:
+			if cfg.TLSClientCAFile == "" {
+				return nil, fmt.Errorf("mTLS authentication requires a client CA file")
+			}
+			clientCert, err := auth.NewClientCert(cfg.MTLSPrincipalField)
+			if err != nil {
+				return nil, err
+			}
+			authenticators = append(authenticators, clientCert)
+		default:
+			return nil, fmt.Errorf("unknown authentication method %q", method)
+		}
+	}
+
+	if len(authenticators) == 0 {
+		slog.Warn("Authentication disabled, anyone reaching the API can change or delete schemas")
+	} else {
+		slog.Info("Authentication enabled", "methods", cfg.Auth)
+	}
+	rest.SetAuthenticators(authenticators...)
+
+	// Roles can only be checked for callers that are identified
+	if cfg.RBAC && len(authenticators) == 0 {
+		return nil, fmt.Errorf("authorization requires an authentication method")
+	}
+	var superusers []string
+	for _, name := range strings.Split(cfg.RBACSuperusers, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		// Principals are qualified with their method, so that a name of another method cannot match
+		method, _, _ := strings.Cut(name, ":")
+		switch method {
+		case authBasic, authJWT, authAPIKey, authMTLS:
+		default:
+			return nil, fmt.Errorf("superuser %q is not qualified with an authentication method, such as %s:%s", name, authBasic, name)
+		}
+		superusers = append(superusers, name)
+	}
+	if cfg.RBAC {
+		slog.Info("Authorization enabled", "superusers", superusers)
+	}
+	rest.SetAuthorization(cfg.RBAC, superusers...)
+	return apiKeys, nil
+}
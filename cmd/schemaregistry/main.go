@@ -8,7 +8,11 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"schemaregistry/internal/auth"
 	"schemaregistry/internal/rest"
+	"schemaregistry/internal/schema"
+	"strconv"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,29 +20,116 @@ import (
 	"github.com/nats-io/nats.go"
 )
 
+// Storage modes
+const (
+	storageNATS       = "nats"       // external NATS server
+	storageStandalone = "standalone" // embedded NATS server persisting to DataDir
+)
+
+// Storage policies, deciding what to serve while storage is unavailable
+const (
+	policyRequire       = "require"        // reject requests until storage is available
+	policyMemoryAllowed = "memory-allowed" // serve an empty read-only registry until storage is available
+)
+
+// Interval between attempts to set up storage after it failed
+const reconnectInterval = 5 * time.Second
+
 type config struct {
-	NATSURL      string
-	HTTPAddr     string
-	SchemaBucket string
-	ConfigBucket string
-	Debug        bool
-	TestMode     bool
+	NATSURL            string
+	NATSCredsFile      string
+	NATSNKeyFile       string
+	NATSUser           string
+	NATSPassword       string
+	NATSTLSCAFile      string
+	NATSTLSCertFile    string
+	NATSTLSKeyFile     string
+	HTTPAddr           string
+	TLSCertFile        string
+	TLSKeyFile         string
+	TLSClientCAFile    string
+	TLSClientAuth      string
+	TLSMinVersion      string
+	SchemaBucket       string
+	ConfigBucket       string
+	Storage            string
+	DataDir            string
+	Policy             string
+	Tracing            string
+	OTLPEndpoint       string
+	OTLPInsecure       bool
+	TracingFile        string
+	TracingSampleRatio float64
+	Auth               string
+	HtpasswdFile       string
+	JWKSFile           string
+	JWTIssuer          string
+	JWTAudience        string
+	JWTPrincipalClaim  string
+	APIKeyBucket       string
+	MTLSPrincipalField string
+	RBAC               bool
+	RBACSuperusers     string
+	Debug              bool
+	TestMode           bool
+}
+
+// LogValue hides the secrets of the config from logs
+func (c config) LogValue() slog.Value {
+	if c.NATSPassword != "" {
+		c.NATSPassword = "REDACTED"
+	}
+	type plain config // drops this method, which would otherwise be called again
+	return slog.AnyValue(plain(c))
 }
 
 func (c *config) load() {
 	flag.StringVar(&c.NATSURL, "nats-url", getEnv("NATS_URL", nats.DefaultURL), "NATS server URL")
+	flag.StringVar(&c.NATSCredsFile, "nats-creds-file", getEnv("NATS_CREDS_FILE", ""), "Credentials file of the NATS user, with its JWT and nkey seed")
+	flag.StringVar(&c.NATSNKeyFile, "nats-nkey-file", getEnv("NATS_NKEY_FILE", ""), "File of the nkey seed the registry authenticates to NATS with")
+	flag.StringVar(&c.NATSUser, "nats-user", getEnv("NATS_USER", ""), "NATS user name")
+	flag.StringVar(&c.NATSPassword, "nats-password", getEnv("NATS_PASSWORD", ""), "NATS password")
+	flag.StringVar(&c.NATSTLSCAFile, "nats-tls-ca-file", getEnv("NATS_TLS_CA_FILE", ""), "CA certificates the NATS server certificate is verified with, the system ones if empty")
+	flag.StringVar(&c.NATSTLSCertFile, "nats-tls-cert-file", getEnv("NATS_TLS_CERT_FILE", ""), "Client certificate presented to the NATS server")
+	flag.StringVar(&c.NATSTLSKeyFile, "nats-tls-key-file", getEnv("NATS_TLS_KEY_FILE", ""), "Key of the client certificate presented to the NATS server")
 	flag.StringVar(&c.HTTPAddr, "http-addr", getEnv("HTTP_ADDR", ":8081"), "HTTP server address")
+	flag.StringVar(&c.TLSCertFile, "tls-cert-file", getEnv("TLS_CERT_FILE", ""), "Certificate of the HTTPS listener, plain HTTP if empty")
+	flag.StringVar(&c.TLSKeyFile, "tls-key-file", getEnv("TLS_KEY_FILE", ""), "Key of the certificate of the HTTPS listener")
+	flag.StringVar(&c.TLSClientCAFile, "tls-client-ca-file", getEnv("TLS_CLIENT_CA_FILE", ""), "CA certificates client certificates are verified with")
+	flag.StringVar(&c.TLSClientAuth, "tls-client-auth", getEnv("TLS_CLIENT_AUTH", ""), "Client certificates: none, request to verify them if sent, require to refuse clients without one; request if a client CA is set")
+	flag.StringVar(&c.TLSMinVersion, "tls-min-version", getEnv("TLS_MIN_VERSION", "1.2"), "Minimum TLS version: 1.2 or 1.3")
 	flag.StringVar(&c.SchemaBucket, "schema-bucket", getEnv("SCHEMA_BUCKET", "SCHEMAS"), "JetStream KV bucket for schemas")
 	flag.StringVar(&c.ConfigBucket, "config-bucket", getEnv("CONFIG_BUCKET", "CONFIG"), "JetStream KV bucket for configs")
+	flag.StringVar(&c.Storage, "storage", getEnv("STORAGE", storageNATS), "Storage mode: nats to use an external NATS server, standalone to run an embedded one persisting to data-dir")
+	flag.StringVar(&c.DataDir, "data-dir", getEnv("DATA_DIR", "./data"), "Directory the embedded NATS server stores data in, in standalone mode")
+	flag.StringVar(&c.Policy, "storage-policy", getEnv("STORAGE_POLICY", policyRequire), "What to do while storage is unavailable: require to reject requests, memory-allowed to serve an empty read-only registry")
+	flag.StringVar(&c.Tracing, "tracing", getEnv("TRACING", tracingNone), "Tracing exporter: none, otlp to send spans to an OTLP/HTTP collector, stdout to write them to stdout or tracing-file")
+	flag.StringVar(&c.OTLPEndpoint, "otlp-endpoint", getEnv("OTLP_ENDPOINT", "localhost:4318"), "Host and port of the OTLP/HTTP collector")
+	flag.BoolVar(&c.OTLPInsecure, "otlp-insecure", getEnvBool("OTLP_INSECURE", false), "Send spans to the OTLP collector over plain HTTP")
+	flag.StringVar(&c.TracingFile, "tracing-file", getEnv("TRACING_FILE", ""), "File the stdout exporter appends spans to instead of stdout")
+	flag.Float64Var(&c.TracingSampleRatio, "tracing-sample-ratio", getEnvFloat("TRACING_SAMPLE_RATIO", 1), "Share of traces started by the registry that are recorded")
+	flag.StringVar(&c.Auth, "auth", getEnv("AUTH", ""), "Comma-separated authentication methods tried in order: basic, jwt, apikey, mtls; none if empty")
+	flag.StringVar(&c.HtpasswdFile, "auth-htpasswd-file", getEnv("AUTH_HTPASSWD_FILE", ""), "htpasswd file of users and bcrypt password hashes, for basic authentication")
+	flag.StringVar(&c.JWKSFile, "auth-jwks-file", getEnv("AUTH_JWKS_FILE", ""), "JWKS file of the keys bearer tokens are signed with, for jwt authentication")
+	flag.StringVar(&c.JWTIssuer, "auth-jwt-issuer", getEnv("AUTH_JWT_ISSUER", ""), "Issuer bearer tokens must carry, any if empty")
+	flag.StringVar(&c.JWTAudience, "auth-jwt-audience", getEnv("AUTH_JWT_AUDIENCE", ""), "Audience bearer tokens must carry, any if empty")
+	flag.StringVar(&c.JWTPrincipalClaim, "auth-jwt-principal-claim", getEnv("AUTH_JWT_PRINCIPAL_CLAIM", "sub"), "Claim of bearer tokens naming the caller")
+	flag.StringVar(&c.APIKeyBucket, "auth-apikey-bucket", getEnv("AUTH_APIKEY_BUCKET", "API_KEYS"), "JetStream KV bucket of API keys, for apikey authentication")
+	flag.StringVar(&c.MTLSPrincipalField, "auth-mtls-principal-field", getEnv("AUTH_MTLS_PRINCIPAL_FIELD", "cn"), "Field of client certificates naming the caller: cn, dns, email or uri")
+	flag.BoolVar(&c.RBAC, "rbac", getEnvBool("RBAC", false), "Check the roles of authenticated callers against the access control list stored in the config bucket")
+	flag.StringVar(&c.RBACSuperusers, "rbac-superusers", getEnv("RBAC_SUPERUSERS", ""), "Comma-separated principals, such as basic:admin, allowed everything regardless of the access control list")
 	flag.BoolVar(&c.Debug, "debug", getEnvBool("DEBUG", false), "Enable debug logging")
 	flag.BoolVar(&c.TestMode, "test", getEnvBool("TEST_MODE", false), "Enable test mode with embedded NATS server")
 }
 
 type server struct {
 	cfg          config
+	nc           atomic.Pointer[nats.Conn] // connection to storage, once set up
 	js           nats.JetStreamContext
-	kvSchemas    nats.KeyValue
-	kvConfig     nats.KeyValue
+	kvSchemas    schema.Store
+	kvConfig     schema.Store
+	apiKeys      *auth.APIKeys // bound to its bucket once storage is set up, if enabled
+	natsOptions  []nats.Option // credentials and TLS options of an external NATS server
 	http         *http.Server
 	natsServer   *natsd.Server
 	embeddedNATS bool
@@ -69,28 +160,78 @@ func main() {
 
 	slog.Info("Starting schema registry server", "config", cfg)
 
+	if cfg.Storage != storageNATS && cfg.Storage != storageStandalone {
+		slog.Error("Invalid storage mode", "storage", cfg.Storage)
+		os.Exit(1)
+	}
+	if cfg.Policy != policyRequire && cfg.Policy != policyMemoryAllowed {
+		slog.Error("Invalid storage policy", "policy", cfg.Policy)
+		os.Exit(1)
+	}
+
+	shutdownTracing, err := setupTracing(cfg)
+	if err != nil {
+		slog.Error("Failed to setup tracing", "error", err)
+		os.Exit(1)
+	}
+
 	srv := newServer(cfg)
+	if srv.http.TLSConfig, err = setupTLS(cfg); err != nil {
+		slog.Error("Failed to setup TLS", "error", err)
+		os.Exit(1)
+	}
+	if srv.apiKeys, err = setupAuth(cfg); err != nil {
+		slog.Error("Failed to setup authentication", "error", err)
+		os.Exit(1)
+	}
+	if srv.natsOptions, err = natsAuthOptions(cfg); err != nil {
+		slog.Error("Failed to setup NATS credentials", "error", err)
+		os.Exit(1)
+	}
+	rest.SetConnectionStatus(srv.connectionStatus)
 	if err := srv.setup(); err != nil {
-		slog.Error("Failed to setup server", "error", err)
-		// Continue with HTTP server even if NATS setup fails
-		slog.Warn("Continuing with limited functionality (no persistent storage)")
+		slog.Error("Failed to setup storage", "error", err)
+
+		// Serve what the storage policy allows until storage comes back
+		if cfg.Policy == policyMemoryAllowed {
+			slog.Warn("Serving an empty in-memory registry, writes are rejected until storage is available")
+			rest.Init(schema.NewMemoryStore(cfg.SchemaBucket), schema.NewMemoryStore(cfg.ConfigBucket))
+		} else {
+			rest.Init(nil, nil)
+		}
+		go srv.reconnect()
+	} else {
+		// Initialize REST handlers with schema registry
+		rest.Init(srv.kvSchemas, srv.kvConfig)
 	}
 
-	// Initialize REST handlers with schema registry
-	rest.Init(srv.kvSchemas, srv.kvConfig)
-
 	go func() {
-		slog.Info("HTTP server listening", "addr", cfg.HTTPAddr)
-		if err := srv.http.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Info("HTTP server listening", "addr", cfg.HTTPAddr, "tls", srv.http.TLSConfig != nil)
+		var err error
+		if srv.http.TLSConfig != nil {
+			// The certificate is served by the TLS config
+			err = srv.http.ListenAndServeTLS("", "")
+		} else {
+			err = srv.http.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			slog.Error("HTTP server error", "error", err)
 			os.Exit(1)
 		}
 	}()
 
 	srv.gracefulShutdown(5 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush spans", "error", err)
+	}
 }
 
-func (s *server) startEmbeddedNATS() error {
+// startTestNATS starts an embedded NATS server listening on the default port,
+// with its data in a temporary directory
+func (s *server) startTestNATS() error {
 	slog.Info("Starting embedded NATS server for testing")
 
 	tmpDir, err := os.MkdirTemp("", "nats-data-*")
@@ -105,11 +246,36 @@ func (s *server) startEmbeddedNATS() error {
 		StoreDir:   tmpDir,
 		MaxPayload: 8 * 1024 * 1024, // 8MB
 	}
+	if err := s.startEmbeddedNATS(opts); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	return nil
+}
+
+// startStandaloneNATS starts an embedded NATS server that only accepts
+// in-process connections and keeps its data in the configured directory, so
+// that it survives restarts
+func (s *server) startStandaloneNATS() error {
+	slog.Info("Starting embedded NATS server", "dataDir", s.cfg.DataDir)
+
+	if err := os.MkdirAll(s.cfg.DataDir, 0o750); err != nil {
+		return fmt.Errorf("create data directory: %w", err)
+	}
 
+	return s.startEmbeddedNATS(&natsd.Options{
+		JetStream:  true,
+		DontListen: true,
+		StoreDir:   s.cfg.DataDir,
+		MaxPayload: 8 * 1024 * 1024, // 8MB
+	})
+}
+
+// startEmbeddedNATS starts an embedded NATS server and waits for JetStream
+func (s *server) startEmbeddedNATS(opts *natsd.Options) error {
 	// Create the server
 	ns, err := natsd.NewServer(opts)
 	if err != nil {
-		os.RemoveAll(tmpDir)
 		return fmt.Errorf("create embedded NATS server: %w", err)
 	}
 
@@ -118,7 +284,7 @@ func (s *server) startEmbeddedNATS() error {
 
 	// Wait for server to be ready
 	if !ns.ReadyForConnections(5 * time.Second) {
-		os.RemoveAll(tmpDir)
+		ns.Shutdown()
 		return fmt.Errorf("embedded NATS server failed to start")
 	}
 
@@ -132,7 +298,7 @@ func (s *server) startEmbeddedNATS() error {
 	}
 
 	if !ns.JetStreamEnabled() {
-		os.RemoveAll(tmpDir)
+		ns.Shutdown()
 		return fmt.Errorf("JetStream failed to start")
 	}
 
@@ -143,30 +309,53 @@ func (s *server) startEmbeddedNATS() error {
 	return nil
 }
 
-func (s *server) setup() error {
+// connect connects to the NATS server of the storage mode, starting it first
+// when it is embedded
+func (s *server) connect() (*nats.Conn, error) {
+	if s.cfg.Storage == storageStandalone {
+		if err := s.startStandaloneNATS(); err != nil {
+			return nil, fmt.Errorf("start embedded NATS server: %w", err)
+		}
+		nc, err := nats.Connect("",
+			nats.Name("Schema Registry"),
+			nats.InProcessServer(s.natsServer),
+			nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+				slog.Error("NATS error", "error", err)
+			}),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("connect to embedded NATS: %w", err)
+		}
+		return nc, nil
+	}
+
 	slog.Debug("Connecting to NATS", "url", s.cfg.NATSURL)
 
 	// Connect to NATS with more options for better error messages
-	nc, err := nats.Connect(s.cfg.NATSURL,
+	opts := append([]nats.Option{
 		nats.Name("Schema Registry"),
-		nats.Timeout(5*time.Second),
+		nats.Timeout(5 * time.Second),
 		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
 			slog.Error("NATS error", "error", err)
 		}),
+		nats.MaxReconnects(-1),
 		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
-			slog.Error("NATS disconnected", "error", err)
+			slog.Error("NATS disconnected, registry is read-only", "error", err)
+			rest.SetStorageAvailable(false)
 		}),
 		nats.ReconnectHandler(func(_ *nats.Conn) {
 			slog.Info("NATS reconnected")
+			rest.SetStorageAvailable(true)
 		}),
-	)
+	}, s.natsOptions...)
+	nc, err := nats.Connect(s.cfg.NATSURL, opts...)
 
 	// If connection fails and test mode is enabled, start embedded NATS server
 	if err != nil && s.cfg.TestMode {
 		slog.Info("Failed to connect to external NATS server, starting embedded server")
 
-		if err := s.startEmbeddedNATS(); err != nil {
-			return fmt.Errorf("start embedded NATS server: %w", err)
+		if err := s.startTestNATS(); err != nil {
+			return nil, fmt.Errorf("start embedded NATS server: %w", err)
 		}
 
 		// Try to connect to the embedded server
@@ -179,14 +368,50 @@ func (s *server) setup() error {
 		)
 
 		if err != nil {
-			return fmt.Errorf("connect to embedded NATS: %w", err)
+			return nil, fmt.Errorf("connect to embedded NATS: %w", err)
 		}
 	} else if err != nil {
-		return fmt.Errorf("connect to NATS: %w", err)
+		return nil, fmt.Errorf("connect to NATS: %w", err)
 	}
 
+	return nc, nil
+}
+
+// reconnect retries setting up storage until it succeeds, then binds the REST
+// handlers to it
+func (s *server) reconnect() {
+	for {
+		time.Sleep(reconnectInterval)
+		if err := s.setup(); err != nil {
+			slog.Warn("Storage still unavailable", "error", err)
+			continue
+		}
+
+		slog.Info("Storage available, binding registry")
+		rest.Init(s.kvSchemas, s.kvConfig)
+		return
+	}
+}
+
+func (s *server) setup() (err error) {
+	nc, err := s.connect()
+	if err != nil {
+		return err
+	}
 	slog.Info("Connected to NATS")
 
+	// Don't leave a connection or server behind if the buckets cannot be set up
+	defer func() {
+		if err == nil {
+			return
+		}
+		nc.Close()
+		if s.embeddedNATS {
+			s.natsServer.Shutdown()
+			s.natsServer, s.embeddedNATS = nil, false
+		}
+	}()
+
 	// Create JetStream context
 	slog.Debug("Creating JetStream context")
 	s.js, err = nc.JetStream(nats.PublishAsyncMaxPending(256))
@@ -222,22 +447,49 @@ func (s *server) setup() error {
 		break
 	}
 
+	if s.apiKeys != nil {
+		slog.Debug("Setting up API key bucket", "name", s.cfg.APIKeyBucket)
+		apiKeys, err := s.makeBucket(s.cfg.APIKeyBucket, "API keys")
+		if err != nil {
+			return fmt.Errorf("create API key bucket: %w", err)
+		}
+		s.apiKeys.Bind(apiKeys)
+	}
+
+	s.nc.Store(nc)
 	slog.Info("NATS setup completed successfully")
 	return nil
 }
 
-func (s *server) makeBucket(name, desc string) (nats.KeyValue, error) {
+// connectionStatus reports the state of the connection to NATS
+func (s *server) connectionStatus() rest.ConnectionStatus {
+	status := rest.ConnectionStatus{Storage: s.cfg.Storage, State: nats.DISCONNECTED.String()}
+	nc := s.nc.Load()
+	if nc == nil {
+		return status
+	}
+	status.State = nc.Status().String()
+	status.URL = nc.ConnectedUrlRedacted()
+	status.ServerID = nc.ConnectedServerId()
+	status.Reconnects = nc.Stats().Reconnects
+	return status
+}
+
+func (s *server) makeBucket(name, desc string) (schema.Store, error) {
 	kv, err := s.js.KeyValue(name)
 	if err == nats.ErrBucketNotFound {
 		slog.Debug("Bucket not found, creating", "name", name)
-		return s.js.CreateKeyValue(&nats.KeyValueConfig{
+		kv, err = s.js.CreateKeyValue(&nats.KeyValueConfig{
 			Bucket:      name,
 			Description: desc,
 			Storage:     nats.FileStorage,
 			History:     5,
 		})
 	}
-	return kv, err
+	if err != nil {
+		return nil, err
+	}
+	return schema.NewJetStreamStore(kv), nil
 }
 
 func (s *server) gracefulShutdown(timeout time.Duration) {
@@ -267,6 +519,15 @@ func getEnv(key, def string) string {
 	return def
 }
 
+func getEnvFloat(key string, def float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
 func getEnvBool(key string, def bool) bool {
 	if v := os.Getenv(key); v != "" {
 		return v == "true" || v == "1" || v == "yes"
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"schemaregistry/internal/schema"
+	"schemaregistry/internal/schema/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startStandalone sets up the storage of a server in standalone mode and
+// returns a registry over it
+func startStandalone(t *testing.T, cfg config) (*server, *schema.Registry) {
+	t.Helper()
+	s := newServer(cfg)
+	require.NoError(t, s.setup())
+
+	registry := schema.New(s.kvSchemas, s.kvConfig)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, registry.WaitReady(ctx))
+	return s, registry
+}
+
+// stopStandalone closes a registry and shuts the embedded NATS server down
+func stopStandalone(s *server, registry *schema.Registry) {
+	registry.Close()
+	s.nc.Load().Close()
+	s.natsServer.Shutdown()
+	s.natsServer.WaitForShutdown()
+}
+
+func TestStandalone_PersistsAcrossRestarts(t *testing.T) {
+	cfg := config{
+		Storage:      storageStandalone,
+		DataDir:      t.TempDir(),
+		SchemaBucket: "schemas",
+		ConfigBucket: "config",
+	}
+	schemaStr := `{"type": "object", "properties": {"name": {"type": "string"}}}`
+
+	s, registry := startStandalone(t, cfg)
+	id, err := registry.RegisterSchema(t.Context(), "orders", schemaStr, types.JSON, nil, false)
+	require.NoError(t, err)
+	stopStandalone(s, registry)
+
+	// A server restarted on the same directory serves what was registered before
+	s, registry = startStandalone(t, cfg)
+	defer stopStandalone(s, registry)
+
+	stored, err := registry.GetSchema(t.Context(), id)
+	require.NoError(t, err)
+	assert.Equal(t, schemaStr, stored.Schema)
+
+	stored, err = registry.GetSchemaBySubjectVersion(t.Context(), "orders", "1", false)
+	require.NoError(t, err)
+	assert.Equal(t, id, stored.ID)
+	assert.Equal(t, schemaStr, stored.Schema)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsAuthOptions returns the options authenticating the registry to an
+// external NATS server and securing the connection to it. A tls:// URL or
+// any TLS file turns TLS on.
+func natsAuthOptions(cfg config) ([]nats.Option, error) {
+	var opts []nats.Option
+	methods := 0
+	if cfg.NATSCredsFile != "" {
+		methods++
+		opts = append(opts, nats.UserCredentials(cfg.NATSCredsFile))
+	}
+	if cfg.NATSNKeyFile != "" {
+		methods++
+		opt, err := nats.NkeyOptionFromSeed(cfg.NATSNKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load NATS nkey seed: %w", err)
+		}
+		opts = append(opts, opt)
+	}
+	if cfg.NATSUser != "" {
+		methods++
+		opts = append(opts, nats.UserInfo(cfg.NATSUser, cfg.NATSPassword))
+	}
+	if methods > 1 {
+		return nil, fmt.Errorf("only one of a NATS creds file, nkey seed file or user can be set")
+	}
+
+	if cfg.NATSTLSCAFile != "" {
+		opts = append(opts, nats.RootCAs(cfg.NATSTLSCAFile))
+	}
+	switch {
+	case cfg.NATSTLSCertFile != "" && cfg.NATSTLSKeyFile != "":
+		opts = append(opts, nats.ClientCert(cfg.NATSTLSCertFile, cfg.NATSTLSKeyFile))
+	case cfg.NATSTLSCertFile != "" || cfg.NATSTLSKeyFile != "":
+		return nil, fmt.Errorf("a NATS client certificate requires both a certificate and a key file")
+	}
+
+	if len(opts) > 0 {
+		slog.Info("Securing NATS connection", "creds", cfg.NATSCredsFile != "", "nkey", cfg.NATSNKeyFile != "", "user", cfg.NATSUser, "tls", cfg.NATSTLSCAFile != "" || cfg.NATSTLSCertFile != "")
+	}
+	return opts, nil
+}
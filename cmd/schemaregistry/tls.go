@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// Client certificate modes of the HTTPS listener
+const (
+	clientAuthNone    = "none"    // client certificates are not requested
+	clientAuthRequest = "request" // client certificates are verified if sent
+	clientAuthRequire = "require" // connections without a valid client certificate are refused
+)
+
+// tlsVersions maps the accepted minimum TLS versions to their identifiers
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certReloader holds the certificate and client CAs of the HTTPS listener,
+// reloading them from their files on SIGHUP
+type certReloader struct {
+	cfg        config
+	minVersion uint16
+	clientAuth tls.ClientAuthType
+	current    atomic.Pointer[tls.Config]
+}
+
+// setupTLS returns the TLS config of the HTTP listener, or nil if no
+// certificate is configured. The certificate and client CAs are reloaded from
+// their files on SIGHUP, so that they can be renewed without a restart.
+func setupTLS(cfg config) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		if cfg.TLSClientCAFile != "" {
+			return nil, fmt.Errorf("a client CA requires a TLS certificate and key")
+		}
+		return nil, nil
+	}
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, fmt.Errorf("TLS requires both a certificate and a key file")
+	}
+
+	minVersion, ok := tlsVersions[cfg.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported minimum TLS version %q", cfg.TLSMinVersion)
+	}
+	r := &certReloader{cfg: cfg, minVersion: minVersion}
+	switch cfg.TLSClientAuth {
+	case "":
+		// Verify the certificates of clients sending one once there are CAs to verify them with
+		if cfg.TLSClientCAFile != "" {
+			r.clientAuth = tls.VerifyClientCertIfGiven
+		}
+	case clientAuthNone:
+	case clientAuthRequest:
+		r.clientAuth = tls.VerifyClientCertIfGiven
+	case clientAuthRequire:
+		r.clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown client certificate mode %q", cfg.TLSClientAuth)
+	}
+	if r.clientAuth != tls.NoClientCert && cfg.TLSClientCAFile == "" {
+		return nil, fmt.Errorf("verifying client certificates requires a client CA file")
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	go r.watch()
+
+	slog.Info("TLS enabled", "minVersion", cfg.TLSMinVersion, "clientAuth", r.clientAuth.String())
+	return &tls.Config{
+		MinVersion: minVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}, nil
+}
+
+// load reads the certificate and client CAs from their files
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.cfg.TLSCertFile, r.cfg.TLSKeyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   r.minVersion,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   r.clientAuth,
+	}
+
+	if r.cfg.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.TLSClientCAFile)
+		if err != nil {
+			return fmt.Errorf("load client CA: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("client CA file %s holds no PEM certificates", r.cfg.TLSClientCAFile)
+		}
+	}
+
+	r.current.Store(config)
+	return nil
+}
+
+// watch reloads the certificate and client CAs on SIGHUP. Files that cannot
+// be loaded leave the current ones in use.
+func (r *certReloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := r.load(); err != nil {
+			slog.Error("Failed to reload TLS certificate, keeping the current one", "error", err)
+			continue
+		}
+		slog.Info("TLS certificate reloaded", "certFile", r.cfg.TLSCertFile)
+	}
+}
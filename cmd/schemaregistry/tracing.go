@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Tracing exporters
+const (
+	tracingNone   = "none"   // spans are not recorded
+	tracingOTLP   = "otlp"   // spans are sent to an OTLP/HTTP collector
+	tracingStdout = "stdout" // spans are written as JSON to stdout or TracingFile
+)
+
+// setupTracing installs the tracer provider of the configured exporter and the
+// W3C trace context propagator. It returns the function flushing the spans
+// left on shutdown.
+func setupTracing(cfg config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var closeOutput func() error
+	switch cfg.Tracing {
+	case tracingNone:
+		return func(context.Context) error { return nil }, nil
+	case tracingOTLP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.OTLPEndpoint)}
+		if cfg.OTLPInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		var err error
+		if exporter, err = otlptracehttp.New(context.Background(), opts...); err != nil {
+			return nil, fmt.Errorf("create OTLP exporter: %w", err)
+		}
+	case tracingStdout:
+		var out io.Writer = os.Stdout
+		if cfg.TracingFile != "" {
+			f, err := os.OpenFile(cfg.TracingFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+			if err != nil {
+				return nil, fmt.Errorf("open tracing file: %w", err)
+			}
+			out, closeOutput = f, f.Close
+		}
+		var err error
+		if exporter, err = stdouttrace.New(stdouttrace.WithWriter(out)); err != nil {
+			return nil, fmt.Errorf("create stdout exporter: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Tracing)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("schemaregistry"))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeOutput != nil {
+			closeOutput()
+		}
+		return err
+	}, nil
+}
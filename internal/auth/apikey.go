@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync/atomic"
+
+	"schemaregistry/internal/schema"
+)
+
+// APIKeyRecord is the value stored under the ID of an API key. Only the
+// SHA-256 of the secret is stored; secrets are expected to be long random
+// strings, unlike the passwords of an htpasswd file.
+type APIKeyRecord struct {
+	Principal    string `json:"principal"`
+	SecretSHA256 string `json:"secretSha256"` // hex encoded
+	Disabled     bool   `json:"disabled,omitempty"`
+}
+
+// apiKeyIDPattern matches the IDs that can be keys of a bucket
+var apiKeyIDPattern = regexp.MustCompile(`^[-_=.a-zA-Z0-9]+$`)
+
+// APIKeys authenticates API keys sent as HTTP Basic credentials, the key ID
+// as user and its secret as password, against the records of a bucket
+type APIKeys struct {
+	store atomic.Pointer[schema.Store]
+}
+
+// NewAPIKeys creates an authenticator whose keys are read once a bucket is bound
+func NewAPIKeys() *APIKeys {
+	return &APIKeys{}
+}
+
+// Bind sets the bucket the keys are read from
+func (a *APIKeys) Bind(store schema.Store) {
+	a.store.Store(&store)
+}
+
+// HashSecret returns the hash of a secret as stored in an APIKeyRecord
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate checks the API key of a request. Unknown key IDs are left to
+// the other authenticators.
+func (a *APIKeys) Authenticate(r *http.Request) (*Principal, error) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || !apiKeyIDPattern.MatchString(id) {
+		return nil, ErrNoCredentials
+	}
+	store := a.store.Load()
+	if store == nil {
+		return nil, schema.ErrStorageUnavailable
+	}
+
+	entry, err := (*store).Get(id)
+	if errors.Is(err, schema.ErrKeyNotFound) {
+		return nil, ErrNoCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get API key: %w", err)
+	}
+	var record APIKeyRecord
+	if err := json.Unmarshal(entry.Value, &record); err != nil {
+		return nil, fmt.Errorf("parse API key %s: %w", id, err)
+	}
+
+	if record.Disabled || subtle.ConstantTimeCompare([]byte(HashSecret(secret)), []byte(record.SecretSHA256)) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Name: record.Principal, Method: "apikey"}, nil
+}
+
+// Scheme returns Basic
+func (a *APIKeys) Scheme() string {
+	return "Basic"
+}
@@ -0,0 +1,86 @@
+// Package auth identifies the callers of the REST API from the credentials
+// their requests carry.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrNoCredentials is returned when a request carries no credentials an authenticator handles
+	ErrNoCredentials = errors.New("credentials required")
+	// ErrInvalidCredentials is returned when the credentials of a request are wrong
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is an authenticated caller
+type Principal struct {
+	Name   string // user name, token subject, API key owner or client certificate name
+	Method string // authenticator that identified the caller
+}
+
+// ID returns the name of a principal qualified with its method, such as
+// jwt:alice, that grants and superusers are matched against. Callers of
+// different methods sharing a name are different principals.
+func (p *Principal) ID() string {
+	return p.Method + ":" + p.Name
+}
+
+// Authenticator identifies the caller of a request
+type Authenticator interface {
+	// Authenticate returns the principal of the request's credentials. It
+	// returns ErrNoCredentials if the request carries none it handles, and
+	// ErrInvalidCredentials if they are wrong.
+	Authenticate(r *http.Request) (*Principal, error)
+
+	// Scheme returns the HTTP authentication scheme of the credentials, such
+	// as Basic, or an empty string if they are not sent in HTTP headers
+	Scheme() string
+}
+
+// Chain tries authenticators in order, until one of them handles the
+// credentials of a request
+type Chain []Authenticator
+
+// Authenticate returns the principal of the first authenticator handling the
+// request's credentials
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		principal, err := a.Authenticate(r)
+		if !errors.Is(err, ErrNoCredentials) {
+			return principal, err
+		}
+	}
+	if r.Header.Get("Authorization") != "" {
+		return nil, ErrInvalidCredentials
+	}
+	return nil, ErrNoCredentials
+}
+
+// Schemes returns the authentication schemes of the chain, each once
+func (c Chain) Schemes() []string {
+	var schemes []string
+	seen := map[string]bool{}
+	for _, a := range c {
+		if scheme := a.Scheme(); scheme != "" && !seen[scheme] {
+			seen[scheme] = true
+			schemes = append(schemes, scheme)
+		}
+	}
+	return schemes
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the caller of a request
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the caller carried by a context, nil if there is none
+func PrincipalFrom(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"schemaregistry/internal/schema"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// request returns a request carrying an Authorization header, if not empty
+func request(authorization string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/subjects", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	return r
+}
+
+// basic returns a request carrying Basic credentials
+func basic(user, password string) *http.Request {
+	r := request("")
+	r.SetBasicAuth(user, password)
+	return r
+}
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(path, []byte("# users\nalice:"+string(hash)+"\n"), 0o600))
+
+	htpasswd, err := LoadHtpasswd(path)
+	require.NoError(t, err)
+
+	principal, err := htpasswd.Authenticate(basic("alice", "secret"))
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "alice", Method: "basic"}, principal)
+
+	_, err = htpasswd.Authenticate(basic("alice", "wrong"))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = htpasswd.Authenticate(basic("bob", "secret"))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+	_, err = htpasswd.Authenticate(request(""))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	// Only bcrypt hashes are accepted
+	require.NoError(t, os.WriteFile(path, []byte("alice:plain\n"), 0o600))
+	_, err = LoadHtpasswd(path)
+	assert.Error(t, err)
+}
+
+func TestJWT(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kid": "test",
+		"kty": "OKP",
+		"crv": "Ed25519",
+		"x":   base64.RawURLEncoding.EncodeToString(public),
+	}}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwks, 0o600))
+
+	authenticator, err := LoadJWKS(path, JWTOptions{Issuer: "issuer", Audience: "registry"})
+	require.NoError(t, err)
+
+	sign := func(claims jwt.MapClaims, key ed25519.PrivateKey) *http.Request {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return request("Bearer " + signed)
+	}
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub": "ci",
+			"iss": "issuer",
+			"aud": "registry",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	principal, err := authenticator.Authenticate(sign(valid(), private))
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "ci", Method: "jwt"}, principal)
+
+	expired := valid()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	_, err = authenticator.Authenticate(sign(expired, private))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	otherAudience := valid()
+	otherAudience["aud"] = "other"
+	_, err = authenticator.Authenticate(sign(otherAudience, private))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = authenticator.Authenticate(sign(valid(), other))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	_, err = authenticator.Authenticate(basic("ci", "secret"))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestAPIKeys(t *testing.T) {
+	apiKeys := NewAPIKeys()
+	_, err := apiKeys.Authenticate(basic("key1", "secret"))
+	assert.ErrorIs(t, err, schema.ErrStorageUnavailable, "keys cannot be checked before a bucket is bound")
+
+	store := schema.NewMemoryStore("API_KEYS")
+	apiKeys.Bind(store)
+	record, err := json.Marshal(APIKeyRecord{Principal: "billing", SecretSHA256: HashSecret("secret")})
+	require.NoError(t, err)
+	_, err = store.Put("key1", record)
+	require.NoError(t, err)
+
+	principal, err := apiKeys.Authenticate(basic("key1", "secret"))
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "billing", Method: "apikey"}, principal)
+
+	_, err = apiKeys.Authenticate(basic("key1", "wrong"))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = apiKeys.Authenticate(basic("key2", "secret"))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+	_, err = apiKeys.Authenticate(basic("not a key", "secret"))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestChain(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	htpasswd := &Htpasswd{users: map[string][]byte{"alice": hash}}
+	apiKeys := NewAPIKeys()
+	store := schema.NewMemoryStore("API_KEYS")
+	apiKeys.Bind(store)
+	record, err := json.Marshal(APIKeyRecord{Principal: "billing", SecretSHA256: HashSecret("key-secret")})
+	require.NoError(t, err)
+	_, err = store.Put("key1", record)
+	require.NoError(t, err)
+
+	chain := Chain{htpasswd, apiKeys}
+	assert.Equal(t, []string{"Basic"}, chain.Schemes())
+
+	// Credentials unknown to the first authenticator are passed on
+	principal, err := chain.Authenticate(basic("key1", "key-secret"))
+	require.NoError(t, err)
+	assert.Equal(t, "billing", principal.Name)
+
+	// Principals of different methods sharing a name are told apart
+	record, err = json.Marshal(APIKeyRecord{Principal: "alice", SecretSHA256: HashSecret("key-secret")})
+	require.NoError(t, err)
+	_, err = store.Put("key2", record)
+	require.NoError(t, err)
+	byKey, err := chain.Authenticate(basic("key2", "key-secret"))
+	require.NoError(t, err)
+	byPassword, err := chain.Authenticate(basic("alice", "secret"))
+	require.NoError(t, err)
+	assert.Equal(t, "apikey:alice", byKey.ID())
+	assert.Equal(t, "basic:alice", byPassword.ID())
+
+	_, err = chain.Authenticate(basic("alice", "wrong"))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = chain.Authenticate(basic("nobody", "secret"))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = chain.Authenticate(request(""))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestClientCert(t *testing.T) {
+	spiffe, err := url.Parse("spiffe://example.org/billing")
+	require.NoError(t, err)
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "billing-service"},
+		DNSNames:       []string{"billing.example.org"},
+		EmailAddresses: []string{"billing@example.org"},
+		URIs:           []*url.URL{spiffe},
+	}
+	verified := request("")
+	verified.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+	for field, name := range map[string]string{
+		"":                  "billing-service",
+		CertFieldCommonName: "billing-service",
+		CertFieldDNS:        "billing.example.org",
+		CertFieldEmail:      "billing@example.org",
+		CertFieldURI:        "spiffe://example.org/billing",
+	} {
+		authenticator, err := NewClientCert(field)
+		require.NoError(t, err)
+		principal, err := authenticator.Authenticate(verified)
+		require.NoError(t, err)
+		assert.Equal(t, &Principal{Name: name, Method: "mtls"}, principal)
+	}
+
+	_, err = NewClientCert("serial")
+	assert.Error(t, err)
+
+	authenticator, err := NewClientCert(CertFieldCommonName)
+	require.NoError(t, err)
+	_, err = authenticator.Authenticate(request(""))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	// Certificates the listener did not verify are ignored
+	unverified := request("")
+	unverified.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	_, err = authenticator.Authenticate(unverified)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	anonymous := request("")
+	anonymous.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	_, err = authenticator.Authenticate(anonymous)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// Certificates have no HTTP scheme to challenge clients with
+	assert.Equal(t, []string{"Basic"}, Chain{authenticator, NewAPIKeys()}.Schemes())
+}
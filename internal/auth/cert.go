@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+)
+
+// Fields of a client certificate a principal can be named after
+const (
+	CertFieldCommonName = "cn"    // common name of the subject
+	CertFieldDNS        = "dns"   // first DNS subject alternative name
+	CertFieldEmail      = "email" // first email subject alternative name
+	CertFieldURI        = "uri"   // first URI subject alternative name, such as a SPIFFE ID
+)
+
+// ClientCert authenticates the client certificates of TLS connections. The
+// certificates must have been verified by the listener against its client CAs.
+type ClientCert struct {
+	field string
+}
+
+// NewClientCert creates an authenticator naming principals after a field of
+// their certificate, the common name if empty
+func NewClientCert(field string) (*ClientCert, error) {
+	switch field {
+	case "":
+		field = CertFieldCommonName
+	case CertFieldCommonName, CertFieldDNS, CertFieldEmail, CertFieldURI:
+	default:
+		return nil, fmt.Errorf("unknown certificate field %q", field)
+	}
+	return &ClientCert{field: field}, nil
+}
+
+// Authenticate returns the principal of the verified client certificate of a
+// request. Connections without one are left to the other authenticators.
+func (a *ClientCert) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	name := certName(r.TLS.VerifiedChains[0][0], a.field)
+	if name == "" {
+		return nil, fmt.Errorf("%w: client certificate has no %s", ErrInvalidCredentials, a.field)
+	}
+	return &Principal{Name: name, Method: "mtls"}, nil
+}
+
+// certName returns a field of a certificate, or an empty string
+func certName(cert *x509.Certificate, field string) string {
+	switch field {
+	case CertFieldDNS:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case CertFieldEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case CertFieldURI:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	default:
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+// Scheme returns an empty string, certificates are not sent over HTTP
+func (a *ClientCert) Scheme() string {
+	return ""
+}
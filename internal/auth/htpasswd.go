@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd authenticates HTTP Basic credentials against a file of users and
+// bcrypt password hashes, in the format written by htpasswd -B
+type Htpasswd struct {
+	users map[string][]byte // user -> bcrypt hash
+}
+
+// LoadHtpasswd reads an htpasswd file. Blank lines and lines starting with #
+// are ignored.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := &Htpasswd{users: make(map[string][]byte)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", path, line)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: password of %s is not a bcrypt hash", path, line, user)
+		}
+		h.users[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Authenticate checks the Basic credentials of a request. Unknown users are
+// left to the other authenticators.
+func (h *Htpasswd) Authenticate(r *http.Request) (*Principal, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	hash, ok := h.users[user]
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Name: user, Method: "basic"}, nil
+}
+
+// Scheme returns Basic
+func (h *Htpasswd) Scheme() string {
+	return "Basic"
+}
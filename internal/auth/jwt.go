@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTOptions are the claims a token must carry to be accepted
+type JWTOptions struct {
+	Issuer         string // required issuer, any if empty
+	Audience       string // required audience, any if empty
+	PrincipalClaim string // claim naming the caller, sub if empty
+}
+
+// JWT authenticates bearer tokens signed with the keys of a JWKS file
+type JWT struct {
+	keys    map[string]any // key ID -> public key
+	opts    JWTOptions
+	methods []string // accepted signing algorithms
+}
+
+// jsonWebKey is a public key of a JWKS document
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`   // RSA modulus
+	E   string `json:"e"`   // RSA exponent
+	Crv string `json:"crv"` // EC or OKP curve
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKS reads the signing keys of a JWKS file. RSA, EC and Ed25519 keys
+// are supported; keys meant for encryption are ignored.
+func LoadJWKS(path string, opts JWTOptions) (*JWT, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse JWKS %s: %w", path, err)
+	}
+
+	if opts.PrincipalClaim == "" {
+		opts.PrincipalClaim = "sub"
+	}
+	j := &JWT{keys: make(map[string]any), opts: opts}
+	for i, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("parse JWKS %s: key %d: %w", path, i, err)
+		}
+		j.keys[k.Kid] = key
+	}
+	if len(j.keys) == 0 {
+		return nil, fmt.Errorf("JWKS %s has no signing keys", path)
+	}
+	j.methods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+	return j, nil
+}
+
+// publicKey decodes the public key of a JWK
+func (k jsonWebKey) publicKey() (any, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("modulus: %w", err)
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("exponent: %w", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %w", err)
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+// Authenticate checks the bearer token of a request
+func (j *JWT) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(j.methods), jwt.WithExpirationRequired()}
+	if j.opts.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(j.opts.Issuer))
+	}
+	if j.opts.Audience != "" {
+		opts = append(opts, jwt.WithAudience(j.opts.Audience))
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(strings.TrimSpace(token), claims, j.key, opts...); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	name, _ := claims[j.opts.PrincipalClaim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("%w: token has no %s claim", ErrInvalidCredentials, j.opts.PrincipalClaim)
+	}
+	return &Principal{Name: name, Method: "jwt"}, nil
+}
+
+// key returns the key a token was signed with. Tokens without a key ID are
+// accepted if the JWKS holds a single key.
+func (j *JWT) key(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// Scheme returns Bearer
+func (j *JWT) Scheme() string {
+	return "Bearer"
+}
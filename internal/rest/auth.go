@@ -0,0 +1,160 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"schemaregistry/internal/auth"
+	"schemaregistry/internal/schema"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authenticators identify the callers of the API. Requests are not
+// authenticated while it is empty.
+var authenticators atomic.Pointer[auth.Chain]
+
+// publicRoutes are served without authentication so that probes don't need credentials
+var publicRoutes = map[string]bool{
+	"/health":  true,
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// SetAuthenticators sets the authenticators tried in order on each request.
+// Without any, requests are not authenticated.
+func SetAuthenticators(authenticator ...auth.Authenticator) {
+	chain := auth.Chain(authenticator)
+	authenticators.Store(&chain)
+}
+
+// authMiddleware identifies the caller of a request and passes it on through
+// the request's context, rejecting requests without valid credentials with 401
+func authMiddleware(c *gin.Context) {
+	chain := authenticators.Load()
+	if chain == nil || len(*chain) == 0 || publicRoutes[c.FullPath()] {
+		c.Next()
+		return
+	}
+
+	principal, err := chain.Authenticate(c.Request)
+	switch {
+	case err == nil:
+		c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), principal))
+		c.Next()
+	case errors.Is(err, auth.ErrNoCredentials), errors.Is(err, auth.ErrInvalidCredentials):
+		for _, scheme := range chain.Schemes() {
+			c.Writer.Header().Add("WWW-Authenticate", scheme+` realm="schemaregistry"`)
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{ErrorCode: 40101, Message: "Unauthorized: " + err.Error()})
+	default:
+		respondError(c, err)
+		c.Abort()
+	}
+}
+
+// authorization holds the principals allowed everything regardless of the
+// ACL. It is nil while authorization is disabled.
+var authorization atomic.Pointer[map[string]bool]
+
+// SetAuthorization enables or disables checking the roles of callers against
+// the ACL of the bound registry. Superusers are allowed everything, which lets
+// them set up the first grants.
+func SetAuthorization(enabled bool, superusers ...string) {
+	if !enabled {
+		authorization.Store(nil)
+		return
+	}
+	names := make(map[string]bool, len(superusers))
+	for _, name := range superusers {
+		names[name] = true
+	}
+	authorization.Store(&names)
+}
+
+// authorizationEnabled reports whether the roles of callers are checked
+func authorizationEnabled() bool {
+	return authorization.Load() != nil
+}
+
+// permissions returns a function telling whether the caller of a request
+// holds a role on a subject, a context given as :.{context}: or "global".
+// Every caller holds every role while authorization is disabled. Without a
+// bound registry the ACL cannot be read, so only superusers hold any.
+func permissions(c *gin.Context, registry *schema.Registry) (func(subject string, role schema.Role) bool, error) {
+	superusers := authorization.Load()
+	if superusers == nil {
+		return func(string, schema.Role) bool { return true }, nil
+	}
+	principal := auth.PrincipalFrom(c.Request.Context())
+	if principal == nil {
+		return func(string, schema.Role) bool { return false }, nil
+	}
+	if (*superusers)[principal.ID()] {
+		return func(string, schema.Role) bool { return true }, nil
+	}
+	if registry == nil {
+		return func(string, schema.Role) bool { return false }, nil
+	}
+
+	acl, err := registry.GetACL(c.Request.Context())
+	if err != nil {
+		return nil, err
+	}
+	return func(subject string, role schema.Role) bool {
+		return acl.Allows(principal.ID(), subject, role)
+	}, nil
+}
+
+// authorize checks that the caller of a request holds a role on a subject,
+// a context or "global", answering 403 and returning false if not
+func authorize(c *gin.Context, registry *schema.Registry, subject string, role schema.Role) bool {
+	allowed, err := permissions(c, registry)
+	if err != nil {
+		respondError(c, err)
+		return false
+	}
+	if allowed(subject, role) {
+		return true
+	}
+
+	name := "anonymous"
+	if principal := auth.PrincipalFrom(c.Request.Context()); principal != nil {
+		name = principal.ID()
+	}
+	c.JSON(http.StatusForbidden, ErrorResponse{
+		ErrorCode: 40301,
+		Message:   fmt.Sprintf("Forbidden: %s does not hold role %s on subject %s", name, role, subject),
+	})
+	return false
+}
+
+// visibleContexts filters contexts down to those the caller of a request
+// holds grants in
+func visibleContexts(c *gin.Context, registry *schema.Registry, contexts []string) ([]string, error) {
+	superusers := authorization.Load()
+	if superusers == nil {
+		return contexts, nil
+	}
+	principal := auth.PrincipalFrom(c.Request.Context())
+	if principal == nil {
+		return []string{}, nil
+	}
+	if (*superusers)[principal.ID()] {
+		return contexts, nil
+	}
+
+	acl, err := registry.GetACL(c.Request.Context())
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]string, 0, len(contexts))
+	for _, context := range contexts {
+		if acl.AllowsContext(principal.ID(), context) {
+			visible = append(visible, context)
+		}
+	}
+	return visible, nil
+}
@@ -0,0 +1,66 @@
+package rest
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"schemaregistry/internal/schema"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorMapping maps a registry error to an HTTP status and Confluent error code
+type errorMapping struct {
+	err    error
+	status int
+	code   int
+}
+
+// errorMappings lists the registry errors in the order they are matched
+var errorMappings = []errorMapping{
+	{schema.ErrSubjectNotFound, http.StatusNotFound, 40401},
+	{schema.ErrModeNotFound, http.StatusNotFound, 40409},
+	{schema.ErrVersionNotFound, http.StatusNotFound, 40402},
+	{schema.ErrSchemaNotFound, http.StatusNotFound, 40403},
+	{schema.ErrSubjectSoftDeleted, http.StatusNotFound, 40404},
+	{schema.ErrSubjectNotSoftDeleted, http.StatusNotFound, 40405},
+	{schema.ErrVersionSoftDeleted, http.StatusNotFound, 40406},
+	{schema.ErrVersionNotSoftDeleted, http.StatusNotFound, 40407},
+	{schema.ErrIncompatible, http.StatusConflict, 40901},
+	{schema.ErrInvalidSchema, http.StatusUnprocessableEntity, 42201},
+	{schema.ErrReferenceNotFound, http.StatusUnprocessableEntity, 42201},
+	{schema.ErrInvalidVersion, http.StatusUnprocessableEntity, 42202},
+	{schema.ErrInvalidCompatibilityLevel, http.StatusUnprocessableEntity, 42203},
+	{schema.ErrInvalidMode, http.StatusUnprocessableEntity, 42204},
+	{schema.ErrOperationNotPermitted, http.StatusUnprocessableEntity, 42205},
+	{schema.ErrReferenceExists, http.StatusUnprocessableEntity, 42206},
+	{schema.ErrInvalidACL, http.StatusUnprocessableEntity, 42207},
+	{schema.ErrStorageUnavailable, http.StatusServiceUnavailable, 50300},
+}
+
+// errorStatus returns the HTTP status and error code for a registry error.
+// Unknown errors are reported as backend store failures.
+func errorStatus(err error) (int, int) {
+	for _, m := range errorMappings {
+		if errors.Is(err, m.err) {
+			return m.status, m.code
+		}
+	}
+	return http.StatusInternalServerError, 50001
+}
+
+// respondError writes the error response for a registry error
+func respondError(c *gin.Context, err error) {
+	status, code := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		slog.Error("Request failed", "path", c.FullPath(), "error", err)
+	}
+
+	response := ErrorResponse{ErrorCode: code, Message: err.Error()}
+	var compatErr *schema.CompatibilityError
+	if errors.As(err, &compatErr) {
+		response.Violations = compatErr.Violations
+	}
+	c.JSON(status, response)
+}
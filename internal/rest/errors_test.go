@@ -0,0 +1,32 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"schemaregistry/internal/schema"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   int
+	}{
+		{fmt.Errorf("%w: orders", schema.ErrSubjectNotFound), http.StatusNotFound, 40401},
+		{fmt.Errorf("%w: orders", schema.ErrModeNotFound), http.StatusNotFound, 40409},
+		{fmt.Errorf("%w: 7", schema.ErrSchemaNotFound), http.StatusNotFound, 40403},
+		{&schema.CompatibilityError{Subject: "orders"}, http.StatusConflict, 40901},
+		{schema.ErrStorageUnavailable, http.StatusServiceUnavailable, 50300},
+		{errors.New("connection reset"), http.StatusInternalServerError, 50001},
+	}
+	for _, tt := range tests {
+		status, code := errorStatus(tt.err)
+		assert.Equal(t, tt.status, status, tt.err.Error())
+		assert.Equal(t, tt.code, code, tt.err.Error())
+	}
+}
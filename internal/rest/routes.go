@@ -1,306 +1,82 @@
 package rest
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"strings"
-	"sync"
-	"time"
+	"slices"
+	"sync/atomic"
 
 	"schemaregistry/internal/schema"
 	"schemaregistry/internal/schema/types"
 
 	"github.com/gin-gonic/gin"
-	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var registry *schema.Registry
-var kvSchemas, kvConfig nats.KeyValue
+// bound holds the registry the handlers serve. It is nil while no storage is
+// available, in which case requests are answered with 503.
+var bound atomic.Pointer[schema.Registry]
 
-// MemoryKeyValue is a simple in-memory implementation of the nats.KeyValue interface
-// for fallback when NATS is not available
-type MemoryKeyValue struct {
-	name  string
-	data  map[string][]byte
-	mutex sync.RWMutex
-}
-
-// NewMemoryKeyValue creates a new in-memory KeyValue store
-func NewMemoryKeyValue(name string) *MemoryKeyValue {
-	return &MemoryKeyValue{
-		name: name,
-		data: make(map[string][]byte),
-	}
-}
-
-// Get retrieves a value for a key
-func (m *MemoryKeyValue) Get(key string) (nats.KeyValueEntry, error) {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
-
-	if val, ok := m.data[key]; ok {
-		return &MemoryKeyValueEntry{
-			key:   key,
-			value: val,
-		}, nil
-	}
-	return nil, nats.ErrKeyNotFound
-}
-
-// GetRevision returns a specific revision value for the key
-func (m *MemoryKeyValue) GetRevision(key string, revision uint64) (nats.KeyValueEntry, error) {
-	// In-memory implementation doesn't track revisions, so just return the current value
-	return m.Get(key)
-}
-
-// Put stores a value for a key
-func (m *MemoryKeyValue) Put(key string, value []byte) (uint64, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
-	m.data[key] = value
-	return 1, nil // Return a dummy revision
-}
-
-// PutString stores a string value for a key
-func (m *MemoryKeyValue) PutString(key string, value string) (uint64, error) {
-	return m.Put(key, []byte(value))
-}
-
-// Keys returns all keys in the store
-func (m *MemoryKeyValue) Keys(opts ...nats.WatchOpt) ([]string, error) {
-	slog.Debug("MemoryKeyValue.Keys called", "bucket", m.name)
-
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
-
-	// Initialize with empty slice to avoid returning nil
-	keys := make([]string, 0)
-
-	if len(m.data) == 0 {
-		slog.Debug("No keys in memory store", "bucket", m.name)
-		return keys, nil
-	}
-
-	// Add keys to the slice
-	for k := range m.data {
-		slog.Debug("Adding key", "key", k)
-		keys = append(keys, k)
-	}
-
-	slog.Debug("Returning keys", "count", len(keys), "bucket", m.name)
-	return keys, nil
-}
-
-// ListKeys returns all keys in the store via a channel
-func (m *MemoryKeyValue) ListKeys(opts ...nats.WatchOpt) (nats.KeyLister, error) {
-	// Not implemented for in-memory store
-	return nil, fmt.Errorf("list keys not implemented for in-memory store")
-}
+// inMemory is set while the bound registry keeps its records in memory
+var inMemory atomic.Bool
 
-// Create creates a new key with the given value
-func (m *MemoryKeyValue) Create(key string, value []byte) (uint64, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// Init binds the REST handlers to a registry keeping its records in the given
+// stores, replacing the registry bound before. If either store is nil the
+// handlers are left unbound until Init is called again with both. A registry
+// kept in memory is read-only, so that no write is lost when the storage is
+// bound in its place.
+func Init(schemas, config schema.Store) {
+	slog.Info("Initializing schema registry handlers")
 
-	if _, ok := m.data[key]; ok {
-		return 0, nats.ErrKeyExists
+	var registry *schema.Registry
+	if schemas == nil || config == nil {
+		slog.Error("Storage not available, requests are rejected until it is")
+	} else {
+		slog.Info("Using storage", "schemaBucket", schemas.Name(), "configBucket", config.Name())
+		registry = schema.New(schemas, config)
 	}
-
-	m.data[key] = value
-	return 1, nil // Return a dummy revision
-}
-
-// Update updates a key with the given value and revision
-func (m *MemoryKeyValue) Update(key string, value []byte, last uint64) (uint64, error) {
-	// In-memory implementation doesn't track revisions
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
-	if _, ok := m.data[key]; !ok {
-		return 0, nats.ErrKeyNotFound
+	_, memory := schemas.(*schema.MemoryStore)
+	inMemory.Store(memory)
+	if memory && registry != nil {
+		registry.SetDegraded(true)
 	}
 
-	m.data[key] = value
-	return last + 1, nil
-}
-
-// Delete deletes a key
-func (m *MemoryKeyValue) Delete(key string, opts ...nats.DeleteOpt) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
-	if _, ok := m.data[key]; !ok {
-		return nats.ErrKeyNotFound
+	if previous := bound.Swap(registry); previous != nil {
+		previous.Close()
 	}
-
-	delete(m.data, key)
-	return nil
-}
-
-// Purge purges a key
-func (m *MemoryKeyValue) Purge(key string, opts ...nats.DeleteOpt) error {
-	return m.Delete(key, opts...) // Same as delete for in-memory
-}
-
-// Watch watches for changes to keys
-func (m *MemoryKeyValue) Watch(keys string, opts ...nats.WatchOpt) (nats.KeyWatcher, error) {
-	// Not implemented for in-memory store
-	return nil, fmt.Errorf("watch not implemented for in-memory store")
-}
-
-// WatchAll watches for changes to all keys
-func (m *MemoryKeyValue) WatchAll(opts ...nats.WatchOpt) (nats.KeyWatcher, error) {
-	// Not implemented for in-memory store
-	return nil, fmt.Errorf("watch all not implemented for in-memory store")
-}
-
-// WatchFiltered watches for changes to keys matching the filter
-func (m *MemoryKeyValue) WatchFiltered(keys []string, opts ...nats.WatchOpt) (nats.KeyWatcher, error) {
-	// Not implemented for in-memory store
-	return nil, fmt.Errorf("watch filtered not implemented for in-memory store")
-}
-
-// History returns the history for a key
-func (m *MemoryKeyValue) History(key string, opts ...nats.WatchOpt) ([]nats.KeyValueEntry, error) {
-	// Not implemented for in-memory store
-	return nil, fmt.Errorf("history not implemented for in-memory store")
+	slog.Info("Schema registry handlers initialized successfully")
 }
 
-// Bucket returns the bucket name
-func (m *MemoryKeyValue) Bucket() string {
-	return m.name
-}
+// connectionStatus reads the state of the connection to the storage backend
+var connectionStatus atomic.Pointer[func() ConnectionStatus]
 
-// PurgeDeletes purges deleted keys
-func (m *MemoryKeyValue) PurgeDeletes(opts ...nats.PurgeOpt) error {
-	// Not implemented for in-memory store
-	return nil
+// SetConnectionStatus sets the function the status document reads the state
+// of the connection to the storage backend with
+func SetConnectionStatus(fn func() ConnectionStatus) {
+	connectionStatus.Store(&fn)
 }
 
-// Status returns the status of the bucket
-func (m *MemoryKeyValue) Status() (nats.KeyValueStatus, error) {
-	// Create a memory status implementation
-	status := &MemoryKeyValueStatus{
-		bucket:       m.name,
-		values:       uint64(len(m.data)),
-		backingStore: "Memory",
+// SetStorageAvailable reports whether the storage of the bound registry can be
+// reached. While it cannot, the registry is read-only.
+func SetStorageAvailable(available bool) {
+	if registry := bound.Load(); registry != nil && !inMemory.Load() {
+		registry.SetDegraded(!available)
 	}
-	return status, nil
-}
-
-// MemoryKeyValueEntry implements the KeyValueEntry interface for the in-memory store
-type MemoryKeyValueEntry struct {
-	key   string
-	value []byte
 }
 
-// Bucket returns the bucket name
-func (e *MemoryKeyValueEntry) Bucket() string {
-	return ""
-}
-
-// Key returns the key
-func (e *MemoryKeyValueEntry) Key() string {
-	return e.key
-}
-
-// Value returns the value
-func (e *MemoryKeyValueEntry) Value() []byte {
-	return e.value
-}
-
-// Revision returns the revision
-func (e *MemoryKeyValueEntry) Revision() uint64 {
-	return 1
-}
-
-// Created returns the creation time
-func (e *MemoryKeyValueEntry) Created() time.Time {
-	return time.Now()
-}
-
-// Delta returns the delta
-func (e *MemoryKeyValueEntry) Delta() uint64 {
-	return 0
-}
-
-// Operation returns the operation
-func (e *MemoryKeyValueEntry) Operation() nats.KeyValueOp {
-	return nats.KeyValuePut
-}
-
-// MemoryKeyValueStatus implements the KeyValueStatus interface
-type MemoryKeyValueStatus struct {
-	bucket       string
-	values       uint64
-	backingStore string
-}
-
-// Bucket returns the bucket name
-func (s *MemoryKeyValueStatus) Bucket() string {
-	return s.bucket
-}
-
-// Values returns the number of values in the bucket
-func (s *MemoryKeyValueStatus) Values() uint64 {
-	return s.values
-}
-
-// History returns the configured history kept per key
-func (s *MemoryKeyValueStatus) History() int64 {
-	return 1 // No history in memory implementation
-}
-
-// TTL returns how long the bucket keeps values for
-func (s *MemoryKeyValueStatus) TTL() time.Duration {
-	return 0 // No expiration in memory implementation
-}
-
-// BackingStore returns the technology used for storage
-func (s *MemoryKeyValueStatus) BackingStore() string {
-	return s.backingStore
-}
-
-// Bytes returns the size in bytes of the bucket
-func (s *MemoryKeyValueStatus) Bytes() uint64 {
-	return 0 // Not tracking bytes in memory implementation
-}
-
-// IsCompressed returns if the data is compressed
-func (s *MemoryKeyValueStatus) IsCompressed() bool {
-	return false // No compression in memory implementation
-}
-
-// Init initializes the REST handlers with the schema registry
-// If NATS is not available, it will use in-memory implementations
-func Init(schemas, config nats.KeyValue) {
-	slog.Info("Initializing schema registry handlers")
-
-	// If both KeyValue stores are nil, use in-memory fallbacks
-	if schemas == nil {
-		slog.Warn("Schema storage not available, using in-memory fallback")
-		schemas = NewMemoryKeyValue("SCHEMAS")
-	} else {
-		slog.Info("Using external schema storage", "bucket", schemas.Bucket())
-	}
-
-	if config == nil {
-		slog.Warn("Config storage not available, using in-memory fallback")
-		config = NewMemoryKeyValue("CONFIG")
-	} else {
-		slog.Info("Using external config storage", "bucket", config.Bucket())
+// boundRegistry returns the bound registry, or answers the request with 503
+// and returns nil if there is none
+func boundRegistry(c *gin.Context) *schema.Registry {
+	registry := bound.Load()
+	if registry == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			ErrorCode: 50300,
+			Message:   "storage backend unavailable",
+		})
 	}
-
-	kvSchemas = schemas
-	kvConfig = config
-
-	// Create registry with the storage
-	slog.Debug("Creating schema registry")
-	registry = schema.New(kvSchemas, kvConfig)
-
-	slog.Info("Schema registry handlers initialized successfully")
+	return registry
 }
 
 // SchemaRecord represents a stored schema record
@@ -314,9 +90,11 @@ type SchemaRecord struct {
 
 // SchemaRequest represents a schema registration request
 type SchemaRequest struct {
-	Schema     string                    `json:"schema"`
-	SchemaType string                    `json:"schemaType,omitempty"`
-	References []types.SchemaReference   `json:"references,omitempty"`
+	Schema     string                  `json:"schema"`
+	SchemaType string                  `json:"schemaType,omitempty"`
+	References []types.SchemaReference `json:"references,omitempty"`
+	ID         int                     `json:"id,omitempty"`
+	Version    int                     `json:"version,omitempty"`
 }
 
 // SchemaResponse returns the schema ID.
@@ -324,25 +102,92 @@ type SchemaResponse struct {
 	ID int `json:"id"`
 }
 
-// CompatibilityResponse indicates compatibility result.
+// CompatibilityResponse indicates compatibility result. Messages and
+// violations are only included for verbose requests.
 type CompatibilityResponse struct {
-	IsCompatible bool `json:"is_compatible"`
+	IsCompatible bool              `json:"is_compatible"`
+	Messages     []string          `json:"messages,omitempty"`
+	Violations   []types.Violation `json:"violations,omitempty"`
 }
 
-// ConfigRequest updates compatibility.
+// newCompatibilityResponse builds the response for a compatibility check
+func newCompatibilityResponse(violations []types.Violation, verbose bool) CompatibilityResponse {
+	resp := CompatibilityResponse{IsCompatible: len(violations) == 0}
+	if verbose {
+		for _, v := range violations {
+			resp.Messages = append(resp.Messages, v.String())
+		}
+		resp.Violations = violations
+	}
+	return resp
+}
+
+// ConfigRequest updates compatibility and normalization. Either may be left out.
 type ConfigRequest struct {
-	Compatibility string `json:"compatibility"`
+	Compatibility string `json:"compatibility,omitempty"`
+	Normalize     *bool  `json:"normalize,omitempty"`
 }
 
-// ConfigResponse returns compatibility.
+// ConfigResponse returns compatibility and normalization.
 type ConfigResponse struct {
 	CompatibilityLevel string `json:"compatibilityLevel"`
+	Normalize          bool   `json:"normalize,omitempty"`
+}
+
+// ModeRequest updates mode.
+type ModeRequest struct {
+	Mode string `json:"mode"`
+}
+
+// Storage states reported by the health endpoint
+const (
+	storageAvailable   = "available"   // records are persisted
+	storageMemory      = "memory"      // storage was never reached, an empty registry is served read-only
+	storageReadOnly    = "read-only"   // storage is unreachable, reads are served from the cache
+	storageUnavailable = "unavailable" // no storage, requests are rejected
+)
+
+// HealthResponse reports the state of the registry and its storage
+type HealthResponse struct {
+	Status  string `json:"status"` // UP, DEGRADED or DOWN
+	Storage string `json:"storage"`
+}
+
+// ReadinessResponse tells whether the registry is ready to serve requests
+type ReadinessResponse struct {
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ConnectionStatus describes the connection to the storage backend
+type ConnectionStatus struct {
+	Storage    string `json:"storage"` // storage mode
+	State      string `json:"state"`
+	URL        string `json:"url,omitempty"`
+	ServerID   string `json:"serverId,omitempty"`
+	Reconnects uint64 `json:"reconnects"`
+}
+
+// StatusResponse is the detailed status document of the registry
+type StatusResponse struct {
+	Status     string             `json:"status"` // as reported by /health
+	Storage    string             `json:"storage"`
+	Ready      bool               `json:"ready"`
+	Connection *ConnectionStatus  `json:"connection,omitempty"`
+	Registry   *schema.Status     `json:"registry,omitempty"`
+	Cache      *schema.CacheStats `json:"cache,omitempty"`
+}
+
+// ModeResponse returns mode.
+type ModeResponse struct {
+	Mode string `json:"mode"`
 }
 
 // ErrorResponse represents an error message
 type ErrorResponse struct {
-	ErrorCode int    `json:"error_code"`
-	Message   string `json:"message"`
+	ErrorCode  int               `json:"error_code"`
+	Message    string            `json:"message"`
+	Violations []types.Violation `json:"violations,omitempty"`
 }
 
 // SetupRouter creates and configures a Gin router with all schema registry routes
@@ -352,6 +197,12 @@ func SetupRouter() *gin.Engine {
 
 	r := gin.New()
 	r.Use(gin.Recovery())
+	r.Use(metricsMiddleware)
+	r.Use(tracingMiddleware)
+	r.Use(authMiddleware)
+
+	// Prometheus metrics, served before the content type is set below
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	// Set custom content type for all responses
 	r.Use(func(c *gin.Context) {
@@ -369,6 +220,7 @@ func SetupRouter() *gin.Engine {
 		subjectGroup.POST("/versions", registerSchema)
 		subjectGroup.GET("/versions/:version", getSchema)
 		subjectGroup.DELETE("/versions/:version", deleteSchemaVersion)
+		subjectGroup.GET("/versions/:version/referencedby", getReferencedBy)
 		subjectGroup.DELETE("", deleteSubject)
 		subjectGroup.POST("", checkSchema)
 	}
@@ -376,6 +228,9 @@ func SetupRouter() *gin.Engine {
 	// Schema ID routes
 	r.GET("/schemas/ids/:id", getSchemaById)
 
+	// Context routes
+	r.GET("/contexts", listContexts)
+
 	// Compatibility routes
 	r.POST("/compatibility/subjects/:subject/versions/:version", checkCompatibility)
 	r.POST("/compatibility/subjects/:subject/versions", checkCompatibilityForSubject)
@@ -386,6 +241,26 @@ func SetupRouter() *gin.Engine {
 	r.GET("/config/:subject", getSubjectConfig)
 	r.PUT("/config/:subject", updateSubjectConfig)
 
+	// Mode routes
+	r.GET("/mode", getGlobalMode)
+	r.PUT("/mode", updateGlobalMode)
+	r.GET("/mode/:subject", getSubjectMode)
+	r.PUT("/mode/:subject", updateSubjectMode)
+	r.DELETE("/mode/:subject", deleteSubjectMode)
+
+	// Access control routes
+	r.GET("/acl", getACL)
+	r.PUT("/acl", updateACL)
+
+	// Health routes
+	r.GET("/health", getHealth)
+	r.GET("/healthz", getLiveness)
+	r.GET("/readyz", getReadiness)
+	r.GET("/v1/metadata/status", getStatus)
+
+	// Debug routes
+	r.GET("/debug/cache", getCacheStats)
+
 	return r
 }
 
@@ -396,45 +271,31 @@ func Routes() http.Handler {
 
 func handleSubjects(c *gin.Context) {
 	// Check if storage is available
-	if kvSchemas == nil {
-		slog.Error("Storage not available", "endpoint", "handleSubjects")
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
 		return
 	}
 
 	// Get all subjects with at least one version
-	slog.Debug("Getting keys from KeyValue store", "bucket", kvSchemas.Bucket())
-	keys, err := kvSchemas.Keys()
+	subjectList, err := registry.GetSubjects(c.Request.Context(), c.Query("deleted") == "true")
 	if err != nil {
-		slog.Error("Failed to get keys", "error", err, "bucket", kvSchemas.Bucket())
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			ErrorCode: 50000,
-			Message:   fmt.Sprintf("failed to get keys: %v", err),
-		})
+		respondError(c, err)
 		return
 	}
 
-	// Filter out internal keys and extract unique subjects
-	subjects := make(map[string]bool)
-	prefix := "subjects/"
-	for _, key := range keys {
-		if !strings.HasPrefix(key, prefix) {
-			continue
-		}
-		parts := strings.Split(strings.TrimPrefix(key, prefix), "/")
-		if len(parts) > 0 {
-			subjects[parts[0]] = true
-		}
+	// Only list the subjects the caller may read
+	allowed, err := permissions(c, registry)
+	if err != nil {
+		respondError(c, err)
+		return
 	}
-
-	// Convert map to slice
-	subjectList := make([]string, 0, len(subjects))
-	for subject := range subjects {
-		subjectList = append(subjectList, subject)
+	readable := make([]string, 0, len(subjectList))
+	for _, subject := range subjectList {
+		if allowed(subject, schema.RoleReader) {
+			readable = append(readable, subject)
+		}
 	}
+	subjectList = readable
 
 	slog.Debug("Got subjects", "count", len(subjectList), "subjects", subjectList)
 	c.JSON(http.StatusOK, subjectList)
@@ -444,11 +305,11 @@ func registerSchema(c *gin.Context) {
 	subject := c.Param("subject")
 
 	// Check if storage is available
-	if kvSchemas == nil || registry == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleDeveloper) {
 		return
 	}
 
@@ -467,19 +328,16 @@ func registerSchema(c *gin.Context) {
 	}
 
 	slog.Debug("Registering schema", "subject", subject, "schema", req.Schema, "schemaType", schemaType, "references", req.References)
-	id, err := registry.RegisterSchema(subject, req.Schema, schemaType, req.References)
+	normalize := c.Query("normalize") == "true"
+	var id int
+	var err error
+	if req.ID > 0 {
+		id, err = registry.ImportSchema(c.Request.Context(), subject, req.Schema, schemaType, req.References, req.ID, req.Version, normalize)
+	} else {
+		id, err = registry.RegisterSchema(c.Request.Context(), subject, req.Schema, schemaType, req.References, normalize)
+	}
 	if err != nil {
-		if err.Error() == "incompatible schema" {
-			c.JSON(http.StatusConflict, ErrorResponse{
-				ErrorCode: 40901,
-				Message:   "incompatible schema",
-			})
-		} else {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				ErrorCode: 50000,
-				Message:   err.Error(),
-			})
-		}
+		respondError(c, err)
 		return
 	}
 
@@ -491,25 +349,17 @@ func getSchema(c *gin.Context) {
 	version := c.Param("version")
 
 	// Check if storage is available
-	if kvSchemas == nil || registry == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleReader) {
 		return
 	}
 
-	schema, err := registry.GetSchemaBySubjectVersion(subject, version)
+	schema, err := registry.GetSchemaBySubjectVersion(c.Request.Context(), subject, version, c.Query("deleted") == "true")
 	if err != nil {
-		code := http.StatusInternalServerError
-		if err.Error() == "no versions found" {
-			code = http.StatusNotFound
-		}
-
-		c.JSON(code, ErrorResponse{
-			ErrorCode: 40401,
-			Message:   err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -529,25 +379,44 @@ func getSchema(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+func getReferencedBy(c *gin.Context) {
+	subject := c.Param("subject")
+	version := c.Param("version")
+
+	// Check if storage is available
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleReader) {
+		return
+	}
+
+	ids, err := registry.GetReferencedBy(c.Request.Context(), subject, version)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ids)
+}
+
 func listVersions(c *gin.Context) {
 	slog.Debug("Listing versions")
 	subject := c.Param("subject")
 
 	// Check if storage is available
-	if kvSchemas == nil || registry == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleReader) {
 		return
 	}
 
-	versions, err := registry.GetVersions(subject)
+	versions, err := registry.GetVersions(c.Request.Context(), subject, c.Query("deleted") == "true")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			ErrorCode: 50000,
-			Message:   err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -558,11 +427,11 @@ func checkCompatibility(c *gin.Context) {
 	subject := c.Param("subject")
 
 	// Check if storage is available
-	if kvSchemas == nil || registry == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleDeveloper) {
 		return
 	}
 
@@ -580,36 +449,30 @@ func checkCompatibility(c *gin.Context) {
 		schemaType = types.SchemaType(req.SchemaType)
 	}
 
-	level, err := registry.GetCompatibilityLevel(subject)
+	level, err := registry.GetCompatibilityLevel(c.Request.Context(), subject)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			ErrorCode: 50000,
-			Message:   err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
-	compatible, err := registry.CheckCompatibility(subject, req.Schema, schemaType, level)
+	violations, err := registry.CheckCompatibility(c.Request.Context(), subject, req.Schema, schemaType, req.References, level)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			ErrorCode: 50000,
-			Message:   err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, CompatibilityResponse{IsCompatible: compatible})
+	c.JSON(http.StatusOK, newCompatibilityResponse(violations, c.Query("verbose") == "true"))
 }
 
 func checkCompatibilityForSubject(c *gin.Context) {
 	subject := c.Param("subject")
 
 	// Check if storage is available
-	if kvSchemas == nil || registry == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleDeveloper) {
 		return
 	}
 
@@ -627,56 +490,76 @@ func checkCompatibilityForSubject(c *gin.Context) {
 		schemaType = types.SchemaType(req.SchemaType)
 	}
 
-	level, err := registry.GetCompatibilityLevel(subject)
+	level, err := registry.GetCompatibilityLevel(c.Request.Context(), subject)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			ErrorCode: 50000,
-			Message:   err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
-	compatible, err := registry.CheckCompatibility(subject, req.Schema, schemaType, level)
+	violations, err := registry.CheckCompatibility(c.Request.Context(), subject, req.Schema, schemaType, req.References, level)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			ErrorCode: 50000,
-			Message:   err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, CompatibilityResponse{IsCompatible: compatible})
+	c.JSON(http.StatusOK, newCompatibilityResponse(violations, c.Query("verbose") == "true"))
+}
+
+// getConfig returns the effective compatibility and normalization of a subject, or "global"
+func getConfig(ctx context.Context, registry *schema.Registry, subject string) (ConfigResponse, error) {
+	level, err := registry.GetCompatibilityLevel(ctx, subject)
+	if err != nil {
+		return ConfigResponse{}, err
+	}
+	normalize, err := registry.GetNormalize(ctx, subject)
+	if err != nil {
+		return ConfigResponse{}, err
+	}
+	return ConfigResponse{CompatibilityLevel: string(level), Normalize: normalize}, nil
+}
+
+// updateConfig applies the settings of a config request to a subject, or "global"
+func updateConfig(ctx context.Context, registry *schema.Registry, subject string, req ConfigRequest) error {
+	// A request without any setting is rejected as an invalid compatibility level
+	if req.Compatibility != "" || req.Normalize == nil {
+		if err := registry.SetCompatibilityLevel(ctx, subject, types.CompatibilityLevel(req.Compatibility)); err != nil {
+			return err
+		}
+	}
+	if req.Normalize != nil {
+		if err := registry.SetNormalize(ctx, subject, *req.Normalize); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func getGlobalConfig(c *gin.Context) {
 	// Check if storage is available
-	if kvConfig == nil || registry == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, "global", schema.RoleReader) {
 		return
 	}
 
-	level, err := registry.GetCompatibilityLevel("global")
+	response, err := getConfig(c.Request.Context(), registry, "global")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			ErrorCode: 50000,
-			Message:   err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, ConfigResponse{CompatibilityLevel: string(level)})
+	c.JSON(http.StatusOK, response)
 }
 
 func updateGlobalConfig(c *gin.Context) {
 	// Check if storage is available
-	if kvConfig == nil || registry == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, "global", schema.RoleOperator) {
 		return
 	}
 
@@ -689,50 +572,49 @@ func updateGlobalConfig(c *gin.Context) {
 		return
 	}
 
-	if err := registry.SetCompatibilityLevel("global", types.CompatibilityLevel(req.Compatibility)); err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			ErrorCode: 50000,
-			Message:   err.Error(),
-		})
+	if err := updateConfig(c.Request.Context(), registry, "global", req); err != nil {
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, ConfigResponse{CompatibilityLevel: req.Compatibility})
+	response, err := getConfig(c.Request.Context(), registry, "global")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func getSubjectConfig(c *gin.Context) {
 	subject := c.Param("subject")
 
 	// Check if storage is available
-	if kvConfig == nil || registry == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleReader) {
 		return
 	}
 
-	level, err := registry.GetCompatibilityLevel(subject)
+	response, err := getConfig(c.Request.Context(), registry, subject)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			ErrorCode: 50000,
-			Message:   err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, ConfigResponse{CompatibilityLevel: string(level)})
+	c.JSON(http.StatusOK, response)
 }
 
 func updateSubjectConfig(c *gin.Context) {
 	subject := c.Param("subject")
 
 	// Check if storage is available
-	if kvConfig == nil || registry == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleOperator) {
 		return
 	}
 
@@ -745,44 +627,233 @@ func updateSubjectConfig(c *gin.Context) {
 		return
 	}
 
-	if err := registry.SetCompatibilityLevel(subject, types.CompatibilityLevel(req.Compatibility)); err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			ErrorCode: 50000,
-			Message:   err.Error(),
+	if err := updateConfig(c.Request.Context(), registry, subject, req); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	response, err := getConfig(c.Request.Context(), registry, subject)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func getGlobalMode(c *gin.Context) {
+	// Check if storage is available
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, "global", schema.RoleReader) {
+		return
+	}
+
+	mode, err := registry.GetMode(c.Request.Context(), "global", true)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ModeResponse{Mode: string(mode)})
+}
+
+func updateGlobalMode(c *gin.Context) {
+	updateMode(c, "global")
+}
+
+func getSubjectMode(c *gin.Context) {
+	subject := c.Param("subject")
+
+	// Check if storage is available
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleReader) {
+		return
+	}
+
+	mode, err := registry.GetMode(c.Request.Context(), subject, c.Query("defaultToGlobal") == "true")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ModeResponse{Mode: string(mode)})
+}
+
+func updateSubjectMode(c *gin.Context) {
+	updateMode(c, c.Param("subject"))
+}
+
+// updateMode handles PUT /mode and PUT /mode/{subject}
+func updateMode(c *gin.Context, subject string) {
+	// Check if storage is available
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleOperator) {
+		return
+	}
+
+	var req ModeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			ErrorCode: 42201,
+			Message:   "invalid JSON",
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, ConfigResponse{CompatibilityLevel: req.Compatibility})
+	force := c.Query("force") == "true"
+	if err := registry.SetMode(c.Request.Context(), subject, types.Mode(req.Mode), force); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ModeRequest{Mode: req.Mode})
+}
+
+func deleteSubjectMode(c *gin.Context) {
+	subject := c.Param("subject")
+
+	// Check if storage is available
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleOperator) {
+		return
+	}
+
+	mode, err := registry.DeleteMode(c.Request.Context(), subject)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ModeResponse{Mode: string(mode)})
+}
+
+// getACL handles GET /acl
+func getACL(c *gin.Context) {
+	// Check if storage is available
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, "global", schema.RoleAdmin) {
+		return
+	}
+
+	acl, err := registry.GetACL(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, acl)
+}
+
+// updateACL handles PUT /acl, replacing the whole access control list
+func updateACL(c *gin.Context) {
+	// Check if storage is available
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, "global", schema.RoleAdmin) {
+		return
+	}
+
+	var acl schema.ACL
+	if err := c.ShouldBindJSON(&acl); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			ErrorCode: 42201,
+			Message:   "invalid JSON",
+		})
+		return
+	}
+
+	if err := registry.SetACL(c.Request.Context(), &acl); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, acl)
 }
 
 func getSchemaById(c *gin.Context) {
 	id := c.Param("id")
 
 	// Check if storage is available
-	if kvSchemas == nil || registry == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+
+	// The schema is readable by those who may read a subject using the ID, the
+	// given subject if it is one of them. IDs the caller may not read are
+	// reported as not found, so that their existence is not disclosed.
+	allowed, err := permissions(c, registry)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	notFound := fmt.Errorf("%w: %s", schema.ErrSchemaNotFound, id)
+	subject := c.Query("subject")
+	if subject != "" && !allowed(subject, schema.RoleReader) {
+		respondError(c, notFound)
 		return
 	}
 
-	schema, err := registry.GetSchemaById(id)
+	// The subject, if given, selects the context the ID belongs to
+	record, err := registry.GetSchemaById(c.Request.Context(), id, subject)
 	if err != nil {
-		code := http.StatusInternalServerError
-		if err.Error() == "schema not found" {
-			code = http.StatusNotFound
+		respondError(c, err)
+		return
+	}
+
+	if authorizationEnabled() {
+		subjects, err := registry.GetSubjectsById(c.Request.Context(), id, subject)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if !slices.ContainsFunc(subjects, func(s string) bool { return allowed(s, schema.RoleReader) }) {
+			respondError(c, notFound)
+			return
 		}
+	}
 
-		c.JSON(code, ErrorResponse{
-			ErrorCode: 40403,
-			Message:   err.Error(),
-		})
+	c.JSON(http.StatusOK, map[string]string{"schema": record.Schema})
+}
+
+// listContexts handles GET /contexts
+func listContexts(c *gin.Context) {
+	// Check if storage is available
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+
+	contexts, err := registry.GetContexts(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, map[string]string{"schema": schema.Schema})
+	// Only list the contexts the caller holds grants in
+	contexts, err = visibleContexts(c, registry, contexts)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, contexts)
 }
 
 func deleteSchemaVersion(c *gin.Context) {
@@ -790,54 +861,38 @@ func deleteSchemaVersion(c *gin.Context) {
 	version := c.Param("version")
 
 	// Check if storage is available
-	if kvSchemas == nil || registry == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleAdmin) {
 		return
 	}
 
-	err := registry.DeleteSchemaVersion(subject, version)
+	deleted, err := registry.DeleteSchemaVersion(c.Request.Context(), subject, version, c.Query("permanent") == "true")
 	if err != nil {
-		code := http.StatusInternalServerError
-		if err.Error() == "version not found" {
-			code = http.StatusNotFound
-		}
-
-		c.JSON(code, ErrorResponse{
-			ErrorCode: 40402,
-			Message:   err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, version)
+	c.JSON(http.StatusOK, deleted)
 }
 
 func deleteSubject(c *gin.Context) {
 	subject := c.Param("subject")
 
 	// Check if storage is available
-	if kvSchemas == nil || registry == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleAdmin) {
 		return
 	}
 
-	versions, err := registry.DeleteSubject(subject)
+	versions, err := registry.DeleteSubject(c.Request.Context(), subject, c.Query("permanent") == "true")
 	if err != nil {
-		code := http.StatusInternalServerError
-		if err.Error() == "subject not found" {
-			code = http.StatusNotFound
-		}
-
-		c.JSON(code, ErrorResponse{
-			ErrorCode: 40401,
-			Message:   err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -849,11 +904,11 @@ func checkSchema(c *gin.Context) {
 	slog.Debug("Checking schema", "subject", subject)
 
 	// Check if storage is available
-	if kvSchemas == nil || registry == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			ErrorCode: 50300,
-			Message:   "storage backend unavailable",
-		})
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, subject, schema.RoleReader) {
 		return
 	}
 
@@ -871,19 +926,9 @@ func checkSchema(c *gin.Context) {
 		schemaType = types.SchemaType(req.SchemaType)
 	}
 
-	schema, err := registry.LookupSchema(subject, req.Schema, schemaType)
+	schema, err := registry.LookupSchema(c.Request.Context(), subject, req.Schema, schemaType, req.References, c.Query("normalize") == "true")
 	if err != nil {
-		if err.Error() == "schema not found" {
-			c.JSON(http.StatusNotFound, ErrorResponse{
-				ErrorCode: 40403,
-				Message:   err.Error(),
-			})
-		} else {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				ErrorCode: 50000,
-				Message:   err.Error(),
-			})
-		}
+		respondError(c, err)
 		return
 	}
 
@@ -902,3 +947,89 @@ func checkSchema(c *gin.Context) {
 
 	c.JSON(http.StatusOK, response)
 }
+
+// health returns the health of a registry. The registry is DOWN without
+// storage and DEGRADED while its records are not persisted or cannot be written.
+func health(registry *schema.Registry) HealthResponse {
+	switch {
+	case registry == nil:
+		return HealthResponse{Status: "DOWN", Storage: storageUnavailable}
+	case inMemory.Load():
+		return HealthResponse{Status: "DEGRADED", Storage: storageMemory}
+	case registry.Degraded():
+		return HealthResponse{Status: "DEGRADED", Storage: storageReadOnly}
+	default:
+		return HealthResponse{Status: "UP", Storage: storageAvailable}
+	}
+}
+
+// getHealth handles GET /health
+func getHealth(c *gin.Context) {
+	response := health(bound.Load())
+	if response.Status == "DOWN" {
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// getLiveness handles GET /healthz. It only tells that the process serves requests.
+func getLiveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "UP"})
+}
+
+// getReadiness handles GET /readyz. The registry is ready once its storage can
+// be reached and its cache has caught up with it.
+func getReadiness(c *gin.Context) {
+	registry := bound.Load()
+	if registry == nil {
+		c.JSON(http.StatusServiceUnavailable, ReadinessResponse{Reason: "storage backend unavailable"})
+		return
+	}
+
+	status := registry.Status(c.Request.Context())
+	response := ReadinessResponse{Ready: status.Ready, Reason: status.Reason}
+	if !status.Ready {
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// getStatus handles GET /v1/metadata/status. The status discloses the
+// storage layout, so it is only served to readers of the global config, and to
+// superusers while no registry is bound.
+func getStatus(c *gin.Context) {
+	registry := bound.Load()
+	if !authorize(c, registry, "global", schema.RoleReader) {
+		return
+	}
+	h := health(registry)
+	response := StatusResponse{Status: h.Status, Storage: h.Storage}
+	if fn := connectionStatus.Load(); fn != nil {
+		connection := (*fn)()
+		response.Connection = &connection
+	}
+	if registry != nil {
+		status := registry.Status(c.Request.Context())
+		cache := registry.CacheStats()
+		response.Ready = status.Ready
+		response.Registry = &status
+		response.Cache = &cache
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// getCacheStats handles GET /debug/cache
+func getCacheStats(c *gin.Context) {
+	// Check if storage is available
+	registry := boundRegistry(c)
+	if registry == nil {
+		return
+	}
+	if !authorize(c, registry, "global", schema.RoleReader) {
+		return
+	}
+
+	c.JSON(http.StatusOK, registry.CacheStats())
+}
@@ -0,0 +1,232 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"schemaregistry/internal/auth"
+	"schemaregistry/internal/schema"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// persistentStore stands for a store of the storage backend, which Init does
+// not take for an in-memory one
+type persistentStore struct {
+	schema.Store
+}
+
+// userAuthenticator takes the user of Basic credentials as principal,
+// without checking the password
+type userAuthenticator struct{}
+
+func (userAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	user, _, ok := r.BasicAuth()
+	if !ok {
+		return nil, auth.ErrNoCredentials
+	}
+	return &auth.Principal{Name: user, Method: "test"}, nil
+}
+
+func (userAuthenticator) Scheme() string {
+	return "Basic"
+}
+
+// tokenAuthenticator takes a bearer token as principal name
+type tokenAuthenticator struct{}
+
+func (tokenAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, auth.ErrNoCredentials
+	}
+	return &auth.Principal{Name: token, Method: "token"}, nil
+}
+
+func (tokenAuthenticator) Scheme() string {
+	return "Bearer"
+}
+
+// serve sends a request to the router and returns the response
+func serve(t *testing.T, router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// serveAs sends a request to the router on behalf of a principal
+func serveAs(t *testing.T, router http.Handler, principal, method, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	req.SetBasicAuth(principal, "")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// waitBound waits for the bound registry to be ready
+func waitBound(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, bound.Load().WaitReady(ctx))
+}
+
+func TestInit_RebindFromMemory(t *testing.T) {
+	t.Cleanup(func() { Init(nil, nil) })
+	router := SetupRouter()
+	body := `{"schemaType": "JSON", "schema": "{\"type\": \"object\"}"}`
+
+	// The storage already holds a subject registered by another instance
+	schemas := persistentStore{schema.NewMemoryStore("schemas")}
+	config := persistentStore{schema.NewMemoryStore("config")}
+	other := schema.New(schemas, config)
+	_, err := other.RegisterSchema(t.Context(), "orders", `{"type": "object"}`, "JSON", nil, false)
+	require.NoError(t, err)
+	other.Close()
+
+	// While storage is unavailable, the in-memory registry rejects writes,
+	// even once the connection reports the storage available again
+	Init(schema.NewMemoryStore("schemas"), schema.NewMemoryStore("config"))
+	waitBound(t)
+	w := serve(t, router, http.MethodPost, "/subjects/payments/versions", body)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":50300`)
+	SetStorageAvailable(true)
+	w = serve(t, router, http.MethodPost, "/subjects/payments/versions", body)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	var health HealthResponse
+	w = serve(t, router, http.MethodGet, "/health", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Equal(t, HealthResponse{Status: "DEGRADED", Storage: storageMemory}, health)
+
+	// Once bound, the storage is served and written to
+	Init(schemas, config)
+	waitBound(t)
+	w = serve(t, router, http.MethodGet, "/subjects", "")
+	assert.JSONEq(t, `["orders"]`, w.Body.String())
+	w = serve(t, router, http.MethodPost, "/subjects/payments/versions", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id": 1}`, w.Body.String())
+
+	w = serve(t, router, http.MethodGet, "/health", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Equal(t, HealthResponse{Status: "UP", Storage: storageAvailable}, health)
+}
+
+func TestGetSchemaById_Authorization(t *testing.T) {
+	Init(persistentStore{schema.NewMemoryStore("schemas")}, persistentStore{schema.NewMemoryStore("config")})
+	waitBound(t)
+	SetAuthenticators(userAuthenticator{})
+	SetAuthorization(true)
+	t.Cleanup(func() {
+		SetAuthorization(false)
+		SetAuthenticators()
+		Init(nil, nil)
+	})
+	router := SetupRouter()
+
+	// ID 1 is first registered under orders, then shared with billing-orders
+	registry := bound.Load()
+	shared, err := registry.RegisterSchema(t.Context(), "orders", `{"type": "object"}`, "JSON", nil, false)
+	require.NoError(t, err)
+	_, err = registry.RegisterSchema(t.Context(), "billing-orders", `{"type": "object"}`, "JSON", nil, false)
+	require.NoError(t, err)
+	hidden, err := registry.RegisterSchema(t.Context(), "payroll", `{"type": "string"}`, "JSON", nil, false)
+	require.NoError(t, err)
+	require.NoError(t, registry.SetACL(t.Context(), &schema.ACL{Grants: []schema.Grant{
+		{Principal: "test:billing", Role: schema.RoleReader, SubjectPrefix: "billing-"},
+	}}))
+
+	// An ID is readable through any subject using it
+	w := serveAs(t, router, "billing", http.MethodGet, fmt.Sprintf("/schemas/ids/%d", shared))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serveAs(t, router, "billing", http.MethodGet, fmt.Sprintf("/schemas/ids/%d?subject=billing-orders", shared))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// IDs the caller may not read cannot be told from unknown ones
+	for _, path := range []string{
+		fmt.Sprintf("/schemas/ids/%d", hidden),
+		fmt.Sprintf("/schemas/ids/%d?subject=orders", shared),
+		fmt.Sprintf("/schemas/ids/%d?subject=billing-orders", hidden),
+		"/schemas/ids/100",
+		"/schemas/ids/100?subject=orders",
+	} {
+		w = serveAs(t, router, "billing", http.MethodGet, path)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+		assert.Contains(t, w.Body.String(), `"error_code":40403`, path)
+	}
+}
+
+func TestGetStatus_Authorization(t *testing.T) {
+	SetAuthenticators(userAuthenticator{})
+	SetAuthorization(true, "test:root")
+	t.Cleanup(func() {
+		SetAuthorization(false)
+		SetAuthenticators()
+		Init(nil, nil)
+	})
+	router := SetupRouter()
+
+	// Without a bound registry only superusers are served
+	Init(nil, nil)
+	w := serveAs(t, router, "ops", http.MethodGet, "/v1/metadata/status")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serveAs(t, router, "root", http.MethodGet, "/v1/metadata/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Then readers of the global config are
+	Init(persistentStore{schema.NewMemoryStore("schemas")}, persistentStore{schema.NewMemoryStore("config")})
+	waitBound(t)
+	require.NoError(t, bound.Load().SetACL(t.Context(), &schema.ACL{Grants: []schema.Grant{
+		{Principal: "test:ops", Role: schema.RoleReader},
+		{Principal: "test:billing", Role: schema.RoleAdmin, SubjectPrefix: "billing-"},
+	}}))
+	w = serveAs(t, router, "ops", http.MethodGet, "/v1/metadata/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serveAs(t, router, "billing", http.MethodGet, "/v1/metadata/status")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), `"error_code":40301`)
+}
+
+func TestAuthorization_PrincipalsOfDifferentMethods(t *testing.T) {
+	Init(persistentStore{schema.NewMemoryStore("schemas")}, persistentStore{schema.NewMemoryStore("config")})
+	waitBound(t)
+	SetAuthenticators(userAuthenticator{}, tokenAuthenticator{})
+	SetAuthorization(true, "test:root")
+	t.Cleanup(func() {
+		SetAuthorization(false)
+		SetAuthenticators()
+		Init(nil, nil)
+	})
+	router := SetupRouter()
+	require.NoError(t, bound.Load().SetACL(t.Context(), &schema.ACL{Grants: []schema.Grant{
+		{Principal: "token:ops", Role: schema.RoleReader},
+	}}))
+
+	// A name shared by callers of different methods does not share their grants
+	byToken := func(name string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/metadata/status", nil)
+		req.Header.Set("Authorization", "Bearer "+name)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusOK, serveAs(t, router, "root", http.MethodGet, "/v1/metadata/status").Code)
+	assert.Equal(t, http.StatusForbidden, byToken("root").Code)
+	assert.Equal(t, http.StatusOK, byToken("ops").Code)
+	w := serveAs(t, router, "ops", http.MethodGet, "/v1/metadata/status")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "test:ops does not hold role reader")
+}
@@ -0,0 +1,206 @@
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Role is a set of operations granted on subjects. Each role includes the
+// operations of the roles before it.
+type Role string
+
+// Roles, from the least to the most privileged
+const (
+	RoleReader    Role = "reader"    // read schemas, versions, config and modes
+	RoleDeveloper Role = "developer" // register schemas and check compatibility
+	RoleOperator  Role = "operator"  // change config and modes
+	RoleAdmin     Role = "admin"     // delete versions and subjects, manage the ACL
+)
+
+// roleRanks orders the roles
+var roleRanks = map[Role]int{
+	RoleReader:    1,
+	RoleDeveloper: 2,
+	RoleOperator:  3,
+	RoleAdmin:     4,
+}
+
+// Includes reports whether a role grants the operations of another
+func (r Role) Includes(other Role) bool {
+	return roleRanks[other] > 0 && roleRanks[r] >= roleRanks[other]
+}
+
+// AnyPrincipal is the principal of grants given to every authenticated caller
+const AnyPrincipal = "*"
+
+// principalPattern matches principals qualified with the authentication
+// method that identified them, such as jwt:alice
+var principalPattern = regexp.MustCompile(`^[a-z]+:.+$`)
+
+// keyACL is the config key of the access control list
+const keyACL = "acl"
+
+// Grant gives a role to a principal on the subjects starting with a prefix,
+// within a context or, without one, on subjects as they are named
+type Grant struct {
+	Principal     string `json:"principal"` // method:name, or * for every caller
+	Role          Role   `json:"role"`
+	SubjectPrefix string `json:"subjectPrefix,omitempty"` // every subject if empty
+	Context       string `json:"context,omitempty"`       // prefix applies to names within the context
+}
+
+// covers reports whether a grant applies to a subject. The global config and
+// mode, named "global", are only covered by grants on every subject.
+func (g Grant) covers(subject string) bool {
+	if subject == "global" {
+		return g.Context == "" && g.SubjectPrefix == ""
+	}
+	if g.Context == "" {
+		return strings.HasPrefix(subject, g.SubjectPrefix)
+	}
+	context, name := SplitSubject(subject)
+	return context == g.Context && strings.HasPrefix(name, g.SubjectPrefix)
+}
+
+// appliesTo reports whether a grant is given to a principal
+func (g Grant) appliesTo(principal string) bool {
+	return g.Principal == principal || g.Principal == AnyPrincipal
+}
+
+// ACL is the access control list of a registry. Principals without grants
+// are denied everything.
+type ACL struct {
+	Grants []Grant `json:"grants"`
+}
+
+// Validate checks that every grant names a principal, a known role and a valid context
+func (a *ACL) Validate() error {
+	for i, g := range a.Grants {
+		switch {
+		case g.Principal == "":
+			return fmt.Errorf("%w: grant %d has no principal", ErrInvalidACL, i)
+		case g.Principal != AnyPrincipal && !principalPattern.MatchString(g.Principal):
+			return fmt.Errorf("%w: grant %d principal %q is not qualified with an authentication method, such as basic:%s", ErrInvalidACL, i, g.Principal, g.Principal)
+		case roleRanks[g.Role] == 0:
+			return fmt.Errorf("%w: grant %d has unknown role %q", ErrInvalidACL, i, g.Role)
+		case g.Context != "" && g.Context != DefaultContext && !contextNamePattern.MatchString(g.Context):
+			return fmt.Errorf("%w: grant %d has invalid context %q", ErrInvalidACL, i, g.Context)
+		}
+	}
+	return nil
+}
+
+// Allows reports whether a principal holds a role on a subject, a context
+// given as :.{context}: or "global"
+func (a *ACL) Allows(principal, subject string, role Role) bool {
+	for _, g := range a.Grants {
+		if g.appliesTo(principal) && g.Role.Includes(role) && g.covers(subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsContext reports whether a principal holds any grant that may cover
+// subjects of a context
+func (a *ACL) AllowsContext(principal, context string) bool {
+	for _, g := range a.Grants {
+		if !g.appliesTo(principal) {
+			continue
+		}
+		if g.Context == context || g.Context == "" && (g.SubjectPrefix == "" || ContextOf(g.SubjectPrefix) == context) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseACL decodes a stored access control list
+func parseACL(value []byte) (*ACL, error) {
+	var acl ACL
+	if err := json.Unmarshal(value, &acl); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidACL, err)
+	}
+	if err := acl.Validate(); err != nil {
+		return nil, err
+	}
+	return &acl, nil
+}
+
+// applyACLUpdate replaces the cached access control list. A list that cannot
+// be parsed denies everything rather than keeping grants that were revoked.
+// Must be called with cacheMu held.
+func (r *Registry) applyACLUpdate(value []byte, deleted bool) {
+	if deleted {
+		r.acl = nil
+		slog.Info("Access control list removed")
+		return
+	}
+	acl, err := parseACL(value)
+	if err != nil {
+		slog.Error("Ignoring invalid access control list, denying every principal", "error", err)
+		acl = &ACL{}
+	}
+	r.acl = acl
+	slog.Info("Access control list reloaded", "grants", len(acl.Grants))
+}
+
+// GetACL returns the access control list, as applied by the watcher or read
+// from the store until the cache is hydrated. Without a stored list the
+// returned list is empty.
+func (r *Registry) GetACL(ctx context.Context) (_ *ACL, err error) {
+	ctx, span := startSpan(ctx, "Registry.GetACL")
+	defer func() { endSpan(span, err) }()
+
+	r.cacheMu.RLock()
+	hydrated, acl := r.configView.hydrated, r.acl
+	r.cacheMu.RUnlock()
+	if hydrated {
+		if acl == nil {
+			return &ACL{Grants: []Grant{}}, nil
+		}
+		return acl, nil
+	}
+
+	value, found, err := r.getConfigValue(ctx, keyACL)
+	if err != nil {
+		return nil, fmt.Errorf("get ACL: %w", err)
+	}
+	if !found {
+		return &ACL{Grants: []Grant{}}, nil
+	}
+	parsed, err := parseACL(value)
+	if err != nil {
+		slog.Error("Ignoring invalid access control list, denying every principal", "error", err)
+		return &ACL{Grants: []Grant{}}, nil
+	}
+	return parsed, nil
+}
+
+// SetACL replaces the access control list. Every registry watching the
+// config bucket applies it.
+func (r *Registry) SetACL(ctx context.Context, acl *ACL) (err error) {
+	ctx, span := startSpan(ctx, "Registry.SetACL")
+	defer func() { endSpan(span, err) }()
+
+	if err := acl.Validate(); err != nil {
+		return err
+	}
+	if err := r.checkAvailable(); err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(acl)
+	if err != nil {
+		return fmt.Errorf("marshal ACL: %w", err)
+	}
+	if _, err := r.configStore(ctx).Put(keyACL, value); err != nil {
+		return err
+	}
+	r.syncCache(r.configStore(ctx), &r.configView)
+	return nil
+}
@@ -0,0 +1,527 @@
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"schemaregistry/internal/schema/types"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reads are served from an in-memory view of the schemas and config buckets
+// that watchUpdates hydrates and keeps current. Writes made through a registry
+// wait for its watcher to apply them before returning, so they are visible to
+// the reads that follow (read-your-writes). Writes made by other instances
+// become visible as soon as the watcher delivers them. Until a bucket has been
+// hydrated, or if its watcher stops, reads go straight to the store.
+
+const (
+	// Maximum time a write waits for the watcher to apply it
+	cacheSyncTimeout = 5 * time.Second
+
+	// Cache names reported by CacheStats
+	cacheSchemas  = "schemas"
+	cacheVersions = "versions"
+	cacheSubjects = "subjects"
+	cacheConfig   = "config"
+)
+
+// cacheEntry represents a cached schema with its metadata
+type cacheEntry struct {
+	schema   *types.Schema
+	revision uint64
+}
+
+// bucketView tracks how far the cache has caught up with a bucket
+type bucketView struct {
+	watching  bool      // the watcher is running
+	hydrated  bool      // all initial values have been applied
+	revision  uint64    // highest revision applied
+	lastEvent time.Time // when the watcher last delivered an update
+}
+
+// cacheCounter counts the hits and misses of one cache
+type cacheCounter struct {
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+	hitMetric  prometheus.Counter
+	missMetric prometheus.Counter
+}
+
+// newCacheCounter creates the counter of a cache
+func newCacheCounter(name string) *cacheCounter {
+	return &cacheCounter{
+		hitMetric:  cacheLookups.WithLabelValues(name, "hit"),
+		missMetric: cacheLookups.WithLabelValues(name, "miss"),
+	}
+}
+
+// record counts a lookup
+func (c *cacheCounter) record(hit bool) {
+	if hit {
+		c.hits.Add(1)
+		c.hitMetric.Inc()
+	} else {
+		c.misses.Add(1)
+		c.missMetric.Inc()
+	}
+}
+
+// CacheStats describes the state of the in-memory caches
+type CacheStats struct {
+	Hydrated        bool                         `json:"hydrated"`
+	SchemasRevision uint64                       `json:"schemasRevision"`
+	ConfigRevision  uint64                       `json:"configRevision"`
+	Caches          map[string]CacheCounterStats `json:"caches"`
+}
+
+// CacheCounterStats describes the size and hit rate of one cache
+type CacheCounterStats struct {
+	Entries int     `json:"entries"`
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+}
+
+// CacheStats returns the current state of the caches
+func (r *Registry) CacheStats() CacheStats {
+	r.cacheMu.RLock()
+	defer r.cacheMu.RUnlock()
+
+	entries := map[string]int{
+		cacheSchemas:  len(r.schemaCache),
+		cacheSubjects: len(r.subjectCache),
+		cacheConfig:   len(r.configCache),
+	}
+	for _, versions := range r.versionCache {
+		entries[cacheVersions] += len(versions)
+	}
+
+	stats := CacheStats{
+		Hydrated:        r.schemasView.hydrated && r.configView.hydrated,
+		SchemasRevision: r.schemasView.revision,
+		ConfigRevision:  r.configView.revision,
+		Caches:          make(map[string]CacheCounterStats, len(r.cacheCounters)),
+	}
+	for name, counter := range r.cacheCounters {
+		s := CacheCounterStats{
+			Entries: entries[name],
+			Hits:    counter.hits.Load(),
+			Misses:  counter.misses.Load(),
+		}
+		if total := s.Hits + s.Misses; total > 0 {
+			s.HitRate = float64(s.Hits) / float64(total)
+		}
+		stats.Caches[name] = s
+	}
+	return stats
+}
+
+// watchUpdates watches for changes in the stores
+func (r *Registry) watchUpdates() {
+	// Watch for schema updates
+	schemaWatcher, err := r.kvSchemas.Watch()
+	if err != nil {
+		slog.Error("Failed to watch schema updates", "error", err)
+		return
+	}
+	defer schemaWatcher.Stop()
+
+	// Watch for config updates
+	configWatcher, err := r.kvConfig.Watch()
+	if err != nil {
+		slog.Error("Failed to watch config updates", "error", err)
+		return
+	}
+	defer configWatcher.Stop()
+
+	r.cacheMu.Lock()
+	r.schemasView.watching = true
+	r.configView.watching = true
+	r.cacheMu.Unlock()
+
+	// Once the watcher is gone the cache goes stale, so reads fall back to the store
+	defer func() {
+		r.cacheMu.Lock()
+		r.schemasView = bucketView{lastEvent: r.schemasView.lastEvent}
+		r.configView = bucketView{lastEvent: r.configView.lastEvent}
+		r.notifyApplied()
+		r.cacheMu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-r.stopWatch:
+			return
+		case update, ok := <-schemaWatcher.Updates():
+			if !ok {
+				slog.Warn("Schema watcher closed")
+				return
+			}
+			r.handleSchemaUpdate(update)
+		case update, ok := <-configWatcher.Updates():
+			if !ok {
+				slog.Warn("Config watcher closed")
+				return
+			}
+			r.handleConfigUpdate(update)
+		}
+	}
+}
+
+// handleSchemaUpdate applies an update of the schemas bucket to the cache.
+// A nil update marks the end of the initial values.
+func (r *Registry) handleSchemaUpdate(update *Entry) {
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+
+	r.schemasView.lastEvent = time.Now()
+	if update == nil {
+		r.schemasView.hydrated = true
+		r.signalReady()
+		r.notifyApplied()
+		return
+	}
+
+	r.applySchemaUpdate(update.Key, update.Value, update.Revision, update.Deleted)
+	r.schemasView.revision = max(r.schemasView.revision, update.Revision)
+	subjectsGauge.Set(float64(len(r.subjectCache)))
+	schemasGauge.Set(float64(len(r.schemaCache)))
+	r.notifyApplied()
+}
+
+// handleConfigUpdate applies an update of the config bucket to the cache.
+// A nil update marks the end of the initial values.
+func (r *Registry) handleConfigUpdate(update *Entry) {
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+
+	r.configView.lastEvent = time.Now()
+	if update == nil {
+		r.configView.hydrated = true
+		r.signalReady()
+		r.notifyApplied()
+		return
+	}
+
+	key := update.Key
+	if r.isStale(key, update.Revision) {
+		return
+	}
+	r.cacheRevisions[key] = update.Revision
+	if update.Deleted {
+		delete(r.configCache, key)
+	} else {
+		r.configCache[key] = update.Value
+	}
+	if key == keyACL {
+		r.applyACLUpdate(update.Value, update.Deleted)
+	}
+	r.configView.revision = max(r.configView.revision, update.Revision)
+	r.notifyApplied()
+}
+
+// applySchemaUpdate applies a change to a key of the schemas bucket. Changes
+// older than the one already applied to the key are ignored. Must be called
+// with cacheMu held.
+func (r *Registry) applySchemaUpdate(key string, value []byte, revision uint64, deleted bool) {
+	context, rest := splitKey(key)
+	var cache string
+	switch {
+	case strings.HasPrefix(rest, keyPrefixSchemas):
+		cache = cacheSchemas
+	case strings.HasPrefix(rest, keyPrefixSubjects):
+		cache = cacheVersions
+	default:
+		// Indexes and counters are always read from the store
+		return
+	}
+	if r.isStale(key, revision) {
+		return
+	}
+	r.cacheRevisions[key] = revision
+
+	var schema types.Schema
+	if !deleted {
+		if err := json.Unmarshal(value, &schema); err != nil {
+			slog.Error("Failed to unmarshal schema update", "key", key, "error", err)
+			return
+		}
+	}
+
+	if cache == cacheSchemas {
+		if _, err := strconv.Atoi(strings.TrimPrefix(rest, keyPrefixSchemas)); err != nil {
+			return
+		}
+		if deleted {
+			delete(r.schemaCache, key)
+		} else {
+			r.schemaCache[key] = &cacheEntry{schema: &schema, revision: revision}
+		}
+		return
+	}
+
+	// Keys look like subjects/{subject}/versions/{version} within a context
+	rest = strings.TrimPrefix(rest, keyPrefixSubjects)
+	i := strings.LastIndex(rest, "/versions/")
+	if i < 0 {
+		return
+	}
+	subject := QualifySubject(context, rest[:i])
+	version, err := strconv.Atoi(rest[i+len("/versions/"):])
+	if err != nil {
+		return
+	}
+
+	versions := r.subjectCache[subject]
+	pos := sort.SearchInts(versions, version)
+	exists := pos < len(versions) && versions[pos] == version
+
+	if deleted {
+		if exists {
+			versions = append(versions[:pos], versions[pos+1:]...)
+		}
+		delete(r.versionCache[subject], version)
+		if len(versions) == 0 {
+			delete(r.subjectCache, subject)
+			delete(r.versionCache, subject)
+		} else {
+			r.subjectCache[subject] = versions
+		}
+		return
+	}
+
+	if !exists {
+		versions = append(versions, 0)
+		copy(versions[pos+1:], versions[pos:])
+		versions[pos] = version
+		r.subjectCache[subject] = versions
+	}
+	if r.versionCache[subject] == nil {
+		r.versionCache[subject] = make(map[int]*cacheEntry)
+	}
+	r.versionCache[subject][version] = &cacheEntry{schema: &schema, revision: revision}
+}
+
+// isStale reports whether a change to a key is older than the one already
+// applied. Must be called with cacheMu held.
+func (r *Registry) isStale(key string, revision uint64) bool {
+	applied, ok := r.cacheRevisions[key]
+	return ok && revision <= applied
+}
+
+// signalReady closes the ready channel once both buckets are hydrated. Must be
+// called with cacheMu held.
+func (r *Registry) signalReady() {
+	if !r.schemasView.hydrated || !r.configView.hydrated {
+		return
+	}
+	select {
+	case <-r.ready:
+	default:
+		close(r.ready)
+	}
+}
+
+// notifyApplied wakes up writes waiting in syncCache. Must be called with cacheMu held.
+func (r *Registry) notifyApplied() {
+	close(r.cacheApplied)
+	r.cacheApplied = make(chan struct{})
+}
+
+// fillSchemaCache adds a key read from the store to the cache, if the cache is
+// hydrated and has not already applied a newer change to it
+func (r *Registry) fillSchemaCache(entry *Entry) {
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+
+	if r.schemasView.hydrated {
+		r.applySchemaUpdate(entry.Key, entry.Value, entry.Revision, false)
+	}
+}
+
+// cachedSchema gets a schema by the key of its ID from the cache
+func (r *Registry) cachedSchema(key string) (*types.Schema, bool) {
+	r.cacheMu.RLock()
+	entry, ok := r.schemaCache[key]
+	ok = ok && r.schemasView.hydrated
+	r.cacheMu.RUnlock()
+
+	r.cacheCounters[cacheSchemas].record(ok)
+	if !ok {
+		return nil, false
+	}
+	schema := *entry.schema
+	return &schema, true
+}
+
+// cachedVersion gets a subject version from the cache
+func (r *Registry) cachedVersion(subject string, version int) (*types.Schema, bool) {
+	r.cacheMu.RLock()
+	entry, ok := r.versionCache[subject][version]
+	ok = ok && r.schemasView.hydrated
+	r.cacheMu.RUnlock()
+
+	r.cacheCounters[cacheVersions].record(ok)
+	if !ok {
+		return nil, false
+	}
+	schema := *entry.schema
+	return &schema, true
+}
+
+// cachedVersions lists the versions of a subject from the cache, optionally
+// including soft-deleted versions. ok is false if the cache cannot answer.
+func (r *Registry) cachedVersions(subject string, includeDeleted bool) (versions []int, ok bool) {
+	r.cacheMu.RLock()
+	defer func() {
+		r.cacheMu.RUnlock()
+		r.cacheCounters[cacheSubjects].record(ok)
+	}()
+
+	if !r.schemasView.hydrated {
+		return nil, false
+	}
+	for _, version := range r.subjectCache[subject] {
+		if entry := r.versionCache[subject][version]; entry != nil && (includeDeleted || !entry.schema.Deleted) {
+			versions = append(versions, version)
+		}
+	}
+	return versions, true
+}
+
+// cacheDisagrees returns the cached versions of a subject that its index
+// misses or marks deleted differently. ok is false if the cache cannot tell.
+// As the cache may lag behind, these versions are only suspects.
+func (r *Registry) cacheDisagrees(subject string, idx *subjectIndex) (versions []int, ok bool) {
+	r.cacheMu.RLock()
+	defer r.cacheMu.RUnlock()
+
+	if !r.schemasView.hydrated {
+		return nil, false
+	}
+	for _, version := range r.subjectCache[subject] {
+		entry := r.versionCache[subject][version]
+		if entry == nil {
+			continue
+		}
+		if indexed := idx.find(version); indexed == nil || indexed.Deleted != entry.schema.Deleted {
+			versions = append(versions, version)
+		}
+	}
+	return versions, true
+}
+
+// cachedSubjects lists the subjects with versions from the cache, optionally
+// including subjects whose versions are all soft-deleted. ok is false if the
+// cache cannot answer.
+func (r *Registry) cachedSubjects(includeDeleted bool) (subjects []string, ok bool) {
+	r.cacheMu.RLock()
+	defer func() {
+		r.cacheMu.RUnlock()
+		r.cacheCounters[cacheSubjects].record(ok)
+	}()
+
+	if !r.schemasView.hydrated {
+		return nil, false
+	}
+	for subject, versions := range r.subjectCache {
+		for _, version := range versions {
+			if entry := r.versionCache[subject][version]; entry != nil && (includeDeleted || !entry.schema.Deleted) {
+				subjects = append(subjects, subject)
+				break
+			}
+		}
+	}
+	return subjects, true
+}
+
+// cachedContexts lists the contexts holding schemas, subjects or config from
+// the cache. ok is false if the cache cannot answer.
+func (r *Registry) cachedContexts() (contexts map[string]bool, ok bool) {
+	r.cacheMu.RLock()
+	defer r.cacheMu.RUnlock()
+
+	if !r.schemasView.hydrated || !r.configView.hydrated {
+		return nil, false
+	}
+	contexts = make(map[string]bool)
+	for key := range r.schemaCache {
+		context, _ := splitKey(key)
+		contexts[context] = true
+	}
+	for subject := range r.subjectCache {
+		contexts[ContextOf(subject)] = true
+	}
+	for key := range r.configCache {
+		context, _ := splitKey(key)
+		contexts[context] = true
+	}
+	return contexts, true
+}
+
+// getConfigValue reads a key of the config bucket, from the cache once it has
+// been hydrated. found is false if the key does not exist.
+func (r *Registry) getConfigValue(ctx context.Context, key string) (value []byte, found bool, err error) {
+	r.cacheMu.RLock()
+	hydrated := r.configView.hydrated
+	if hydrated {
+		value, found = r.configCache[key]
+	}
+	r.cacheMu.RUnlock()
+
+	r.cacheCounters[cacheConfig].record(hydrated)
+	if hydrated {
+		return value, found, nil
+	}
+
+	entry, err := r.configStore(ctx).Get(key)
+	if err == ErrKeyNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return entry.Value, true, nil
+}
+
+// syncCache waits until the watcher has applied every change made to a bucket
+// so far. Writes call it before returning so that the reads that follow see them.
+func (r *Registry) syncCache(kv Store, view *bucketView) {
+	r.cacheMu.RLock()
+	watching := view.watching
+	r.cacheMu.RUnlock()
+	if !watching {
+		return
+	}
+
+	target, err := kv.Revision()
+	if err != nil {
+		return
+	}
+
+	timer := time.NewTimer(cacheSyncTimeout)
+	defer timer.Stop()
+	for {
+		r.cacheMu.RLock()
+		applied, watching, notify := view.revision, view.watching, r.cacheApplied
+		r.cacheMu.RUnlock()
+		if applied >= target || !watching {
+			return
+		}
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			slog.Warn("Timed out waiting for cache to catch up", "bucket", kv.Name(), "revision", target, "applied", applied)
+			return
+		}
+	}
+}
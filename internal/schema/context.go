@@ -0,0 +1,166 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Contexts are namespaces of subjects, each with its own schema IDs and
+// config. A subject is placed in a context by qualifying its name, as in
+// :.team:orders for the subject orders of the context .team. Subjects without
+// a qualifier are in the default context, whose keys keep the unprefixed
+// layout. Since keys cannot contain ':', the keys of other contexts are
+// prefixed with contexts/{name}/ instead, {name} being the context without its
+// leading dot.
+
+const (
+	// DefaultContext is the context of subjects that are not qualified
+	DefaultContext = "."
+
+	// Prefix of the keys of every context but the default one
+	keyPrefixContexts = "contexts/" // contexts/{context}/...
+)
+
+// contextNamePattern matches the names of contexts other than the default one
+var contextNamePattern = regexp.MustCompile(`^\.[A-Za-z0-9_-]+$`)
+
+// SplitSubject returns the context of a subject and its name within the
+// context. Subjects that are not qualified with a valid context are in the
+// default context. A qualifier without a name, such as :.team:, stands for the
+// context itself and yields an empty name.
+func SplitSubject(subject string) (context, name string) {
+	if !strings.HasPrefix(subject, ":.") {
+		return DefaultContext, subject
+	}
+	end := strings.Index(subject[1:], ":")
+	if end < 0 {
+		return DefaultContext, subject
+	}
+	context, name = subject[1:end+1], subject[end+2:]
+	if context != DefaultContext && !contextNamePattern.MatchString(context) {
+		return DefaultContext, subject
+	}
+	return context, name
+}
+
+// ContextOf returns the context of a subject
+func ContextOf(subject string) string {
+	context, _ := SplitSubject(subject)
+	return context
+}
+
+// QualifySubject returns the subject for a name within a context
+func QualifySubject(context, name string) string {
+	if context == DefaultContext {
+		return name
+	}
+	return ":" + context + ":" + name
+}
+
+// contextPrefix returns the prefix of the keys of a context
+func contextPrefix(context string) string {
+	if context == DefaultContext {
+		return ""
+	}
+	return keyPrefixContexts + strings.TrimPrefix(context, ".") + "/"
+}
+
+// splitKey returns the context a key belongs to and the key within the context
+func splitKey(key string) (context, rest string) {
+	if !strings.HasPrefix(key, keyPrefixContexts) {
+		return DefaultContext, key
+	}
+	rest = strings.TrimPrefix(key, keyPrefixContexts)
+	name, rest, ok := strings.Cut(rest, "/")
+	if !ok {
+		return DefaultContext, key
+	}
+	return "." + name, rest
+}
+
+// subjectKey returns the key of a subject-scoped record, the subject's name
+// within its context following prefix
+func subjectKey(prefix, subject string) string {
+	context, name := SplitSubject(subject)
+	return contextPrefix(context) + prefix + name
+}
+
+// versionKey returns the key of a subject version
+func versionKey(subject string, version int) string {
+	return fmt.Sprintf("%s/versions/%d", subjectKey(keyPrefixSubjects, subject), version)
+}
+
+// schemaKey returns the key of the schema stored under an ID in a context
+func schemaKey(context string, id int) string {
+	return contextPrefix(context) + keyPrefixSchemas + strconv.Itoa(id)
+}
+
+// hashIndexKey returns the key mapping a schema's content to its ID in a context
+func hashIndexKey(context, hash string) string {
+	return contextPrefix(context) + keyPrefixHashIndex + hash
+}
+
+// configKeys returns the config keys consulted for a subject, most specific
+// first: the subject's own key, then its context's key and finally the global
+// key. A subject naming only a context, such as :.team:, starts at the
+// context's key.
+func configKeys(subject, subjectPrefix, globalKey string) []string {
+	if subject == "global" {
+		return []string{globalKey}
+	}
+	context, name := SplitSubject(subject)
+	var keys []string
+	if name != "" {
+		keys = append(keys, contextPrefix(context)+subjectPrefix+name)
+	}
+	if context != DefaultContext {
+		keys = append(keys, contextPrefix(context)+globalKey)
+	}
+	return append(keys, globalKey)
+}
+
+// qualifyReference returns the subject a reference of a schema in context
+// points to. References to unqualified subjects stay within the context.
+func qualifyReference(context, subject string) string {
+	if strings.HasPrefix(subject, ":.") {
+		return subject
+	}
+	return QualifySubject(context, subject)
+}
+
+// GetContexts returns the default context and every context holding subjects
+// or config, ordered by name
+func (r *Registry) GetContexts(ctx context.Context) (_ []string, err error) {
+	ctx, span := startSpan(ctx, "Registry.GetContexts")
+	defer func() { endSpan(span, err) }()
+
+	seen, ok := r.cachedContexts()
+	if !ok {
+		// Until the cache is hydrated, walk the keys of both buckets
+		keys, err := r.schemaStore(ctx).List("")
+		if err != nil {
+			return nil, fmt.Errorf("get schema keys: %w", err)
+		}
+		configKeys, err := r.configStore(ctx).List("")
+		if err != nil {
+			return nil, fmt.Errorf("get config keys: %w", err)
+		}
+		seen = make(map[string]bool)
+		for _, key := range append(keys, configKeys...) {
+			context, _ := splitKey(key)
+			seen[context] = true
+		}
+	}
+
+	seen[DefaultContext] = true
+	contexts := make([]string, 0, len(seen))
+	for context := range seen {
+		contexts = append(contexts, context)
+	}
+	sort.Strings(contexts)
+	return contexts, nil
+}
@@ -0,0 +1,183 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// primitiveTypes are the Avro types that are referred to by a bare name
+var primitiveTypes = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// canonicalKeys lists the attributes kept in the canonical form, in the order
+// they are written
+var canonicalKeys = []string{"name", "type", "fields", "symbols", "items", "values", "size", "logicalType", "precision", "scale", "default"}
+
+// canonicalForm returns the Parsing Canonical Form of a schema: names are
+// replaced by full names, namespaces, docs and aliases are dropped, attributes
+// are written in a fixed order and whitespace is removed. Unlike the Parsing
+// Canonical Form, defaults and logical types are kept since they change how
+// data is read.
+func canonicalForm(schemaStr string) (string, error) {
+	decoder := json.NewDecoder(strings.NewReader(schemaStr))
+	decoder.UseNumber()
+	var schema interface{}
+	if err := decoder.Decode(&schema); err != nil {
+		return "", fmt.Errorf("parse schema: %w", err)
+	}
+
+	var b bytes.Buffer
+	if err := writeCanonical(&b, schema, ""); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// writeCanonical writes the canonical form of a schema node, with names
+// resolved against the enclosing namespace
+func writeCanonical(b *bytes.Buffer, schema interface{}, namespace string) error {
+	switch s := schema.(type) {
+	case string:
+		if primitiveTypes[s] {
+			return writeJSON(b, s)
+		}
+		return writeJSON(b, fullName(s, namespace))
+	case []interface{}:
+		b.WriteByte('[')
+		for i, member := range s {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writeCanonical(b, member, namespace); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+		return nil
+	case map[string]interface{}:
+		return writeCanonicalObject(b, s, namespace)
+	default:
+		return fmt.Errorf("invalid schema node %v", schema)
+	}
+}
+
+// writeCanonicalObject writes the canonical form of a schema given as an object
+func writeCanonicalObject(b *bytes.Buffer, s map[string]interface{}, namespace string) error {
+	typ, ok := s["type"].(string)
+	if !ok {
+		// A type given as a nested schema
+		return writeCanonical(b, s["type"], namespace)
+	}
+	if primitiveTypes[typ] && s["logicalType"] == nil {
+		return writeJSON(b, typ)
+	}
+
+	attrs := make(map[string]func() error)
+	switch typ {
+	case "record", "error", "enum", "fixed":
+		name, _ := s["name"].(string)
+		if ns, ok := s["namespace"].(string); ok && !strings.Contains(name, ".") {
+			namespace = ns
+		}
+		name = fullName(name, namespace)
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			namespace = name[:i]
+		} else {
+			namespace = ""
+		}
+		attrs["name"] = func() error { return writeJSON(b, name) }
+	}
+
+	attrs["type"] = func() error { return writeJSON(b, typ) }
+	if fields, ok := s["fields"].([]interface{}); ok {
+		attrs["fields"] = func() error { return writeCanonicalFields(b, fields, namespace) }
+	}
+	for _, key := range []string{"items", "values"} {
+		if v, ok := s[key]; ok {
+			attrs[key] = func() error { return writeCanonical(b, v, namespace) }
+		}
+	}
+	for _, key := range []string{"symbols", "size", "logicalType", "precision", "scale", "default"} {
+		if v, ok := s[key]; ok {
+			attrs[key] = func() error { return writeJSON(b, v) }
+		}
+	}
+
+	return writeAttributes(b, attrs)
+}
+
+// writeCanonicalFields writes the fields of a record
+func writeCanonicalFields(b *bytes.Buffer, fields []interface{}, namespace string) error {
+	b.WriteByte('[')
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		field, ok := f.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid field %v", f)
+		}
+
+		attrs := map[string]func() error{
+			"name": func() error { return writeJSON(b, field["name"]) },
+			"type": func() error { return writeCanonical(b, field["type"], namespace) },
+		}
+		if v, ok := field["default"]; ok {
+			attrs["default"] = func() error { return writeJSON(b, v) }
+		}
+		if err := writeAttributes(b, attrs); err != nil {
+			return err
+		}
+	}
+	b.WriteByte(']')
+	return nil
+}
+
+// writeAttributes writes an object with the given attributes in canonical order
+func writeAttributes(b *bytes.Buffer, attrs map[string]func() error) error {
+	b.WriteByte('{')
+	first := true
+	for _, key := range canonicalKeys {
+		write, ok := attrs[key]
+		if !ok {
+			continue
+		}
+		if !first {
+			b.WriteByte(',')
+		}
+		first = false
+		if err := writeJSON(b, key); err != nil {
+			return err
+		}
+		b.WriteByte(':')
+		if err := write(); err != nil {
+			return err
+		}
+	}
+	b.WriteByte('}')
+	return nil
+}
+
+// writeJSON writes a value as compact JSON without escaping HTML characters
+func writeJSON(b *bytes.Buffer, v interface{}) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	b.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	return nil
+}
+
+// fullName qualifies a name with a namespace unless it already is qualified
+func fullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
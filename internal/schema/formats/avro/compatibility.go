@@ -0,0 +1,269 @@
+package avro
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"schemaregistry/internal/schema/types"
+
+	"github.com/hamba/avro/v2"
+)
+
+// Rules reported in violations, named after the Confluent incompatibility types
+const (
+	ruleNameMismatch              = "NAME_MISMATCH"
+	ruleFixedSizeMismatch         = "FIXED_SIZE_MISMATCH"
+	ruleMissingEnumSymbols        = "MISSING_ENUM_SYMBOLS"
+	ruleReaderFieldMissingDefault = "READER_FIELD_MISSING_DEFAULT_VALUE"
+	ruleTypeMismatch              = "TYPE_MISMATCH"
+	ruleMissingUnionBranch        = "MISSING_UNION_BRANCH"
+)
+
+// incompatibility describes why data written with one schema cannot be read
+// with another
+type incompatibility struct {
+	path       string
+	rule       string
+	readerType string
+	writerType string
+	message    string
+}
+
+// violation converts an incompatibility into a violation of a new schema. The
+// new schema is the reader when checking backward compatibility and the
+// writer when checking forward compatibility.
+func (i incompatibility) violation(newIsReader bool) types.Violation {
+	v := types.Violation{Path: i.path, Rule: i.rule, Message: i.message}
+	if newIsReader {
+		v.OldType, v.NewType = i.writerType, i.readerType
+	} else {
+		v.OldType, v.NewType = i.readerType, i.writerType
+	}
+	return v
+}
+
+// resolver checks whether data written with a writer schema can be read with
+// a reader schema, following the schema resolution rules of the Avro
+// specification
+type resolver struct {
+	// Pairs of named types already being compared, so recursive types terminate
+	seen map[[2]string]bool
+
+	problems []incompatibility
+}
+
+// resolve returns the reasons why reader cannot read data written with writer
+func resolve(reader, writer avro.Schema) []incompatibility {
+	r := &resolver{seen: make(map[[2]string]bool)}
+	r.check(reader, writer, "$")
+	return r.problems
+}
+
+// fail records an incompatibility at path
+func (r *resolver) fail(path, rule string, reader, writer avro.Schema, format string, args ...any) {
+	r.problems = append(r.problems, incompatibility{
+		path:       path,
+		rule:       rule,
+		readerType: typeName(reader),
+		writerType: typeName(writer),
+		message:    fmt.Sprintf(format, args...),
+	})
+}
+
+// check compares a reader and writer schema at path
+func (r *resolver) check(reader, writer avro.Schema, path string) {
+	reader, writer = deref(reader), deref(writer)
+
+	// Every branch a writer union may have written must be readable
+	if w, ok := writer.(*avro.UnionSchema); ok {
+		for _, branch := range w.Types() {
+			r.check(reader, branch, path)
+		}
+		return
+	}
+
+	// A reader union reads the writer data with the first branch that matches it
+	if u, ok := reader.(*avro.UnionSchema); ok {
+		for _, branch := range u.Types() {
+			trial := &resolver{seen: maps.Clone(r.seen)}
+			trial.check(branch, writer, path)
+			if len(trial.problems) == 0 {
+				return
+			}
+		}
+		r.fail(path, ruleMissingUnionBranch, reader, writer, "reader union %s has no branch matching writer type %s", typeName(reader), typeName(writer))
+		return
+	}
+
+	switch w := writer.(type) {
+	case *avro.RecordSchema:
+		rd, ok := reader.(*avro.RecordSchema)
+		if !ok {
+			r.fail(path, ruleTypeMismatch, reader, writer, "writer record %s cannot be read as %s", w.FullName(), typeName(reader))
+			return
+		}
+		r.checkRecord(rd, w, path)
+	case *avro.EnumSchema:
+		rd, ok := reader.(*avro.EnumSchema)
+		if !ok {
+			r.fail(path, ruleTypeMismatch, reader, writer, "writer enum %s cannot be read as %s", w.FullName(), typeName(reader))
+			return
+		}
+		r.checkEnum(rd, w, path)
+	case *avro.FixedSchema:
+		rd, ok := reader.(*avro.FixedSchema)
+		if !ok {
+			r.fail(path, ruleTypeMismatch, reader, writer, "writer fixed %s cannot be read as %s", w.FullName(), typeName(reader))
+			return
+		}
+		if !namesMatch(rd.FullName(), rd.Aliases(), w.FullName()) {
+			r.fail(path, ruleNameMismatch, rd, w, "fixed name changed from %s to %s", w.FullName(), rd.FullName())
+		}
+		if rd.Size() != w.Size() {
+			r.fail(path, ruleFixedSizeMismatch, rd, w, "fixed size changed from %d to %d", w.Size(), rd.Size())
+		}
+	case *avro.ArraySchema:
+		rd, ok := reader.(*avro.ArraySchema)
+		if !ok {
+			r.fail(path, ruleTypeMismatch, reader, writer, "writer array cannot be read as %s", typeName(reader))
+			return
+		}
+		r.check(rd.Items(), w.Items(), path+"[]")
+	case *avro.MapSchema:
+		rd, ok := reader.(*avro.MapSchema)
+		if !ok {
+			r.fail(path, ruleTypeMismatch, reader, writer, "writer map cannot be read as %s", typeName(reader))
+			return
+		}
+		r.check(rd.Values(), w.Values(), path+"{}")
+	default:
+		if !promotable(writer.Type(), reader.Type()) {
+			r.fail(path, ruleTypeMismatch, reader, writer, "writer type %s cannot be read as %s", typeName(writer), typeName(reader))
+		}
+	}
+}
+
+// checkRecord compares the fields of a reader and writer record
+func (r *resolver) checkRecord(reader, writer *avro.RecordSchema, path string) {
+	if !namesMatch(reader.FullName(), reader.Aliases(), writer.FullName()) {
+		r.fail(path, ruleNameMismatch, reader, writer, "record name changed from %s to %s", writer.FullName(), reader.FullName())
+		return
+	}
+
+	pair := [2]string{reader.FullName(), writer.FullName()}
+	if r.seen[pair] {
+		return
+	}
+	r.seen[pair] = true
+
+	// Fields are matched by name or reader alias; writer fields the reader
+	// doesn't know are skipped
+	for _, field := range reader.Fields() {
+		fieldPath := path + "." + field.Name()
+		wf := findField(writer, field)
+		if wf == nil {
+			if !field.HasDefault() {
+				r.fail(fieldPath, ruleReaderFieldMissingDefault, field.Type(), nil, "reader field %s is missing from the writer and has no default value", field.Name())
+			}
+			continue
+		}
+		r.check(field.Type(), wf.Type(), fieldPath)
+	}
+}
+
+// checkEnum verifies that every writer symbol can be read
+func (r *resolver) checkEnum(reader, writer *avro.EnumSchema, path string) {
+	if !namesMatch(reader.FullName(), reader.Aliases(), writer.FullName()) {
+		r.fail(path, ruleNameMismatch, reader, writer, "enum name changed from %s to %s", writer.FullName(), reader.FullName())
+		return
+	}
+
+	// Unknown symbols resolve to the reader's default symbol, if it has one
+	if reader.Default() != "" {
+		return
+	}
+	symbols := reader.Symbols()
+	for _, symbol := range writer.Symbols() {
+		if !slices.Contains(symbols, symbol) {
+			r.fail(path, ruleMissingEnumSymbols, reader, writer, "writer enum symbol %s is missing from the reader and the reader has no default symbol", symbol)
+		}
+	}
+}
+
+// findField finds the writer field matching a reader field by name or alias
+func findField(writer *avro.RecordSchema, field *avro.Field) *avro.Field {
+	for _, wf := range writer.Fields() {
+		if wf.Name() == field.Name() || slices.Contains(field.Aliases(), wf.Name()) {
+			return wf
+		}
+	}
+	return nil
+}
+
+// namesMatch reports whether a reader named type matches a writer one, by full
+// name, unqualified name or one of the reader's aliases
+func namesMatch(readerName string, readerAliases []string, writerName string) bool {
+	if readerName == writerName || unqualified(readerName) == unqualified(writerName) {
+		return true
+	}
+	for _, alias := range readerAliases {
+		if alias == writerName || unqualified(alias) == unqualified(writerName) {
+			return true
+		}
+	}
+	return false
+}
+
+// unqualified strips the namespace from a full name
+func unqualified(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// promotable reports whether a primitive writer type can be read as a reader type
+func promotable(writer, reader avro.Type) bool {
+	if writer == reader {
+		return true
+	}
+	switch writer {
+	case avro.Int:
+		return reader == avro.Long || reader == avro.Float || reader == avro.Double
+	case avro.Long:
+		return reader == avro.Float || reader == avro.Double
+	case avro.Float:
+		return reader == avro.Double
+	case avro.String:
+		return reader == avro.Bytes
+	case avro.Bytes:
+		return reader == avro.String
+	}
+	return false
+}
+
+// deref resolves references to named types
+func deref(schema avro.Schema) avro.Schema {
+	if ref, ok := schema.(*avro.RefSchema); ok {
+		return ref.Schema()
+	}
+	return schema
+}
+
+// typeName describes a schema for messages
+func typeName(schema avro.Schema) string {
+	if schema == nil {
+		return ""
+	}
+	switch s := deref(schema).(type) {
+	case avro.NamedSchema:
+		return fmt.Sprintf("%s %s", s.Type(), s.FullName())
+	case *avro.UnionSchema:
+		names := make([]string, 0, len(s.Types()))
+		for _, t := range s.Types() {
+			names = append(names, typeName(t))
+		}
+		return "[" + strings.Join(names, ", ") + "]"
+	default:
+		return string(s.Type())
+	}
+}
@@ -0,0 +1,255 @@
+package avro
+
+import (
+	"strings"
+	"testing"
+
+	"schemaregistry/internal/schema/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat_CheckCompatibility(t *testing.T) {
+	tests := []struct {
+		name          string
+		oldSchema     string
+		newSchema     string
+		level         types.CompatibilityLevel
+		wantViolation string
+	}{
+		{
+			name:      "Added Field With Default",
+			oldSchema: `{"type": "record", "name": "User", "fields": [{"name": "name", "type": "string"}]}`,
+			newSchema: `{"type": "record", "name": "User", "fields": [{"name": "name", "type": "string"}, {"name": "age", "type": "int", "default": 0}]}`,
+			level:     types.Full,
+		},
+		{
+			name:          "Added Field Without Default",
+			oldSchema:     `{"type": "record", "name": "User", "fields": [{"name": "name", "type": "string"}]}`,
+			newSchema:     `{"type": "record", "name": "User", "fields": [{"name": "name", "type": "string"}, {"name": "age", "type": "int"}]}`,
+			level:         types.Backward,
+			wantViolation: "READER_FIELD_MISSING_DEFAULT_VALUE at $.age: reader field age is missing from the writer and has no default value (old type none, new type int)",
+		},
+		{
+			name:      "Removed Field Is Backward Compatible",
+			oldSchema: `{"type": "record", "name": "User", "fields": [{"name": "name", "type": "string"}, {"name": "age", "type": "int"}]}`,
+			newSchema: `{"type": "record", "name": "User", "fields": [{"name": "name", "type": "string"}]}`,
+			level:     types.Backward,
+		},
+		{
+			name:          "Removed Field Without Default Is Not Forward Compatible",
+			oldSchema:     `{"type": "record", "name": "User", "fields": [{"name": "name", "type": "string"}, {"name": "age", "type": "int"}]}`,
+			newSchema:     `{"type": "record", "name": "User", "fields": [{"name": "name", "type": "string"}]}`,
+			level:         types.Forward,
+			wantViolation: "$.age: reader field age is missing from the writer",
+		},
+		{
+			name:      "Renamed Field With Alias",
+			oldSchema: `{"type": "record", "name": "User", "fields": [{"name": "name", "type": "string"}]}`,
+			newSchema: `{"type": "record", "name": "User", "fields": [{"name": "fullName", "aliases": ["name"], "type": "string"}]}`,
+			level:     types.Backward,
+		},
+		{
+			name:      "Renamed Record With Alias",
+			oldSchema: `{"type": "record", "name": "User", "namespace": "com.example", "fields": [{"name": "name", "type": "string"}]}`,
+			newSchema: `{"type": "record", "name": "Person", "namespace": "com.example", "aliases": ["com.example.User"], "fields": [{"name": "name", "type": "string"}]}`,
+			level:     types.Backward,
+		},
+		{
+			name:          "Renamed Record Without Alias",
+			oldSchema:     `{"type": "record", "name": "User", "fields": [{"name": "name", "type": "string"}]}`,
+			newSchema:     `{"type": "record", "name": "Person", "fields": [{"name": "name", "type": "string"}]}`,
+			level:         types.Backward,
+			wantViolation: "record name changed from User to Person",
+		},
+		{
+			name:      "Promoted Type",
+			oldSchema: `{"type": "record", "name": "User", "fields": [{"name": "age", "type": "int"}]}`,
+			newSchema: `{"type": "record", "name": "User", "fields": [{"name": "age", "type": "long"}]}`,
+			level:     types.Backward,
+		},
+		{
+			name:          "Demoted Type",
+			oldSchema:     `{"type": "record", "name": "User", "fields": [{"name": "age", "type": "long"}]}`,
+			newSchema:     `{"type": "record", "name": "User", "fields": [{"name": "age", "type": "int"}]}`,
+			level:         types.Backward,
+			wantViolation: "TYPE_MISMATCH at $.age: writer type long cannot be read as int (old type long, new type int)",
+		},
+		{
+			name:      "Widened Union",
+			oldSchema: `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}]}`,
+			newSchema: `{"type": "record", "name": "User", "fields": [{"name": "id", "type": ["null", "string"], "default": null}]}`,
+			level:     types.Backward,
+		},
+		{
+			name:          "Narrowed Union",
+			oldSchema:     `{"type": "record", "name": "User", "fields": [{"name": "id", "type": ["null", "string", "long"]}]}`,
+			newSchema:     `{"type": "record", "name": "User", "fields": [{"name": "id", "type": ["null", "string"]}]}`,
+			level:         types.Backward,
+			wantViolation: "MISSING_UNION_BRANCH at $.id: reader union [null, string] has no branch matching writer type long",
+		},
+		{
+			name:      "Enum Symbol Removed With Default",
+			oldSchema: `{"type": "enum", "name": "Color", "symbols": ["RED", "GREEN", "BLUE"]}`,
+			newSchema: `{"type": "enum", "name": "Color", "symbols": ["UNKNOWN", "RED", "GREEN"], "default": "UNKNOWN"}`,
+			level:     types.Backward,
+		},
+		{
+			name:          "Enum Symbol Removed Without Default",
+			oldSchema:     `{"type": "enum", "name": "Color", "symbols": ["RED", "GREEN", "BLUE"]}`,
+			newSchema:     `{"type": "enum", "name": "Color", "symbols": ["RED", "GREEN"]}`,
+			level:         types.Backward,
+			wantViolation: "MISSING_ENUM_SYMBOLS at $: writer enum symbol BLUE is missing from the reader",
+		},
+		{
+			name:          "Fixed Size Changed",
+			oldSchema:     `{"type": "fixed", "name": "Hash", "size": 16}`,
+			newSchema:     `{"type": "fixed", "name": "Hash", "size": 32}`,
+			level:         types.Backward,
+			wantViolation: "FIXED_SIZE_MISMATCH at $: fixed size changed from 16 to 32",
+		},
+		{
+			name:          "Nested Record Field",
+			oldSchema:     `{"type": "record", "name": "User", "fields": [{"name": "address", "type": {"type": "record", "name": "Address", "fields": [{"name": "zip", "type": "string"}]}}]}`,
+			newSchema:     `{"type": "record", "name": "User", "fields": [{"name": "address", "type": {"type": "record", "name": "Address", "fields": [{"name": "zip", "type": "int"}]}}]}`,
+			level:         types.Backward,
+			wantViolation: "$.address.zip: writer type string cannot be read as int",
+		},
+		{
+			name:          "Named Type Reference",
+			oldSchema:     `{"type": "record", "name": "User", "fields": [{"name": "home", "type": {"type": "record", "name": "Address", "fields": [{"name": "zip", "type": "string"}]}}, {"name": "work", "type": "Address"}]}`,
+			newSchema:     `{"type": "record", "name": "User", "fields": [{"name": "home", "type": {"type": "record", "name": "Address", "fields": [{"name": "zip", "type": "string"}, {"name": "city", "type": "string"}]}}, {"name": "work", "type": "Address"}]}`,
+			level:         types.Backward,
+			wantViolation: "$.home.city: reader field city is missing from the writer",
+		},
+		{
+			name:          "Array Items",
+			oldSchema:     `{"type": "array", "items": "string"}`,
+			newSchema:     `{"type": "array", "items": "int"}`,
+			level:         types.Backward,
+			wantViolation: "$[]: writer type string cannot be read as int",
+		},
+		{
+			name:      "Recursive Record",
+			oldSchema: `{"type": "record", "name": "Node", "fields": [{"name": "value", "type": "int"}, {"name": "next", "type": ["null", "Node"]}]}`,
+			newSchema: `{"type": "record", "name": "Node", "fields": [{"name": "value", "type": "long"}, {"name": "next", "type": ["null", "Node"]}]}`,
+			level:     types.Backward,
+		},
+		{
+			name:      "None",
+			oldSchema: `"string"`,
+			newSchema: `"int"`,
+			level:     types.None,
+		},
+	}
+
+	f := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := f.CheckCompatibility(tt.oldSchema, nil, tt.newSchema, nil, tt.level)
+			require.NoError(t, err)
+			if tt.wantViolation == "" {
+				assert.Empty(t, violations)
+				return
+			}
+
+			messages := make([]string, 0, len(violations))
+			for _, v := range violations {
+				messages = append(messages, v.String())
+			}
+			assert.Contains(t, strings.Join(messages, "\n"), tt.wantViolation)
+		})
+	}
+}
+
+func TestFormat_References(t *testing.T) {
+	f := New()
+
+	user := `{"type": "record", "name": "User", "namespace": "com.example", "fields": [{"name": "name", "type": "string"}, {"name": "home", "type": "Address"}]}`
+	refs := []types.ResolvedReference{{
+		Name:    "com.example.Address",
+		Subject: "address",
+		Version: 1,
+		Schema:  `{"type": "record", "name": "Address", "namespace": "com.example", "fields": [{"name": "zip", "type": "string"}]}`,
+	}}
+
+	t.Run("Validate", func(t *testing.T) {
+		assert.NoError(t, f.Validate(user, refs))
+		assert.Error(t, f.Validate(user, nil))
+	})
+
+	t.Run("Serialize Deserialize", func(t *testing.T) {
+		data := map[string]interface{}{"name": "Ada", "home": map[string]interface{}{"zip": "12345"}}
+		payload, err := f.Serialize(data, user, refs)
+		require.NoError(t, err)
+
+		result, err := f.Deserialize(payload, user, refs)
+		require.NoError(t, err)
+		assert.Equal(t, data, result)
+	})
+
+	t.Run("Referenced Type Changed", func(t *testing.T) {
+		newRefs := []types.ResolvedReference{{
+			Name:    "com.example.Address",
+			Subject: "address",
+			Version: 2,
+			Schema:  `{"type": "record", "name": "Address", "namespace": "com.example", "fields": [{"name": "zip", "type": "int"}]}`,
+		}}
+		violations, err := f.CheckCompatibility(user, refs, user, newRefs, types.Backward)
+		require.NoError(t, err)
+		assert.NotEmpty(t, violations)
+	})
+}
+
+func TestFormat_Normalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{
+			name:   "Primitive",
+			schema: `{"type": "string"}`,
+			want:   `"string"`,
+		},
+		{
+			name: "Record",
+			schema: `{
+				"namespace": "com.example", "type": "record", "name": "User", "doc": "A user",
+				"fields": [
+					{"type": "string", "name": "name", "doc": "Full name"},
+					{"name": "age", "type": ["null", "int"], "default": null},
+					{"name": "home", "type": {"type": "record", "name": "Address", "fields": [{"name": "zip", "type": "string"}]}},
+					{"name": "work", "type": "Address"},
+					{"name": "kind", "type": {"type": "enum", "name": "other.Kind", "symbols": ["A", "B"], "default": "A"}},
+					{"name": "tags", "type": {"type": "map", "values": {"type": "array", "items": "string"}}},
+					{"name": "price", "type": {"type": "bytes", "logicalType": "decimal", "precision": 4, "scale": 2}}
+				]
+			}`,
+			want: `{"name":"com.example.User","type":"record","fields":[` +
+				`{"name":"name","type":"string"},` +
+				`{"name":"age","type":["null","int"],"default":null},` +
+				`{"name":"home","type":{"name":"com.example.Address","type":"record","fields":[{"name":"zip","type":"string"}]}},` +
+				`{"name":"work","type":"com.example.Address"},` +
+				`{"name":"kind","type":{"name":"other.Kind","type":"enum","symbols":["A","B"],"default":"A"}},` +
+				`{"name":"tags","type":{"type":"map","values":{"type":"array","items":"string"}}},` +
+				`{"name":"price","type":{"type":"bytes","logicalType":"decimal","precision":4,"scale":2}}]}`,
+		},
+	}
+
+	f := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalized, err := f.Normalize(tt.schema, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, normalized)
+			assert.NoError(t, f.Validate(normalized, nil))
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := f.Normalize(`{"type": "record", "name": "User", "fields": [{"name": "home", "type": "Address"}]}`, nil)
+		assert.Error(t, err)
+	})
+}
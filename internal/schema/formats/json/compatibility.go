@@ -0,0 +1,731 @@
+package json
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"schemaregistry/internal/schema/types"
+)
+
+// Rules reported in violations, named after the Confluent JSON Schema difference types
+const (
+	ruleTypeNarrowed                  = "TYPE_NARROWED"
+	ruleTypeChanged                   = "TYPE_CHANGED"
+	ruleEnumArrayNarrowed             = "ENUM_ARRAY_NARROWED"
+	ruleMaxLengthAdded                = "MAX_LENGTH_ADDED"
+	ruleMaxLengthDecreased            = "MAX_LENGTH_DECREASED"
+	ruleMinLengthAdded                = "MIN_LENGTH_ADDED"
+	ruleMinLengthIncreased            = "MIN_LENGTH_INCREASED"
+	rulePatternAdded                  = "PATTERN_ADDED"
+	rulePatternChanged                = "PATTERN_CHANGED"
+	ruleMaximumAdded                  = "MAXIMUM_ADDED"
+	ruleMaximumDecreased              = "MAXIMUM_DECREASED"
+	ruleMinimumAdded                  = "MINIMUM_ADDED"
+	ruleMinimumIncreased              = "MINIMUM_INCREASED"
+	ruleExclusiveMaximumAdded         = "EXCLUSIVE_MAXIMUM_ADDED"
+	ruleExclusiveMaximumDecreased     = "EXCLUSIVE_MAXIMUM_DECREASED"
+	ruleExclusiveMinimumAdded         = "EXCLUSIVE_MINIMUM_ADDED"
+	ruleExclusiveMinimumIncreased     = "EXCLUSIVE_MINIMUM_INCREASED"
+	ruleMultipleOfAdded               = "MULTIPLE_OF_ADDED"
+	ruleMultipleOfChanged             = "MULTIPLE_OF_CHANGED"
+	ruleMaxPropertiesAdded            = "MAX_PROPERTIES_ADDED"
+	ruleMaxPropertiesDecreased        = "MAX_PROPERTIES_DECREASED"
+	ruleMinPropertiesAdded            = "MIN_PROPERTIES_ADDED"
+	ruleMinPropertiesIncreased        = "MIN_PROPERTIES_INCREASED"
+	ruleMaxItemsAdded                 = "MAX_ITEMS_ADDED"
+	ruleMaxItemsDecreased             = "MAX_ITEMS_DECREASED"
+	ruleMinItemsAdded                 = "MIN_ITEMS_ADDED"
+	ruleMinItemsIncreased             = "MIN_ITEMS_INCREASED"
+	ruleUniqueItemsAdded              = "UNIQUE_ITEMS_ADDED"
+	ruleRequiredAttributeAdded        = "REQUIRED_ATTRIBUTE_ADDED"
+	ruleRequiredPropertyAddedToUnopen = "REQUIRED_PROPERTY_ADDED_TO_UNOPEN_CONTENT_MODEL"
+	rulePropertyAddedToOpen           = "PROPERTY_ADDED_TO_OPEN_CONTENT_MODEL"
+	rulePropertyAddedNotCovered       = "PROPERTY_ADDED_NOT_COVERED_BY_PARTIALLY_OPEN_CONTENT_MODEL"
+	rulePropertyRemovedFromClosed     = "PROPERTY_REMOVED_FROM_CLOSED_CONTENT_MODEL"
+	rulePropertyRemovedNotCovered     = "PROPERTY_REMOVED_NOT_COVERED_BY_PARTIALLY_OPEN_CONTENT_MODEL"
+	ruleAdditionalPropertiesRemoved   = "ADDITIONAL_PROPERTIES_REMOVED"
+	ruleAdditionalPropertiesNarrowed  = "ADDITIONAL_PROPERTIES_NARROWED"
+	ruleSumTypeNarrowed               = "SUM_TYPE_NARROWED"
+	ruleProductTypeExtended           = "PRODUCT_TYPE_EXTENDED"
+	ruleCombinedTypeChanged           = "COMBINED_TYPE_CHANGED"
+)
+
+// checker verifies that every document valid against a writer schema is also
+// valid against a reader schema, recursing through the whole document
+type checker struct {
+	writer document
+	reader document
+
+	// Whether the new schema is the reader, when checking backward compatibility
+	newIsReader bool
+
+	// Pairs of references already being compared, so recursive schemas terminate
+	seen map[[2]string]bool
+
+	violations []types.Violation
+}
+
+// document is the schema document a checker is in, together with the documents
+// that references can name
+type document struct {
+	name string                 // Reference name of the document, empty for the schema itself
+	root interface{}            // Parsed document
+	refs map[string]interface{} // Parsed referenced documents by reference name
+}
+
+// check returns the violations of reader being unable to read documents written with writer
+func check(writer, reader document, newIsReader bool) []types.Violation {
+	c := &checker{
+		writer:      writer,
+		reader:      reader,
+		newIsReader: newIsReader,
+		seen:        make(map[[2]string]bool),
+	}
+	c.compare(writer.root, reader.root, "#")
+	return c.violations
+}
+
+// fail records a violation at path
+func (c *checker) fail(path, rule string, writer, reader interface{}, format string, args ...any) {
+	v := types.Violation{Path: path, Rule: rule, Message: fmt.Sprintf(format, args...)}
+	if c.newIsReader {
+		v.OldType, v.NewType = typeName(writer), typeName(reader)
+	} else {
+		v.OldType, v.NewType = typeName(reader), typeName(writer)
+	}
+	c.violations = append(c.violations, v)
+}
+
+// accepts reports whether reader reads everything written with writer, without
+// recording violations
+func (c *checker) accepts(writer, reader interface{}, path string) bool {
+	trial := &checker{
+		writer:      c.writer,
+		reader:      c.reader,
+		newIsReader: c.newIsReader,
+		seen:        maps.Clone(c.seen),
+	}
+	trial.compare(writer, reader, path)
+	return len(trial.violations) == 0
+}
+
+// compare compares a writer and reader schema at path
+func (c *checker) compare(writer, reader interface{}, path string) {
+	writer, writerDoc, writerRef := resolveRef(c.writer, writer)
+	reader, readerDoc, readerRef := resolveRef(c.reader, reader)
+	if writerRef != "" || readerRef != "" {
+		pair := [2]string{writerRef, readerRef}
+		if c.seen[pair] {
+			return
+		}
+		c.seen[pair] = true
+	}
+
+	// References into other documents move the comparison into them
+	defer func(writer, reader document) {
+		c.writer, c.reader = writer, reader
+	}(c.writer, c.reader)
+	c.writer, c.reader = writerDoc, readerDoc
+
+	// Boolean schemas accept everything or nothing
+	if b, ok := reader.(bool); ok {
+		if !b && writer != false {
+			c.fail(path, ruleTypeNarrowed, writer, reader, "reader schema accepts no value")
+		}
+		return
+	}
+	if writer == false {
+		return
+	}
+	w, _ := writer.(map[string]interface{})
+	r, _ := reader.(map[string]interface{})
+
+	if c.compareCombined(w, r, path) {
+		return
+	}
+
+	c.compareTypes(w, r, path)
+	c.compareEnum(w, r, path)
+
+	if bothAllow(w, r, "string") {
+		c.compareString(w, r, path)
+	}
+	if bothAllow(w, r, "number") || bothAllow(w, r, "integer") {
+		c.compareNumber(w, r, path)
+	}
+	if bothAllow(w, r, "object") {
+		c.compareObject(w, r, path)
+	}
+	if bothAllow(w, r, "array") {
+		c.compareArray(w, r, path)
+	}
+}
+
+// compareTypes verifies that every type the writer allows is allowed by the reader
+func (c *checker) compareTypes(w, r map[string]interface{}, path string) {
+	readerTypes := schemaTypes(r)
+	if readerTypes == nil {
+		return
+	}
+	writerTypes := schemaTypes(w)
+	if writerTypes == nil {
+		c.fail(path, ruleTypeNarrowed, w, r, "writer allows any type but the reader only allows %s", typeName(r))
+		return
+	}
+
+	// Writer numbers are still partly readable if the reader allows integers
+	var missing []string
+	overlap := false
+	for _, t := range writerTypes {
+		if !allowsType(readerTypes, t) {
+			missing = append(missing, t)
+			overlap = overlap || (t == "number" && allowsType(readerTypes, "integer"))
+		}
+	}
+	switch {
+	case len(missing) == 0:
+	case len(missing) == len(writerTypes) && !overlap:
+		c.fail(path, ruleTypeChanged, w, r, "writer type %s cannot be read as %s", typeName(w), typeName(r))
+	default:
+		c.fail(path, ruleTypeNarrowed, w, r, "writer type %s cannot be read as %s", strings.Join(missing, ", "), typeName(r))
+	}
+}
+
+// compareEnum verifies that every value the writer allows is in the reader enum
+func (c *checker) compareEnum(w, r map[string]interface{}, path string) {
+	readerValues, ok := enumValues(r)
+	if !ok {
+		return
+	}
+	writerValues, ok := enumValues(w)
+	if !ok {
+		c.fail(path, ruleEnumArrayNarrowed, w, r, "reader restricts values to an enum the writer doesn't have")
+		return
+	}
+
+	for _, value := range writerValues {
+		found := false
+		for _, candidate := range readerValues {
+			if reflect.DeepEqual(value, candidate) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.fail(path, ruleEnumArrayNarrowed, w, r, "writer enum value %v is missing from the reader", value)
+		}
+	}
+}
+
+// compareString compares the string constraints of a writer and reader schema
+func (c *checker) compareString(w, r map[string]interface{}, path string) {
+	c.compareUpperBound(w, r, "maxLength", path, ruleMaxLengthAdded, ruleMaxLengthDecreased)
+	c.compareLowerBound(w, r, "minLength", path, ruleMinLengthAdded, ruleMinLengthIncreased)
+
+	readerPattern, ok := r["pattern"].(string)
+	if !ok {
+		return
+	}
+	writerPattern, ok := w["pattern"].(string)
+	switch {
+	case !ok:
+		c.fail(path, rulePatternAdded, w, r, "reader pattern %s was added", readerPattern)
+	case writerPattern != readerPattern:
+		c.fail(path, rulePatternChanged, w, r, "pattern changed from %s to %s", writerPattern, readerPattern)
+	}
+}
+
+// compareNumber compares the numeric constraints of a writer and reader schema
+func (c *checker) compareNumber(w, r map[string]interface{}, path string) {
+	c.compareUpperBound(w, r, "maximum", path, ruleMaximumAdded, ruleMaximumDecreased)
+	c.compareLowerBound(w, r, "minimum", path, ruleMinimumAdded, ruleMinimumIncreased)
+	c.compareUpperBound(w, r, "exclusiveMaximum", path, ruleExclusiveMaximumAdded, ruleExclusiveMaximumDecreased)
+	c.compareLowerBound(w, r, "exclusiveMinimum", path, ruleExclusiveMinimumAdded, ruleExclusiveMinimumIncreased)
+
+	// Every multiple of the writer's multipleOf must be a multiple of the reader's
+	readerMultiple, ok := number(r["multipleOf"])
+	if !ok {
+		return
+	}
+	writerMultiple, ok := number(w["multipleOf"])
+	switch {
+	case !ok:
+		c.fail(path, ruleMultipleOfAdded, w, r, "reader multipleOf %v was added", readerMultiple)
+	case !isMultiple(writerMultiple, readerMultiple):
+		c.fail(path, ruleMultipleOfChanged, w, r, "multipleOf changed from %v to %v", writerMultiple, readerMultiple)
+	}
+}
+
+// compareObject compares the properties and content model of a writer and reader schema
+func (c *checker) compareObject(w, r map[string]interface{}, path string) {
+	writerProps := properties(w)
+	readerProps := properties(r)
+
+	for _, name := range sortedKeys(writerProps, readerProps) {
+		propPath := path + "/properties/" + escapePointer(name)
+		writerProp, inWriter := writerProps[name]
+		readerProp, inReader := readerProps[name]
+		switch {
+		case inWriter && inReader:
+			c.compare(writerProp, readerProp, propPath)
+		case inReader:
+			// The writer may have put the property in its additional properties,
+			// unless its content model is closed
+			writerExtra := extraSchema(w, name)
+			switch {
+			case writerExtra == false:
+			case isEmptySchema(writerExtra):
+				if !isEmptySchema(normalizeSchema(readerProp)) {
+					c.fail(propPath, rulePropertyAddedToOpen, nil, readerProp, "reader property %s was added to the writer's open content model", name)
+				}
+			case !c.accepts(writerExtra, readerProp, propPath):
+				c.fail(propPath, rulePropertyAddedNotCovered, writerExtra, readerProp, "reader property %s doesn't accept the writer's additional properties", name)
+			}
+		default:
+			// The reader must accept the property as one of its additional properties
+			readerExtra := extraSchema(r, name)
+			switch {
+			case isEmptySchema(readerExtra):
+			case readerExtra == false:
+				if writerProp != false {
+					c.fail(propPath, rulePropertyRemovedFromClosed, writerProp, nil, "writer property %s is not allowed by the reader's closed content model", name)
+				}
+			case !c.accepts(writerProp, readerExtra, propPath):
+				c.fail(propPath, rulePropertyRemovedNotCovered, writerProp, readerExtra, "writer property %s is not accepted by the reader's additional properties", name)
+			}
+		}
+	}
+
+	// Properties the reader requires must be present in everything written,
+	// unless the reader can fall back to a default
+	writerRequired := stringSet(w["required"])
+	for _, name := range sortedKeys(stringSet(r["required"])) {
+		if writerRequired[name] {
+			continue
+		}
+		readerProp := readerProps[name]
+		if m, ok := readerProp.(map[string]interface{}); ok && m["default"] != nil {
+			continue
+		}
+		propPath := path + "/properties/" + escapePointer(name)
+		if _, inWriter := writerProps[name]; inWriter || isEmptySchema(extraSchema(w, name)) {
+			c.fail(propPath, ruleRequiredAttributeAdded, writerProps[name], readerProp, "property %s became required", name)
+		} else {
+			c.fail(propPath, ruleRequiredPropertyAddedToUnopen, nil, readerProp, "required property %s was added", name)
+		}
+	}
+
+	c.compareAdditionalProperties(w, r, path)
+	c.compareUpperBound(w, r, "maxProperties", path, ruleMaxPropertiesAdded, ruleMaxPropertiesDecreased)
+	c.compareLowerBound(w, r, "minProperties", path, ruleMinPropertiesAdded, ruleMinPropertiesIncreased)
+}
+
+// compareAdditionalProperties compares the content models of a writer and reader schema
+func (c *checker) compareAdditionalProperties(w, r map[string]interface{}, path string) {
+	writerExtra := additionalProperties(w)
+	readerExtra := additionalProperties(r)
+	switch {
+	case isEmptySchema(readerExtra):
+		// The reader accepts any additional property
+	case writerExtra == false:
+		// The writer writes no additional properties
+	case readerExtra == false:
+		c.fail(path+"/additionalProperties", ruleAdditionalPropertiesRemoved, writerExtra, readerExtra, "reader no longer allows additional properties")
+	case isEmptySchema(writerExtra):
+		c.fail(path+"/additionalProperties", ruleAdditionalPropertiesNarrowed, writerExtra, readerExtra, "reader restricts additional properties the writer allows")
+	default:
+		c.compare(writerExtra, readerExtra, path+"/additionalProperties")
+	}
+}
+
+// compareArray compares the items and constraints of a writer and reader schema
+func (c *checker) compareArray(w, r map[string]interface{}, path string) {
+	writerTuple, writerIsTuple := w["items"].([]interface{})
+	readerTuple, readerIsTuple := r["items"].([]interface{})
+	if writerIsTuple || readerIsTuple {
+		for i := 0; i < max(len(writerTuple), len(readerTuple)); i++ {
+			c.compare(itemSchema(w, i), itemSchema(r, i), path+"/items/"+strconv.Itoa(i))
+		}
+		c.compare(additionalItems(w), additionalItems(r), path+"/additionalItems")
+	} else if readerItems, ok := r["items"]; ok {
+		c.compare(schemaOrTrue(w["items"]), readerItems, path+"/items")
+	}
+
+	c.compareUpperBound(w, r, "maxItems", path, ruleMaxItemsAdded, ruleMaxItemsDecreased)
+	c.compareLowerBound(w, r, "minItems", path, ruleMinItemsAdded, ruleMinItemsIncreased)
+	if r["uniqueItems"] == true && w["uniqueItems"] != true {
+		c.fail(path, ruleUniqueItemsAdded, w, r, "reader requires unique items")
+	}
+}
+
+// compareCombined compares schemas using oneOf, anyOf or allOf and reports
+// whether either used one
+func (c *checker) compareCombined(w, r map[string]interface{}, path string) bool {
+	writerKeyword, writerSubs := combined(w)
+	readerKeyword, readerSubs := combined(r)
+
+	switch {
+	case writerKeyword == "" && readerKeyword == "":
+		return false
+	case readerKeyword == "":
+		if writerKeyword == "allOf" {
+			// Documents match every writer subschema, so one matching the reader is enough
+			for _, sub := range writerSubs {
+				if c.accepts(sub, r, path) {
+					return true
+				}
+			}
+			c.fail(path, ruleCombinedTypeChanged, w, r, "no writer allOf subschema can be read by the reader")
+			return true
+		}
+		for i, sub := range writerSubs {
+			c.compare(sub, r, fmt.Sprintf("%s/%s/%d", path, writerKeyword, i))
+		}
+	case writerKeyword == "":
+		if readerKeyword == "allOf" {
+			for i, sub := range readerSubs {
+				c.compare(w, sub, fmt.Sprintf("%s/allOf/%d", path, i))
+			}
+			return true
+		}
+		for _, sub := range readerSubs {
+			if c.accepts(w, sub, path) {
+				return true
+			}
+		}
+		c.fail(path, ruleSumTypeNarrowed, w, r, "no reader %s subschema can read the writer schema", readerKeyword)
+	case writerKeyword == "allOf" && readerKeyword == "allOf":
+		// Every reader constraint must already hold for the writer
+		for i, readerSub := range readerSubs {
+			if !slicesAny(writerSubs, func(writerSub interface{}) bool { return c.accepts(writerSub, readerSub, path) }) {
+				c.fail(fmt.Sprintf("%s/allOf/%d", path, i), ruleProductTypeExtended, w, readerSub, "reader allOf subschema %d is not implied by the writer", i)
+			}
+		}
+	case writerKeyword == "allOf" || readerKeyword == "allOf", writerKeyword == "anyOf" && readerKeyword == "oneOf":
+		c.fail(path, ruleCombinedTypeChanged, w, r, "combined type changed from %s to %s", writerKeyword, readerKeyword)
+	default:
+		// Every writer alternative must be readable with a reader alternative
+		for i, writerSub := range writerSubs {
+			if !slicesAny(readerSubs, func(readerSub interface{}) bool { return c.accepts(writerSub, readerSub, path) }) {
+				c.fail(fmt.Sprintf("%s/%s/%d", path, writerKeyword, i), ruleSumTypeNarrowed, writerSub, r, "writer %s subschema %d cannot be read with any reader subschema", writerKeyword, i)
+			}
+		}
+	}
+	return true
+}
+
+// compareUpperBound reports a reader upper bound that rejects values the writer allows
+func (c *checker) compareUpperBound(w, r map[string]interface{}, key, path, ruleAdded, ruleDecreased string) {
+	readerBound, ok := number(r[key])
+	if !ok {
+		return
+	}
+	writerBound, ok := number(w[key])
+	switch {
+	case !ok:
+		c.fail(path, ruleAdded, w, r, "reader %s %v was added", key, readerBound)
+	case readerBound < writerBound:
+		c.fail(path, ruleDecreased, w, r, "%s decreased from %v to %v", key, writerBound, readerBound)
+	}
+}
+
+// compareLowerBound reports a reader lower bound that rejects values the writer allows
+func (c *checker) compareLowerBound(w, r map[string]interface{}, key, path, ruleAdded, ruleIncreased string) {
+	readerBound, ok := number(r[key])
+	if !ok {
+		return
+	}
+	writerBound, ok := number(w[key])
+	switch {
+	case !ok:
+		c.fail(path, ruleAdded, w, r, "reader %s %v was added", key, readerBound)
+	case readerBound > writerBound:
+		c.fail(path, ruleIncreased, w, r, "%s increased from %v to %v", key, writerBound, readerBound)
+	}
+}
+
+// resolveRef follows $ref pointers and returns the schema referred to, the
+// document it is in and the last reference followed. A reference names either a
+// pointer within the current document, or a referenced document optionally
+// followed by a pointer within it. References that cannot be resolved are
+// treated as accepting anything.
+func resolveRef(doc document, schema interface{}) (interface{}, document, string) {
+	var ref string
+	for depth := 0; depth < 32; depth++ {
+		m, ok := schema.(map[string]interface{})
+		if !ok {
+			return schema, doc, ref
+		}
+		next, ok := m["$ref"].(string)
+		if !ok {
+			return schema, doc, ref
+		}
+
+		name, fragment, _ := strings.Cut(next, "#")
+		if name != "" {
+			root, ok := doc.refs[name]
+			if !ok {
+				return true, doc, next
+			}
+			doc.name, doc.root = name, root
+		}
+		ref = doc.name + "#" + fragment
+		if schema, ok = resolvePointer(doc.root, "#"+fragment); !ok {
+			return true, doc, ref
+		}
+	}
+	return true, doc, ref
+}
+
+// resolvePointer resolves a JSON pointer fragment such as #/definitions/Address
+func resolvePointer(root interface{}, ref string) (interface{}, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+	fragment, err := url.PathUnescape(ref[1:])
+	if err != nil {
+		return nil, false
+	}
+
+	current := root
+	for _, token := range strings.Split(strings.TrimPrefix(fragment, "/"), "/") {
+		if token == "" {
+			continue
+		}
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch v := current.(type) {
+		case map[string]interface{}:
+			next, ok := v[token]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			current = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// escapePointer escapes a property name for use in a JSON pointer
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+// schemaTypes returns the types a schema allows, or nil if it doesn't restrict them
+func schemaTypes(s map[string]interface{}) []string {
+	switch t := s["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var result []string
+		for _, v := range t {
+			if name, ok := v.(string); ok {
+				result = append(result, name)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// allowsType reports whether a list of types, nil meaning any, allows a type
+func allowsType(allowed []string, t string) bool {
+	if allowed == nil {
+		return true
+	}
+	for _, a := range allowed {
+		if a == t || (t == "integer" && a == "number") {
+			return true
+		}
+	}
+	return false
+}
+
+// bothAllow reports whether both schemas allow a type, so its keywords need comparing
+func bothAllow(w, r map[string]interface{}, t string) bool {
+	return allowsType(schemaTypes(w), t) && allowsType(schemaTypes(r), t)
+}
+
+// enumValues returns the values allowed by enum or const, if the schema restricts them
+func enumValues(s map[string]interface{}) ([]interface{}, bool) {
+	if values, ok := s["enum"].([]interface{}); ok {
+		return values, true
+	}
+	if value, ok := s["const"]; ok {
+		return []interface{}{value}, true
+	}
+	return nil, false
+}
+
+// properties returns the declared properties of a schema
+func properties(s map[string]interface{}) map[string]interface{} {
+	props, _ := s["properties"].(map[string]interface{})
+	return props
+}
+
+// extraSchema returns the schema a property not declared in properties must
+// match: the first matching pattern property, or the additional properties
+func extraSchema(s map[string]interface{}, name string) interface{} {
+	if patterns, ok := s["patternProperties"].(map[string]interface{}); ok {
+		for _, pattern := range sortedKeys(patterns) {
+			if re, err := regexp.Compile(pattern); err == nil && re.MatchString(name) {
+				return normalizeSchema(patterns[pattern])
+			}
+		}
+	}
+	return additionalProperties(s)
+}
+
+// additionalProperties returns the schema additional properties must match
+func additionalProperties(s map[string]interface{}) interface{} {
+	return schemaOrTrue(s["additionalProperties"])
+}
+
+// itemSchema returns the schema the item at an index of an array must match
+func itemSchema(s map[string]interface{}, i int) interface{} {
+	switch items := s["items"].(type) {
+	case []interface{}:
+		if i < len(items) {
+			return items[i]
+		}
+		return additionalItems(s)
+	case nil:
+		return true
+	default:
+		return items
+	}
+}
+
+// additionalItems returns the schema items beyond a tuple must match
+func additionalItems(s map[string]interface{}) interface{} {
+	if _, ok := s["items"].([]interface{}); !ok {
+		return schemaOrTrue(s["items"])
+	}
+	return schemaOrTrue(s["additionalItems"])
+}
+
+// schemaOrTrue returns a subschema, or true if it is absent
+func schemaOrTrue(s interface{}) interface{} {
+	if s == nil {
+		return true
+	}
+	return normalizeSchema(s)
+}
+
+// normalizeSchema returns true for empty schemas, which accept anything
+func normalizeSchema(s interface{}) interface{} {
+	if isEmptySchema(s) {
+		return true
+	}
+	return s
+}
+
+// isEmptySchema reports whether a schema accepts anything
+func isEmptySchema(s interface{}) bool {
+	if s == true {
+		return true
+	}
+	m, ok := s.(map[string]interface{})
+	return ok && len(m) == 0
+}
+
+// combined returns the combining keyword of a schema and its subschemas
+func combined(s map[string]interface{}) (string, []interface{}) {
+	for _, keyword := range []string{"oneOf", "anyOf", "allOf"} {
+		if subs, ok := s[keyword].([]interface{}); ok {
+			return keyword, subs
+		}
+	}
+	return "", nil
+}
+
+// slicesAny reports whether any element satisfies fn
+func slicesAny(values []interface{}, fn func(interface{}) bool) bool {
+	for _, v := range values {
+		if fn(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringSet returns the strings of a JSON array as a set
+func stringSet(v interface{}) map[string]bool {
+	set := make(map[string]bool)
+	values, _ := v.([]interface{})
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			set[s] = true
+		}
+	}
+	return set
+}
+
+// sortedKeys returns the keys of maps in a stable order
+func sortedKeys[V any](ms ...map[string]V) []string {
+	set := make(map[string]bool)
+	for _, m := range ms {
+		for k := range m {
+			set[k] = true
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// number returns a JSON number as a float
+func number(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+// isMultiple reports whether a is a multiple of b
+func isMultiple(a, b float64) bool {
+	if b == 0 {
+		return false
+	}
+	q := a / b
+	return math.Abs(q-math.Round(q)) < 1e-9
+}
+
+// typeName describes a schema for violations
+func typeName(s interface{}) string {
+	switch v := s.(type) {
+	case nil:
+		return ""
+	case bool:
+		return strconv.FormatBool(v)
+	case map[string]interface{}:
+		t := schemaTypes(v)
+		switch len(t) {
+		case 0:
+			if keyword, _ := combined(v); keyword != "" {
+				return keyword
+			}
+			return "any"
+		case 1:
+			return t[0]
+		default:
+			return "[" + strings.Join(t, ", ") + "]"
+		}
+	}
+	return ""
+}
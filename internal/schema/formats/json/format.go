@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"log/slog"
+	"strings"
 
 	"schemaregistry/internal/schema/types"
 
@@ -19,36 +19,40 @@ func New() *Format {
 	return &Format{}
 }
 
-func (f *Format) Validate(schemaStr string) error {
-	// Parse schema
-	compiler := jsonschema.NewCompiler()
-	if err := compiler.AddResource("schema.json", bytes.NewReader([]byte(schemaStr))); err != nil {
-		return fmt.Errorf("add schema resource: %w", err)
-	}
+func (f *Format) Validate(schemaStr string, refs []types.ResolvedReference) error {
+	// Compile schema, which also validates it against its meta-schema
+	_, err := compile(schemaStr, refs)
+	return err
+}
 
-	// Compile schema
-	schema, err := compiler.Compile("schema.json")
-	if err != nil {
-		return fmt.Errorf("compile schema: %w", err)
+// Normalize returns the schema as compact JSON with object keys sorted
+func (f *Format) Normalize(schemaStr string, refs []types.ResolvedReference) (string, error) {
+	if _, err := compile(schemaStr, refs); err != nil {
+		return "", err
 	}
 
-	// Validate schema
-	if err := schema.Validate(nil); err != nil {
-		return fmt.Errorf("validate schema: %w", err)
+	// Decode numbers as written so that no precision is lost
+	decoder := json.NewDecoder(strings.NewReader(schemaStr))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return "", fmt.Errorf("parse schema: %w", err)
 	}
 
-	return nil
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		return "", fmt.Errorf("encode schema: %w", err)
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
 }
 
-func (f *Format) Serialize(data interface{}, schemaStr string) ([]byte, error) {
+func (f *Format) Serialize(data interface{}, schemaStr string, refs []types.ResolvedReference) ([]byte, error) {
 	// Compile schema
-	compiler := jsonschema.NewCompiler()
-	if err := compiler.AddResource("schema.json", bytes.NewReader([]byte(schemaStr))); err != nil {
-		return nil, fmt.Errorf("add schema resource: %w", err)
-	}
-	schema, err := compiler.Compile("schema.json")
+	schema, err := compile(schemaStr, refs)
 	if err != nil {
-		return nil, fmt.Errorf("compile schema: %w", err)
+		return nil, err
 	}
 
 	// Validate data against schema
@@ -59,20 +63,16 @@ func (f *Format) Serialize(data interface{}, schemaStr string) ([]byte, error) {
 	return json.Marshal(data)
 }
 
-func (f *Format) Deserialize(data []byte, schemaStr string) (interface{}, error) {
+func (f *Format) Deserialize(data []byte, schemaStr string, refs []types.ResolvedReference) (interface{}, error) {
 	var result interface{}
 	if err := json.Unmarshal(data, &result); err != nil {
 		return nil, fmt.Errorf("unmarshal JSON: %w", err)
 	}
 
 	// Compile schema
-	compiler := jsonschema.NewCompiler()
-	if err := compiler.AddResource("schema.json", bytes.NewReader([]byte(schemaStr))); err != nil {
-		return nil, fmt.Errorf("add schema resource: %w", err)
-	}
-	schema, err := compiler.Compile("schema.json")
+	schema, err := compile(schemaStr, refs)
 	if err != nil {
-		return nil, fmt.Errorf("compile schema: %w", err)
+		return nil, err
 	}
 
 	// Validate data against schema
@@ -83,149 +83,67 @@ func (f *Format) Deserialize(data []byte, schemaStr string) (interface{}, error)
 	return result, nil
 }
 
-func (f *Format) CheckCompatibility(oldSchema, newSchema string, level types.CompatibilityLevel) (bool, error) {
+func (f *Format) CheckCompatibility(oldSchema string, oldRefs []types.ResolvedReference, newSchema string, newRefs []types.ResolvedReference, level types.CompatibilityLevel) ([]types.Violation, error) {
 	// Parse schemas
-	oldProps := f.getSchemaProperties(oldSchema)
-	newProps := f.getSchemaProperties(newSchema)
+	oldDoc, err := parseDocument(oldSchema, oldRefs)
+	if err != nil {
+		return nil, fmt.Errorf("parse old schema: %w", err)
+	}
+	newDoc, err := parseDocument(newSchema, newRefs)
+	if err != nil {
+		return nil, fmt.Errorf("parse new schema: %w", err)
+	}
 
 	// Check compatibility based on level
 	switch level {
 	case types.Backward, types.BackwardTransitive:
 		// New schema can read data written with old schema
-		return f.isBackwardCompatible(oldProps, newProps)
+		return check(oldDoc, newDoc, true), nil
 	case types.Forward, types.ForwardTransitive:
 		// Old schema can read data written with new schema
-		return f.isForwardCompatible(oldProps, newProps)
+		return check(newDoc, oldDoc, false), nil
 	case types.Full, types.FullTransitive:
 		// Both backward and forward compatibility
-		backward, err := f.isBackwardCompatible(oldProps, newProps)
-		if err != nil || !backward {
-			return false, err
-		}
-		return f.isForwardCompatible(oldProps, newProps)
+		return append(check(oldDoc, newDoc, true), check(newDoc, oldDoc, false)...), nil
 	case types.None:
-		return true, nil
+		return nil, nil
 	default:
-		return false, fmt.Errorf("unsupported compatibility level: %s", level)
+		return nil, fmt.Errorf("unsupported compatibility level: %s", level)
 	}
 }
 
-// isBackwardCompatible checks if new schema can read data written with old schema
-func (f *Format) isBackwardCompatible(oldProps, newProps map[string]propertyInfo) (bool, error) {
-	// Check each property in the old schema
-	for name, oldProp := range oldProps {
-		newProp, exists := newProps[name]
-		if !exists {
-			// Property was removed
-			if oldProp.required {
-				return false, fmt.Errorf("required property %s was removed", name)
-			}
-			continue
-		}
-
-		// Check type compatibility
-		if !f.isTypeCompatible(oldProp.type_, newProp.type_) {
-			return false, fmt.Errorf("incompatible types for property %s: %s -> %s", name, oldProp.type_, newProp.type_)
-		}
-
-		// Check if property became required
-		if !oldProp.required && newProp.required {
-			return false, fmt.Errorf("property %s became required", name)
+// compile compiles a schema, with the schemas it references available under
+// their reference names
+func compile(schemaStr string, refs []types.ResolvedReference) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	for _, ref := range refs {
+		if err := compiler.AddResource(ref.Name, bytes.NewReader([]byte(ref.Schema))); err != nil {
+			return nil, fmt.Errorf("add reference %s: %w", ref.Name, err)
 		}
 	}
-
-	return true, nil
-}
-
-// isForwardCompatible checks if old schema can read data written with new schema
-func (f *Format) isForwardCompatible(oldProps, newProps map[string]propertyInfo) (bool, error) {
-	// Check each property in the new schema
-	for name, newProp := range newProps {
-		oldProp, exists := oldProps[name]
-		if !exists {
-			// New property was added
-			if newProp.required {
-				return false, fmt.Errorf("new required property %s was added", name)
-			}
-			continue
-		}
-
-		// Check type compatibility
-		if !f.isTypeCompatible(newProp.type_, oldProp.type_) {
-			return false, fmt.Errorf("incompatible types for property %s: %s -> %s", name, newProp.type_, oldProp.type_)
-		}
-
-		// Check if property became optional
-		if oldProp.required && !newProp.required {
-			return false, fmt.Errorf("property %s became optional", name)
-		}
+	if err := compiler.AddResource("schema.json", bytes.NewReader([]byte(schemaStr))); err != nil {
+		return nil, fmt.Errorf("add schema resource: %w", err)
 	}
 
-	return true, nil
-}
-
-type propertyInfo struct {
-	required bool
-	type_    string
+	schema, err := compiler.Compile("schema.json")
+	if err != nil {
+		return nil, fmt.Errorf("compile schema: %w", err)
+	}
+	return schema, nil
 }
 
-func (f *Format) getSchemaProperties(schemaStr string) map[string]propertyInfo {
-	props := make(map[string]propertyInfo)
-
-	// Parse schema JSON
-	var schemaMap map[string]interface{}
-	if err := json.Unmarshal([]byte(schemaStr), &schemaMap); err != nil {
-		return props
+// parseDocument parses a schema and the schemas it references
+func parseDocument(schemaStr string, refs []types.ResolvedReference) (document, error) {
+	doc := document{refs: make(map[string]interface{}, len(refs))}
+	if err := json.Unmarshal([]byte(schemaStr), &doc.root); err != nil {
+		return document{}, err
 	}
-
-	// Extract properties
-	if properties, ok := schemaMap["properties"].(map[string]interface{}); ok {
-		required := make(map[string]bool)
-		if requiredProps, ok := schemaMap["required"].([]interface{}); ok {
-			for _, req := range requiredProps {
-				if name, ok := req.(string); ok {
-					required[name] = true
-				}
-			}
+	for _, ref := range refs {
+		var root interface{}
+		if err := json.Unmarshal([]byte(ref.Schema), &root); err != nil {
+			return document{}, fmt.Errorf("reference %s: %w", ref.Name, err)
 		}
-
-		for name, prop := range properties {
-			if propMap, ok := prop.(map[string]interface{}); ok {
-				type_ := "object" // default type
-				if t, ok := propMap["type"].(string); ok {
-					type_ = t
-				}
-
-				props[name] = propertyInfo{
-					required: required[name],
-					type_:    type_,
-				}
-			}
-		}
-	}
-
-	return props
-}
-
-func (f *Format) isTypeCompatible(oldType, newType string) bool {
-	slog.Debug("isTypeCompatible called", "oldType", oldType, "newType", newType)
-	// Handle type compatibility rules
-	switch oldType {
-	case "null":
-		return newType == "null"
-	case "boolean":
-		return newType == "boolean"
-	case "integer":
-		return newType == "integer" // Don't allow integer -> number conversion
-	case "number":
-		return newType == "number"
-	case "string":
-		return newType == "string"
-	case "array":
-		return newType == "array"
-	case "object":
-		return newType == "object"
-	default:
-		return false
+		doc.refs[ref.Name] = root
 	}
+	return doc, nil
 }
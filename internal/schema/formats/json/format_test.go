@@ -0,0 +1,294 @@
+package json
+
+import (
+	"strings"
+	"testing"
+
+	"schemaregistry/internal/schema/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat_CheckCompatibility(t *testing.T) {
+	tests := []struct {
+		name          string
+		oldSchema     string
+		newSchema     string
+		level         types.CompatibilityLevel
+		wantViolation string
+	}{
+		{
+			name:          "Property Added To Open Content Model",
+			oldSchema:     `{"type": "object", "properties": {"name": {"type": "string"}}}`,
+			newSchema:     `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}}`,
+			level:         types.Backward,
+			wantViolation: "PROPERTY_ADDED_TO_OPEN_CONTENT_MODEL at #/properties/age",
+		},
+		{
+			name:      "Property Accepting Anything Added To Open Content Model",
+			oldSchema: `{"type": "object", "properties": {"name": {"type": "string"}}}`,
+			newSchema: `{"type": "object", "properties": {"name": {"type": "string"}, "extra": {}}}`,
+			level:     types.Backward,
+		},
+		{
+			name:      "Optional Property Added To Closed Content Model",
+			oldSchema: `{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": false}`,
+			newSchema: `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}, "additionalProperties": false}`,
+			level:     types.Backward,
+		},
+		{
+			name:      "Property Added Covered By Partially Open Content Model",
+			oldSchema: `{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": {"type": "string"}}`,
+			newSchema: `{"type": "object", "properties": {"name": {"type": "string"}, "nickname": {"type": "string"}}, "additionalProperties": {"type": "string"}}`,
+			level:     types.Backward,
+		},
+		{
+			name:          "Nested Property Type Changed",
+			oldSchema:     `{"type": "object", "properties": {"address": {"type": "object", "properties": {"zip": {"type": "string"}}}}}`,
+			newSchema:     `{"type": "object", "properties": {"address": {"type": "object", "properties": {"zip": {"type": "integer"}}}}}`,
+			level:         types.Backward,
+			wantViolation: "TYPE_CHANGED at #/properties/address/properties/zip: writer type string cannot be read as integer (old type string, new type integer)",
+		},
+		{
+			name:          "Array Items Changed",
+			oldSchema:     `{"type": "array", "items": {"type": "string"}}`,
+			newSchema:     `{"type": "array", "items": {"type": "boolean"}}`,
+			level:         types.Backward,
+			wantViolation: "TYPE_CHANGED at #/items",
+		},
+		{
+			name:      "Type Widened",
+			oldSchema: `{"type": "object", "properties": {"id": {"type": "integer"}, "name": {"type": "string"}}}`,
+			newSchema: `{"type": "object", "properties": {"id": {"type": "number"}, "name": {"type": ["string", "null"]}}}`,
+			level:     types.Backward,
+		},
+		{
+			name:          "Type Narrowed",
+			oldSchema:     `{"type": "object", "properties": {"name": {"type": ["string", "null"]}}}`,
+			newSchema:     `{"type": "object", "properties": {"name": {"type": "string"}}}`,
+			level:         types.Backward,
+			wantViolation: "TYPE_NARROWED at #/properties/name: writer type null cannot be read as string (old type [string, null], new type string)",
+		},
+		{
+			name:          "Type Widened Is Not Forward Compatible",
+			oldSchema:     `{"type": "object", "properties": {"name": {"type": "string"}}}`,
+			newSchema:     `{"type": "object", "properties": {"name": {"type": ["string", "null"]}}}`,
+			level:         types.Forward,
+			wantViolation: "TYPE_NARROWED at #/properties/name: writer type null cannot be read as string (old type string, new type [string, null])",
+		},
+		{
+			name:          "Reference Target Changed",
+			oldSchema:     `{"definitions": {"Address": {"type": "object", "properties": {"zip": {"type": "string"}}}}, "type": "object", "properties": {"home": {"$ref": "#/definitions/Address"}}}`,
+			newSchema:     `{"definitions": {"Address": {"type": "object", "properties": {"zip": {"type": "integer"}}}}, "type": "object", "properties": {"home": {"$ref": "#/definitions/Address"}}}`,
+			level:         types.Backward,
+			wantViolation: "TYPE_CHANGED at #/properties/home/properties/zip",
+		},
+		{
+			name:      "Recursive Reference",
+			oldSchema: `{"$defs": {"Node": {"type": "object", "properties": {"value": {"type": "integer"}, "next": {"$ref": "#/$defs/Node"}}}}, "$ref": "#/$defs/Node"}`,
+			newSchema: `{"$defs": {"Node": {"type": "object", "properties": {"value": {"type": "number"}, "next": {"$ref": "#/$defs/Node"}}}}, "$ref": "#/$defs/Node"}`,
+			level:     types.Full,
+			// Only integer values can be read back by the old schema
+			wantViolation: "TYPE_NARROWED at #/properties/value",
+		},
+		{
+			name:      "Enum Extended",
+			oldSchema: `{"type": "string", "enum": ["RED", "GREEN"]}`,
+			newSchema: `{"type": "string", "enum": ["RED", "GREEN", "BLUE"]}`,
+			level:     types.Backward,
+		},
+		{
+			name:          "Enum Narrowed",
+			oldSchema:     `{"type": "string", "enum": ["RED", "GREEN", "BLUE"]}`,
+			newSchema:     `{"type": "string", "enum": ["RED", "GREEN"]}`,
+			level:         types.Backward,
+			wantViolation: "ENUM_ARRAY_NARROWED at #: writer enum value BLUE is missing from the reader",
+		},
+		{
+			name:          "Property Removed From Closed Content Model",
+			oldSchema:     `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}, "additionalProperties": false}`,
+			newSchema:     `{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": false}`,
+			level:         types.Backward,
+			wantViolation: "PROPERTY_REMOVED_FROM_CLOSED_CONTENT_MODEL at #/properties/age",
+		},
+		{
+			name:      "Property Removed From Open Content Model",
+			oldSchema: `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}}`,
+			newSchema: `{"type": "object", "properties": {"name": {"type": "string"}}}`,
+			level:     types.Backward,
+		},
+		{
+			name:          "Content Model Closed",
+			oldSchema:     `{"type": "object", "properties": {"name": {"type": "string"}}}`,
+			newSchema:     `{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": false}`,
+			level:         types.Backward,
+			wantViolation: "ADDITIONAL_PROPERTIES_REMOVED at #/additionalProperties",
+		},
+		{
+			name:          "Property Added Not Covered By Partially Open Content Model",
+			oldSchema:     `{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": {"type": "string"}}`,
+			newSchema:     `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}, "additionalProperties": {"type": "string"}}`,
+			level:         types.Backward,
+			wantViolation: "PROPERTY_ADDED_NOT_COVERED_BY_PARTIALLY_OPEN_CONTENT_MODEL at #/properties/age",
+		},
+		{
+			name:      "Required Property With Default Added",
+			oldSchema: `{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": false}`,
+			newSchema: `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer", "default": 0}}, "required": ["age"], "additionalProperties": false}`,
+			level:     types.Backward,
+		},
+		{
+			name:          "Required Property Added To Closed Content Model",
+			oldSchema:     `{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": false}`,
+			newSchema:     `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}, "required": ["age"], "additionalProperties": false}`,
+			level:         types.Backward,
+			wantViolation: "REQUIRED_PROPERTY_ADDED_TO_UNOPEN_CONTENT_MODEL at #/properties/age",
+		},
+		{
+			name:          "Property Became Required",
+			oldSchema:     `{"type": "object", "properties": {"name": {"type": "string"}}}`,
+			newSchema:     `{"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]}`,
+			level:         types.Backward,
+			wantViolation: "REQUIRED_ATTRIBUTE_ADDED at #/properties/name: property name became required",
+		},
+		{
+			name:          "Max Length Decreased",
+			oldSchema:     `{"type": "string", "maxLength": 10}`,
+			newSchema:     `{"type": "string", "maxLength": 5}`,
+			level:         types.Backward,
+			wantViolation: "MAX_LENGTH_DECREASED at #: maxLength decreased from 10 to 5",
+		},
+		{
+			name:      "Numeric Bounds Relaxed",
+			oldSchema: `{"type": "integer", "minimum": 1, "maximum": 10, "multipleOf": 4}`,
+			newSchema: `{"type": "integer", "minimum": 0, "maximum": 100, "multipleOf": 2}`,
+			level:     types.Backward,
+		},
+		{
+			name:          "Minimum Increased",
+			oldSchema:     `{"type": "number", "minimum": 0}`,
+			newSchema:     `{"type": "number", "minimum": 1}`,
+			level:         types.Backward,
+			wantViolation: "MINIMUM_INCREASED at #: minimum increased from 0 to 1",
+		},
+		{
+			name:          "Multiple Of Changed",
+			oldSchema:     `{"type": "integer", "multipleOf": 2}`,
+			newSchema:     `{"type": "integer", "multipleOf": 4}`,
+			level:         types.Backward,
+			wantViolation: "MULTIPLE_OF_CHANGED at #",
+		},
+		{
+			name:          "Pattern Changed",
+			oldSchema:     `{"type": "string", "pattern": "^[a-z]+$"}`,
+			newSchema:     `{"type": "string", "pattern": "^[a-z]{3}$"}`,
+			level:         types.Backward,
+			wantViolation: "PATTERN_CHANGED at #",
+		},
+		{
+			name:      "Sum Type Extended",
+			oldSchema: `{"type": "string"}`,
+			newSchema: `{"oneOf": [{"type": "string"}, {"type": "integer"}]}`,
+			level:     types.Backward,
+		},
+		{
+			name:          "Sum Type Narrowed",
+			oldSchema:     `{"oneOf": [{"type": "string"}, {"type": "integer"}]}`,
+			newSchema:     `{"oneOf": [{"type": "string"}]}`,
+			level:         types.Backward,
+			wantViolation: "SUM_TYPE_NARROWED at #/oneOf/1",
+		},
+		{
+			name:          "Product Type Extended",
+			oldSchema:     `{"allOf": [{"type": "string"}]}`,
+			newSchema:     `{"allOf": [{"type": "string"}, {"type": "string", "maxLength": 3}]}`,
+			level:         types.Backward,
+			wantViolation: "PRODUCT_TYPE_EXTENDED at #/allOf/1",
+		},
+		{
+			name:          "Unique Items Added",
+			oldSchema:     `{"type": "array", "items": {"type": "string"}}`,
+			newSchema:     `{"type": "array", "items": {"type": "string"}, "uniqueItems": true}`,
+			level:         types.Backward,
+			wantViolation: "UNIQUE_ITEMS_ADDED at #",
+		},
+		{
+			name:      "None",
+			oldSchema: `{"type": "string"}`,
+			newSchema: `{"type": "integer"}`,
+			level:     types.None,
+		},
+	}
+
+	f := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := f.CheckCompatibility(tt.oldSchema, nil, tt.newSchema, nil, tt.level)
+			require.NoError(t, err)
+			if tt.wantViolation == "" {
+				assert.Empty(t, violations)
+				return
+			}
+
+			messages := make([]string, 0, len(violations))
+			for _, v := range violations {
+				messages = append(messages, v.String())
+			}
+			assert.Contains(t, strings.Join(messages, "\n"), tt.wantViolation)
+		})
+	}
+}
+
+func TestFormat_References(t *testing.T) {
+	f := New()
+
+	user := `{"type": "object", "properties": {"name": {"type": "string"}, "home": {"$ref": "address.json"}}}`
+	refs := []types.ResolvedReference{{
+		Name:    "address.json",
+		Subject: "address",
+		Version: 1,
+		Schema:  `{"type": "object", "properties": {"zip": {"$ref": "#/definitions/Zip"}}, "definitions": {"Zip": {"type": "string"}}}`,
+	}}
+
+	t.Run("Validate", func(t *testing.T) {
+		assert.NoError(t, f.Validate(user, refs))
+		assert.Error(t, f.Validate(user, nil))
+	})
+
+	t.Run("Serialize", func(t *testing.T) {
+		_, err := f.Serialize(map[string]interface{}{"home": map[string]interface{}{"zip": "12345"}}, user, refs)
+		assert.NoError(t, err)
+		_, err = f.Serialize(map[string]interface{}{"home": map[string]interface{}{"zip": 12345}}, user, refs)
+		assert.Error(t, err)
+	})
+
+	t.Run("Referenced Type Changed", func(t *testing.T) {
+		newRefs := []types.ResolvedReference{{
+			Name:    "address.json",
+			Subject: "address",
+			Version: 2,
+			Schema:  `{"type": "object", "properties": {"zip": {"$ref": "#/definitions/Zip"}}, "definitions": {"Zip": {"type": "integer"}}}`,
+		}}
+		violations, err := f.CheckCompatibility(user, refs, user, newRefs, types.Backward)
+		require.NoError(t, err)
+		require.Len(t, violations, 1)
+		assert.Equal(t, "#/properties/home/properties/zip", violations[0].Path)
+		assert.Equal(t, ruleTypeChanged, violations[0].Rule)
+	})
+}
+
+func TestFormat_Normalize(t *testing.T) {
+	f := New()
+
+	normalized, err := f.Normalize(`{
+		"type": "object",
+		"properties": {"price": {"type": "number", "maximum": 1e21, "pattern": "<a&b>"}, "id": {"type": "integer"}},
+		"$schema": "http://json-schema.org/draft-07/schema#"
+	}`, nil)
+	require.NoError(t, err)
+	assert.Equal(t, `{"$schema":"http://json-schema.org/draft-07/schema#","properties":{"id":{"type":"integer"},"price":{"maximum":1e21,"pattern":"<a&b>","type":"number"}},"type":"object"}`, normalized)
+
+	_, err = f.Normalize(`{"type": 12}`, nil)
+	assert.Error(t, err)
+}
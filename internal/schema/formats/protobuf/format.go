@@ -1,16 +1,15 @@
 package protobuf
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 
 	"schemaregistry/internal/schema/types"
 
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
-	"google.golang.org/protobuf/reflect/protoregistry"
-	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/dynamicpb"
 )
 
@@ -22,46 +21,43 @@ func New() *Format {
 	return &Format{}
 }
 
-func (f *Format) Validate(schemaStr string) error {
-	// Parse the schema string as a FileDescriptorProto
-	var fileDescProto descriptorpb.FileDescriptorProto
-	if err := protojson.Unmarshal([]byte(schemaStr), &fileDescProto); err != nil {
-		return fmt.Errorf("unmarshal schema: %w", err)
-	}
+func (f *Format) Validate(schemaStr string, refs []types.ResolvedReference) error {
+	_, err := f.parseSchema(schemaStr, refs)
+	return err
+}
 
-	// Create a FileDescriptor from the FileDescriptorProto
-	_, err := protodesc.NewFile(&fileDescProto, protoregistry.GlobalFiles)
+// Normalize renders the schema as .proto source text in a canonical layout.
+// Schemas given as descriptors are normalized to source text as well.
+func (f *Format) Normalize(schemaStr string, refs []types.ResolvedReference) (string, error) {
+	fileDesc, err := f.parseSchema(schemaStr, refs)
 	if err != nil {
-		return fmt.Errorf("create file descriptor: %w", err)
+		return "", err
 	}
+	return printSchema(fileDesc)
+}
 
-	return nil
+func (f *Format) Serialize(data interface{}, schemaStr string, refs []types.ResolvedReference) ([]byte, error) {
+	return f.SerializeMessage(data, schemaStr, refs, "")
 }
 
-func (f *Format) Serialize(data interface{}, schemaStr string) ([]byte, error) {
-	// Parse the schema string as a FileDescriptorProto
-	var fileDescProto descriptorpb.FileDescriptorProto
-	if err := protojson.Unmarshal([]byte(schemaStr), &fileDescProto); err != nil {
-		return nil, fmt.Errorf("unmarshal schema: %w", err)
-	}
+func (f *Format) Deserialize(data []byte, schemaStr string, refs []types.ResolvedReference) (interface{}, error) {
+	return f.DeserializeMessage(data, schemaStr, refs, "")
+}
 
-	// Create a FileDescriptor from the FileDescriptorProto
-	fileDesc, err := protodesc.NewFile(&fileDescProto, protoregistry.GlobalFiles)
+// SerializeMessage serializes data as the message with the given fully-qualified
+// name, or as the first message of the schema if the name is empty. Data is a
+// map or struct in the protobuf JSON mapping, JSON text, or a proto.Message.
+func (f *Format) SerializeMessage(data interface{}, schemaStr string, refs []types.ResolvedReference, messageName string) ([]byte, error) {
+	mt, err := f.messageType(schemaStr, refs, messageName)
 	if err != nil {
-		return nil, fmt.Errorf("create file descriptor: %w", err)
-	}
-
-	// Get the first message type from the file
-	messageType := fileDesc.Messages().Get(0)
-	if messageType == nil {
-		return nil, fmt.Errorf("no message type found in schema")
+		return nil, err
 	}
 
 	// Create a dynamic message
-	message := dynamicpb.NewMessage(messageType)
+	message := dynamicpb.NewMessage(mt.desc)
 
 	// Convert data to protobuf message
-	if err := f.toProtoMessage(message, data); err != nil {
+	if err := f.toProtoMessage(message, data, mt.types); err != nil {
 		return nil, fmt.Errorf("convert to proto message: %w", err)
 	}
 
@@ -69,157 +65,235 @@ func (f *Format) Serialize(data interface{}, schemaStr string) ([]byte, error) {
 	return proto.Marshal(message)
 }
 
-func (f *Format) Deserialize(data []byte, schemaStr string) (interface{}, error) {
-	// Parse the schema string as a FileDescriptorProto
-	var fileDescProto descriptorpb.FileDescriptorProto
-	if err := protojson.Unmarshal([]byte(schemaStr), &fileDescProto); err != nil {
-		return nil, fmt.Errorf("unmarshal schema: %w", err)
-	}
-
-	// Create a FileDescriptor from the FileDescriptorProto
-	fileDesc, err := protodesc.NewFile(&fileDescProto, protoregistry.GlobalFiles)
+// DeserializeMessage deserializes data as the message with the given
+// fully-qualified name, or as the first message of the schema if the name is
+// empty. The message is returned as a map in the protobuf JSON mapping.
+func (f *Format) DeserializeMessage(data []byte, schemaStr string, refs []types.ResolvedReference, messageName string) (interface{}, error) {
+	mt, err := f.messageType(schemaStr, refs, messageName)
 	if err != nil {
-		return nil, fmt.Errorf("create file descriptor: %w", err)
-	}
-
-	// Get the first message type from the file
-	messageType := fileDesc.Messages().Get(0)
-	if messageType == nil {
-		return nil, fmt.Errorf("no message type found in schema")
+		return nil, err
 	}
 
 	// Create a dynamic message
-	message := dynamicpb.NewMessage(messageType)
+	message := dynamicpb.NewMessage(mt.desc)
 
 	// Unmarshal the data into the message
-	if err := proto.Unmarshal(data, message); err != nil {
+	if err := (proto.UnmarshalOptions{Resolver: mt.types}).Unmarshal(data, message); err != nil {
 		return nil, fmt.Errorf("unmarshal proto message: %w", err)
 	}
 
 	// Convert the message to a map
-	return f.fromProtoMessage(message), nil
+	result, err := f.fromProtoMessage(message, mt.types)
+	if err != nil {
+		return nil, fmt.Errorf("convert from proto message: %w", err)
+	}
+	return result, nil
+}
+
+// MessageIndexes returns the indexes locating the message with the given
+// fully-qualified name in the schema, starting with the index of the top-level
+// message followed by the indexes of nested messages. The first message is
+// used if the name is empty.
+func (f *Format) MessageIndexes(schemaStr string, refs []types.ResolvedReference, messageName string) ([]int, error) {
+	mt, err := f.messageType(schemaStr, refs, messageName)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []int
+	for d := protoreflect.Descriptor(mt.desc); ; d = d.Parent() {
+		if _, ok := d.(protoreflect.MessageDescriptor); !ok {
+			break
+		}
+		indexes = append([]int{d.Index()}, indexes...)
+	}
+	return indexes, nil
+}
+
+// MessageName returns the fully-qualified name of the message located by indexes
+func (f *Format) MessageName(schemaStr string, refs []types.ResolvedReference, indexes []int) (string, error) {
+	fileDesc, err := f.parseSchema(schemaStr, refs)
+	if err != nil {
+		return "", err
+	}
+	if len(indexes) == 0 {
+		return "", fmt.Errorf("no message indexes")
+	}
+
+	messages := fileDesc.Messages()
+	var message protoreflect.MessageDescriptor
+	for _, index := range indexes {
+		if index < 0 || index >= messages.Len() {
+			return "", fmt.Errorf("message index %v not found in schema", indexes)
+		}
+		message = messages.Get(index)
+		messages = message.Messages()
+	}
+	return string(message.FullName()), nil
 }
 
-func (f *Format) CheckCompatibility(oldSchema, newSchema string, level types.CompatibilityLevel) (bool, error) {
+// Rules reported in violations
+const (
+	ruleRequiredFieldRemoved = "REQUIRED_FIELD_REMOVED"
+	ruleRequiredFieldAdded   = "REQUIRED_FIELD_ADDED"
+	ruleFieldBecameRequired  = "FIELD_BECAME_REQUIRED"
+	ruleFieldBecameOptional  = "FIELD_BECAME_OPTIONAL"
+	ruleFieldKindChanged     = "FIELD_KIND_CHANGED"
+)
+
+func (f *Format) CheckCompatibility(oldSchema string, oldRefs []types.ResolvedReference, newSchema string, newRefs []types.ResolvedReference, level types.CompatibilityLevel) ([]types.Violation, error) {
 	// Parse schemas
-	oldFileDesc, err := f.parseSchema(oldSchema)
+	oldFileDesc, err := f.parseSchema(oldSchema, oldRefs)
 	if err != nil {
-		return false, fmt.Errorf("parse old schema: %w", err)
+		return nil, fmt.Errorf("parse old schema: %w", err)
 	}
 
-	newFileDesc, err := f.parseSchema(newSchema)
+	newFileDesc, err := f.parseSchema(newSchema, newRefs)
 	if err != nil {
-		return false, fmt.Errorf("parse new schema: %w", err)
+		return nil, fmt.Errorf("parse new schema: %w", err)
 	}
 
 	// Get message types
-	oldMessageType := oldFileDesc.Messages().Get(0)
-	if oldMessageType == nil {
-		return false, fmt.Errorf("no message type found in old schema")
+	if oldFileDesc.Messages().Len() == 0 {
+		return nil, fmt.Errorf("no message type found in old schema")
 	}
+	oldMessageType := oldFileDesc.Messages().Get(0)
 
-	newMessageType := newFileDesc.Messages().Get(0)
-	if newMessageType == nil {
-		return false, fmt.Errorf("no message type found in new schema")
+	if newFileDesc.Messages().Len() == 0 {
+		return nil, fmt.Errorf("no message type found in new schema")
 	}
+	newMessageType := newFileDesc.Messages().Get(0)
 
 	// Check compatibility based on level
 	switch level {
 	case types.Backward, types.BackwardTransitive:
 		// New schema can read data written with old schema
-		return f.isBackwardCompatible(oldMessageType, newMessageType)
+		return f.checkBackward(oldMessageType, newMessageType), nil
 	case types.Forward, types.ForwardTransitive:
 		// Old schema can read data written with new schema
-		return f.isForwardCompatible(oldMessageType, newMessageType)
+		return f.checkForward(oldMessageType, newMessageType), nil
 	case types.Full, types.FullTransitive:
 		// Both backward and forward compatibility
-		backward, err := f.isBackwardCompatible(oldMessageType, newMessageType)
-		if err != nil || !backward {
-			return false, err
-		}
-		return f.isForwardCompatible(oldMessageType, newMessageType)
+		return append(f.checkBackward(oldMessageType, newMessageType), f.checkForward(oldMessageType, newMessageType)...), nil
 	case types.None:
-		return true, nil
+		return nil, nil
 	default:
-		return false, fmt.Errorf("unsupported compatibility level: %s", level)
+		return nil, fmt.Errorf("unsupported compatibility level: %s", level)
 	}
 }
 
-// isBackwardCompatible checks if new schema can read data written with old schema
-func (f *Format) isBackwardCompatible(oldMessage, newMessage protoreflect.MessageDescriptor) (bool, error) {
+// checkBackward checks if new schema can read data written with old schema
+func (f *Format) checkBackward(oldMessage, newMessage protoreflect.MessageDescriptor) []types.Violation {
+	var violations []types.Violation
+
 	// Get fields from both messages
 	oldFields := f.getFields(oldMessage)
 	newFields := f.getFields(newMessage)
 
 	// Check each field in the old message
-	for name, oldField := range oldFields {
+	for _, name := range sortedNames(oldFields) {
+		oldField := oldFields[name]
+		path := string(oldMessage.FullName()) + "." + name
 		newField, exists := newFields[name]
 		if !exists {
 			// Field was removed
 			if oldField.required {
-				return false, fmt.Errorf("required field %s was removed", name)
+				violations = append(violations, types.Violation{
+					Path:    path,
+					OldType: oldField.type_,
+					Rule:    ruleRequiredFieldRemoved,
+					Message: fmt.Sprintf("required field %s was removed", name),
+				})
 			}
 			continue
 		}
 
 		// Check type compatibility
 		if !f.isTypeCompatible(oldField.type_, newField.type_) {
-			return false, fmt.Errorf("incompatible types for field %s: %s -> %s", name, oldField.type_, newField.type_)
+			violations = append(violations, types.Violation{
+				Path:    path,
+				OldType: oldField.type_,
+				NewType: newField.type_,
+				Rule:    ruleFieldKindChanged,
+				Message: fmt.Sprintf("incompatible types for field %s: %s -> %s", name, oldField.type_, newField.type_),
+			})
 		}
 
 		// Check if field became required
 		if !oldField.required && newField.required {
-			return false, fmt.Errorf("field %s became required", name)
+			violations = append(violations, types.Violation{
+				Path:    path,
+				OldType: oldField.type_,
+				NewType: newField.type_,
+				Rule:    ruleFieldBecameRequired,
+				Message: fmt.Sprintf("field %s became required", name),
+			})
 		}
 	}
 
-	return true, nil
+	return violations
 }
 
-// isForwardCompatible checks if old schema can read data written with new schema
-func (f *Format) isForwardCompatible(oldMessage, newMessage protoreflect.MessageDescriptor) (bool, error) {
+// checkForward checks if old schema can read data written with new schema
+func (f *Format) checkForward(oldMessage, newMessage protoreflect.MessageDescriptor) []types.Violation {
+	var violations []types.Violation
+
 	// Get fields from both messages
 	oldFields := f.getFields(oldMessage)
 	newFields := f.getFields(newMessage)
 
 	// Check each field in the new message
-	for name, newField := range newFields {
+	for _, name := range sortedNames(newFields) {
+		newField := newFields[name]
+		path := string(newMessage.FullName()) + "." + name
 		oldField, exists := oldFields[name]
 		if !exists {
 			// New field was added
 			if newField.required {
-				return false, fmt.Errorf("new required field %s was added", name)
+				violations = append(violations, types.Violation{
+					Path:    path,
+					NewType: newField.type_,
+					Rule:    ruleRequiredFieldAdded,
+					Message: fmt.Sprintf("new required field %s was added", name),
+				})
 			}
 			continue
 		}
 
 		// Check type compatibility
 		if !f.isTypeCompatible(newField.type_, oldField.type_) {
-			return false, fmt.Errorf("incompatible types for field %s: %s -> %s", name, newField.type_, oldField.type_)
+			violations = append(violations, types.Violation{
+				Path:    path,
+				OldType: oldField.type_,
+				NewType: newField.type_,
+				Rule:    ruleFieldKindChanged,
+				Message: fmt.Sprintf("incompatible types for field %s: %s -> %s", name, newField.type_, oldField.type_),
+			})
 		}
 
 		// Check if field became optional
 		if oldField.required && !newField.required {
-			return false, fmt.Errorf("field %s became optional", name)
+			violations = append(violations, types.Violation{
+				Path:    path,
+				OldType: oldField.type_,
+				NewType: newField.type_,
+				Rule:    ruleFieldBecameOptional,
+				Message: fmt.Sprintf("field %s became optional", name),
+			})
 		}
 	}
 
-	return true, nil
+	return violations
 }
 
-// parseSchema parses a protobuf schema string into a FileDescriptor
-func (f *Format) parseSchema(schemaStr string) (protoreflect.FileDescriptor, error) {
-	var fileDescProto descriptorpb.FileDescriptorProto
-	if err := protojson.Unmarshal([]byte(schemaStr), &fileDescProto); err != nil {
-		return nil, fmt.Errorf("unmarshal schema: %w", err)
+// sortedNames returns the field names in a stable order
+func sortedNames(fields map[string]fieldInfo) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
 	}
-
-	fileDesc, err := protodesc.NewFile(&fileDescProto, protoregistry.GlobalFiles)
-	if err != nil {
-		return nil, fmt.Errorf("create file descriptor: %w", err)
-	}
-
-	return fileDesc, nil
+	sort.Strings(names)
+	return names
 }
 
 type fieldInfo struct {
@@ -236,7 +310,7 @@ func (f *Format) getFields(message protoreflect.MessageDescriptor) map[string]fi
 		name := string(field.Name())
 		// A field is required if it has REQUIRED cardinality
 		required := field.Cardinality() == protoreflect.Required
-		type_ := string(field.Kind())
+		type_ := field.Kind().String()
 
 		fields[name] = fieldInfo{
 			required: required,
@@ -289,14 +363,44 @@ func (f *Format) isTypeCompatible(oldType, newType string) bool {
 	}
 }
 
-func (f *Format) toProtoMessage(message protoreflect.Message, data interface{}) error {
-	// TODO: Implement conversion from data to protobuf message
-	// This is a simplified version that does nothing
-	return nil
+// toProtoMessage fills a message from data in the protobuf JSON mapping
+func (f *Format) toProtoMessage(message *dynamicpb.Message, data interface{}, resolver *dynamicpb.Types) error {
+	var raw []byte
+	switch d := data.(type) {
+	case proto.Message:
+		// Copy through the wire format, which only needs matching field numbers
+		b, err := proto.Marshal(d)
+		if err != nil {
+			return err
+		}
+		return proto.UnmarshalOptions{Resolver: resolver}.Unmarshal(b, message)
+	case []byte:
+		raw = d
+	case json.RawMessage:
+		raw = d
+	case string:
+		raw = []byte(d)
+	default:
+		var err error
+		if raw, err = json.Marshal(data); err != nil {
+			return fmt.Errorf("marshal data: %w", err)
+		}
+	}
+
+	return protojson.UnmarshalOptions{Resolver: resolver}.Unmarshal(raw, message)
 }
 
-func (f *Format) fromProtoMessage(message protoreflect.Message) interface{} {
-	// TODO: Implement conversion from protobuf message to data
-	// This is a simplified version that returns nil
-	return nil
+// fromProtoMessage converts a message to a map in the protobuf JSON mapping,
+// keyed by the field names used in the schema
+func (f *Format) fromProtoMessage(message *dynamicpb.Message, resolver *dynamicpb.Types) (map[string]interface{}, error) {
+	raw, err := protojson.MarshalOptions{UseProtoNames: true, Resolver: resolver}.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
@@ -0,0 +1,327 @@
+package protobuf
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"schemaregistry/internal/schema/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const userProto = `syntax = "proto3";
+package com.example;
+
+import "google/protobuf/any.proto";
+import "google/protobuf/duration.proto";
+import "google/protobuf/struct.proto";
+import "google/protobuf/timestamp.proto";
+
+option java_package = "com.example.proto";
+
+message User {
+  string name = 1;
+  int32 age = 2 [deprecated = true];
+  google.protobuf.Timestamp created = 3;
+  map<string, string> labels = 4;
+  Address address = 5;
+  repeated string emails = 6;
+
+  oneof contact {
+    string phone = 7;
+    string fax = 8;
+  }
+
+  message Address {
+    string street = 1;
+    Kind kind = 2;
+
+    enum Kind {
+      HOME = 0;
+      WORK = 1;
+    }
+  }
+}
+
+message Audit {
+  string actor = 1;
+  google.protobuf.Any detail = 2;
+  google.protobuf.Duration ttl = 3;
+  google.protobuf.Struct extra = 4;
+}
+`
+
+func TestFormat_ParseSchema(t *testing.T) {
+	f := New()
+
+	t.Run("Proto3 Source", func(t *testing.T) {
+		fd, err := f.parseSchema(userProto, nil)
+		require.NoError(t, err)
+		assert.Equal(t, protoreflect.FullName("com.example"), fd.Package())
+
+		user := fd.Messages().ByName("User")
+		require.NotNil(t, user)
+		assert.True(t, user.Fields().ByName("labels").IsMap())
+		assert.Equal(t, protoreflect.FullName("google.protobuf.Timestamp"), user.Fields().ByName("created").Message().FullName())
+		assert.Equal(t, protoreflect.Name("contact"), user.Fields().ByName("phone").ContainingOneof().Name())
+		assert.Equal(t, protoreflect.EnumKind, user.Messages().ByName("Address").Fields().ByName("kind").Kind())
+	})
+
+	t.Run("Proto2 Source", func(t *testing.T) {
+		fd, err := f.parseSchema(`syntax = "proto2";
+message Order {
+  required string id = 1;
+  optional int64 amount = 2 [default = 10];
+}`, nil)
+		require.NoError(t, err)
+		id := fd.Messages().ByName("Order").Fields().ByName("id")
+		assert.Equal(t, protoreflect.Required, id.Cardinality())
+	})
+
+	t.Run("JSON Descriptor", func(t *testing.T) {
+		source, err := f.parseSchema(userProto, nil)
+		require.NoError(t, err)
+		descriptor, err := protojson.Marshal(protodesc.ToFileDescriptorProto(source))
+		require.NoError(t, err)
+
+		fd, err := f.parseSchema(string(descriptor), nil)
+		require.NoError(t, err)
+		assert.NotNil(t, fd.Messages().ByName("User"))
+	})
+
+	t.Run("Base64 Descriptor", func(t *testing.T) {
+		source, err := f.parseSchema(userProto, nil)
+		require.NoError(t, err)
+		descriptor, err := proto.Marshal(protodesc.ToFileDescriptorProto(source))
+		require.NoError(t, err)
+
+		fd, err := f.parseSchema(base64.StdEncoding.EncodeToString(descriptor), nil)
+		require.NoError(t, err)
+		assert.NotNil(t, fd.Messages().ByName("User"))
+	})
+
+	t.Run("Invalid Source", func(t *testing.T) {
+		assert.Error(t, f.Validate(`syntax = "proto3"; message User { string name = 1 }`, nil))
+		assert.Error(t, f.Validate(`syntax = "proto3"; message User { Unknown field = 1; }`, nil))
+		assert.Error(t, f.Validate(`syntax = "proto3"; import "other.proto";`, nil))
+		assert.Error(t, f.Validate("", nil))
+	})
+}
+
+func TestFormat_SerializeDeserialize(t *testing.T) {
+	f := New()
+
+	tests := []struct {
+		name        string
+		messageName string
+		data        string
+	}{
+		{
+			name: "First Message",
+			data: `{
+				"name": "Ada",
+				"age": 36,
+				"created": "2024-01-02T03:04:05Z",
+				"labels": {"team": "core"},
+				"address": {"street": "Main St", "kind": "WORK"},
+				"emails": ["ada@example.com", "ada@example.org"],
+				"phone": "555-0100"
+			}`,
+		},
+		{
+			name:        "Named Message",
+			messageName: "com.example.Audit",
+			data: `{
+				"actor": "admin",
+				"detail": {"@type": "type.googleapis.com/com.example.User.Address", "street": "Main St"},
+				"ttl": "1.500s",
+				"extra": {"reason": "rotation", "attempts": 2}
+			}`,
+		},
+		{
+			name:        "Nested Message",
+			messageName: "com.example.User.Address",
+			data:        `{"street": "Main St", "kind": "WORK"}`,
+		},
+		{
+			name:        "Empty Message",
+			messageName: ".com.example.User",
+			data:        `{}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(tt.data), &data))
+
+			payload, err := f.SerializeMessage(data, userProto, nil, tt.messageName)
+			require.NoError(t, err)
+
+			result, err := f.DeserializeMessage(payload, userProto, nil, tt.messageName)
+			require.NoError(t, err)
+			actual, err := json.Marshal(result)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.data, string(actual))
+		})
+	}
+
+	t.Run("JSON Text", func(t *testing.T) {
+		payload, err := f.Serialize(`{"name": "Ada", "address": {"kind": 1}}`, userProto, nil)
+		require.NoError(t, err)
+
+		result, err := f.Deserialize(payload, userProto, nil)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"name":    "Ada",
+			"address": map[string]interface{}{"kind": "WORK"},
+		}, result)
+	})
+
+	t.Run("Oneof", func(t *testing.T) {
+		_, err := f.Serialize(map[string]interface{}{"phone": "555-0100", "fax": "555-0101"}, userProto, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("Unknown Field", func(t *testing.T) {
+		_, err := f.Serialize(map[string]interface{}{"nickname": "ada"}, userProto, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("Unknown Message", func(t *testing.T) {
+		_, err := f.SerializeMessage(map[string]interface{}{}, userProto, nil, "com.example.Missing")
+		assert.Error(t, err)
+		_, err = f.SerializeMessage(map[string]interface{}{}, userProto, nil, "google.protobuf.Timestamp")
+		assert.Error(t, err)
+	})
+}
+
+func TestFormat_MessageIndexes(t *testing.T) {
+	f := New()
+
+	tests := []struct {
+		messageName string
+		indexes     []int
+	}{
+		{messageName: "com.example.User", indexes: []int{0}},
+		{messageName: "com.example.Audit", indexes: []int{1}},
+		// LabelsEntry, generated for the map field, is the first nested message
+		{messageName: "com.example.User.Address", indexes: []int{0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.messageName, func(t *testing.T) {
+			indexes, err := f.MessageIndexes(userProto, nil, tt.messageName)
+			require.NoError(t, err)
+			assert.Equal(t, tt.indexes, indexes)
+
+			name, err := f.MessageName(userProto, nil, tt.indexes)
+			require.NoError(t, err)
+			assert.Equal(t, tt.messageName, name)
+		})
+	}
+
+	t.Run("Default Message", func(t *testing.T) {
+		indexes, err := f.MessageIndexes(userProto, nil, "")
+		require.NoError(t, err)
+		assert.Equal(t, []int{0}, indexes)
+	})
+
+	t.Run("Unknown Indexes", func(t *testing.T) {
+		_, err := f.MessageName(userProto, nil, []int{2})
+		assert.Error(t, err)
+		_, err = f.MessageName(userProto, nil, []int{0, 5})
+		assert.Error(t, err)
+	})
+}
+
+func TestFormat_References(t *testing.T) {
+	f := New()
+
+	order := `syntax = "proto3";
+package com.example;
+
+import "address.proto";
+
+message Order {
+  string id = 1;
+  com.example.common.Address ship_to = 2;
+}
+`
+	refs := []types.ResolvedReference{{
+		Name:    "address.proto",
+		Subject: "address",
+		Version: 1,
+		Schema: `syntax = "proto3";
+package com.example.common;
+
+message Address {
+  string street = 1;
+}
+`,
+	}}
+
+	t.Run("Validate", func(t *testing.T) {
+		assert.NoError(t, f.Validate(order, refs))
+		assert.Error(t, f.Validate(order, nil))
+	})
+
+	t.Run("Serialize Deserialize", func(t *testing.T) {
+		data := map[string]interface{}{"id": "o-1", "ship_to": map[string]interface{}{"street": "Main St"}}
+		payload, err := f.Serialize(data, order, refs)
+		require.NoError(t, err)
+
+		result, err := f.Deserialize(payload, order, refs)
+		require.NoError(t, err)
+		assert.Equal(t, data, result)
+	})
+
+	t.Run("Messages Of The Schema Only", func(t *testing.T) {
+		indexes, err := f.MessageIndexes(order, refs, "com.example.Order")
+		require.NoError(t, err)
+		assert.Equal(t, []int{0}, indexes)
+		_, err = f.MessageIndexes(order, refs, "com.example.common.Address")
+		assert.Error(t, err)
+	})
+}
+
+func TestFormat_Normalize(t *testing.T) {
+	f := New()
+
+	want := `syntax = "proto3";
+package com.example;
+message Order {
+  string id = 1;
+  repeated Line lines = 2;
+  message Line {
+    string sku = 1;
+  }
+}
+`
+
+	t.Run("Source", func(t *testing.T) {
+		normalized, err := f.Normalize(`syntax="proto3";   package com.example;
+// An order
+message Order { string id = 1;
+    repeated Line lines = 2; message Line { string sku = 1; } }`, nil)
+		require.NoError(t, err)
+		assert.Equal(t, want, normalized)
+	})
+
+	t.Run("Descriptor", func(t *testing.T) {
+		source, err := f.parseSchema(want, nil)
+		require.NoError(t, err)
+		descriptor, err := protojson.Marshal(protodesc.ToFileDescriptorProto(source))
+		require.NoError(t, err)
+
+		normalized, err := f.Normalize(string(descriptor), nil)
+		require.NoError(t, err)
+		assert.Equal(t, want, normalized)
+	})
+}
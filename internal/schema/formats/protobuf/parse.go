@@ -0,0 +1,183 @@
+package protobuf
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"schemaregistry/internal/schema/types"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoprint"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// schemaFileName is the file name given to a schema that doesn't carry one
+const schemaFileName = "schema.proto"
+
+// parseSchema parses a Protobuf schema into a file descriptor. The schema is
+// .proto source text as sent by Confluent clients, or a FileDescriptorProto
+// either in JSON or base64 encoded in the binary wire format. Imports are
+// resolved from the referenced schemas, whose reference names are the import
+// paths, and from the well-known types built into the registry.
+func (f *Format) parseSchema(schemaStr string, refs []types.ResolvedReference) (protoreflect.FileDescriptor, error) {
+	name, result, err := searchResult(schemaStr)
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(protocompile.ResolverFunc(func(path string) (protocompile.SearchResult, error) {
+			if path == name {
+				return result, nil
+			}
+			for _, ref := range refs {
+				if ref.Name != path {
+					continue
+				}
+				_, result, err := searchResult(ref.Schema)
+				if err != nil {
+					return protocompile.SearchResult{}, fmt.Errorf("reference %s: %w", ref.Name, err)
+				}
+				// Descriptors carry their own name, which must match the import
+				if result.Proto != nil {
+					result.Proto.Name = proto.String(path)
+				}
+				return result, nil
+			}
+			return protocompile.SearchResult{}, fmt.Errorf("import %s: %w", path, protoregistry.NotFound)
+		})),
+	}
+	files, err := compiler.Compile(context.Background(), name)
+	if err != nil {
+		return nil, fmt.Errorf("compile schema: %w", err)
+	}
+
+	return files[0], nil
+}
+
+// printSchema renders a file descriptor as .proto source text in a canonical
+// layout, without comments
+func printSchema(fileDesc protoreflect.FileDescriptor) (string, error) {
+	wrapped, err := desc.WrapFile(fileDesc)
+	if err != nil {
+		return "", fmt.Errorf("wrap descriptor: %w", err)
+	}
+
+	printer := protoprint.Printer{Compact: true, OmitComments: protoprint.CommentsAll, Indent: "  "}
+	var b strings.Builder
+	if err := printer.PrintProtoFile(wrapped, &b); err != nil {
+		return "", fmt.Errorf("print schema: %w", err)
+	}
+	return b.String(), nil
+}
+
+// searchResult detects the form of a schema and returns the file name and
+// compiler input for it
+func searchResult(schemaStr string) (string, protocompile.SearchResult, error) {
+	trimmed := strings.TrimSpace(schemaStr)
+	if trimmed == "" {
+		return "", protocompile.SearchResult{}, errors.New("empty schema")
+	}
+
+	// A FileDescriptorProto in JSON
+	if strings.HasPrefix(trimmed, "{") {
+		var fileDescProto descriptorpb.FileDescriptorProto
+		if err := protojson.Unmarshal([]byte(trimmed), &fileDescProto); err != nil {
+			return "", protocompile.SearchResult{}, fmt.Errorf("unmarshal schema: %w", err)
+		}
+		return descriptorResult(&fileDescProto)
+	}
+
+	// A base64 encoded FileDescriptorProto. Source text never decodes as base64
+	// since statements are separated by spaces and semicolons.
+	if raw, err := base64.StdEncoding.DecodeString(trimmed); err == nil {
+		var fileDescProto descriptorpb.FileDescriptorProto
+		if err := proto.Unmarshal(raw, &fileDescProto); err != nil {
+			return "", protocompile.SearchResult{}, fmt.Errorf("unmarshal schema: %w", err)
+		}
+		return descriptorResult(&fileDescProto)
+	}
+
+	return schemaFileName, protocompile.SearchResult{Source: strings.NewReader(schemaStr)}, nil
+}
+
+// descriptorResult returns the compiler input for a FileDescriptorProto
+func descriptorResult(fileDescProto *descriptorpb.FileDescriptorProto) (string, protocompile.SearchResult, error) {
+	if fileDescProto.GetName() == "" {
+		fileDescProto.Name = proto.String(schemaFileName)
+	}
+	return fileDescProto.GetName(), protocompile.SearchResult{Proto: fileDescProto}, nil
+}
+
+// messageType is a message of a schema together with the types it may use
+type messageType struct {
+	desc  protoreflect.MessageDescriptor
+	types *dynamicpb.Types
+}
+
+// messageType parses a schema and finds the message with the given
+// fully-qualified name, or the first message if the name is empty
+func (f *Format) messageType(schemaStr string, refs []types.ResolvedReference, messageName string) (*messageType, error) {
+	fileDesc, err := f.parseSchema(schemaStr, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fileRegistry(fileDesc)
+	if err != nil {
+		return nil, err
+	}
+	mt := &messageType{types: dynamicpb.NewTypes(files)}
+
+	if messageName == "" {
+		if fileDesc.Messages().Len() == 0 {
+			return nil, fmt.Errorf("no message type found in schema")
+		}
+		mt.desc = fileDesc.Messages().Get(0)
+		return mt, nil
+	}
+
+	// Only messages declared by the schema itself can be selected, not those
+	// of its imports
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(strings.TrimPrefix(messageName, ".")))
+	if err != nil || desc.ParentFile().Path() != fileDesc.Path() {
+		return nil, fmt.Errorf("message %s not found in schema", messageName)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", messageName)
+	}
+	mt.desc = md
+	return mt, nil
+}
+
+// fileRegistry returns a registry holding a file and everything it imports
+func fileRegistry(fileDesc protoreflect.FileDescriptor) (*protoregistry.Files, error) {
+	files := new(protoregistry.Files)
+	var register func(fd protoreflect.FileDescriptor) error
+	register = func(fd protoreflect.FileDescriptor) error {
+		if _, err := files.FindFileByPath(fd.Path()); err == nil {
+			return nil
+		}
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			if err := register(imports.Get(i).FileDescriptor); err != nil {
+				return err
+			}
+		}
+		if err := files.RegisterFile(fd); err != nil {
+			return fmt.Errorf("register %s: %w", fd.Path(), err)
+		}
+		return nil
+	}
+	return files, register(fileDesc)
+}
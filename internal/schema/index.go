@@ -0,0 +1,471 @@
+package schema
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	"schemaregistry/internal/schema/types"
+)
+
+const (
+	// Secondary index keys in the schemas bucket
+	keyPrefixHashIndex    = "index/hashes/"     // index/hashes/{hash} -> schema ID
+	keyPrefixSubjectIndex = "index/subjects/"   // index/subjects/{subject} -> versions of the subject
+	keyPrefixIDIndex      = "index/ids/"        // index/ids/{id} -> subject versions using the ID
+	keyPrefixRefIndex     = "index/references/" // index/references/{subject}/{version} -> subject versions referencing it
+	keyIndexVersion       = "index/version"     // layout of the indexes, set once they have been built
+
+	// Current layout of the indexes
+	indexLayoutVersion = "2"
+)
+
+// versionEntry is a single version in a subject index
+type versionEntry struct {
+	Version int  `json:"version"`
+	ID      int  `json:"id"`
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// subjectIndex lists the versions of a subject, ordered by version
+type subjectIndex struct {
+	Versions []versionEntry `json:"versions"`
+}
+
+// latest returns the highest version, optionally skipping soft-deleted versions, or 0
+func (idx *subjectIndex) latest(includeDeleted bool) int {
+	for i := len(idx.Versions) - 1; i >= 0; i-- {
+		if includeDeleted || !idx.Versions[i].Deleted {
+			return idx.Versions[i].Version
+		}
+	}
+	return 0
+}
+
+// find returns the entry for a version, or nil
+func (idx *subjectIndex) find(version int) *versionEntry {
+	for i := range idx.Versions {
+		if idx.Versions[i].Version == version {
+			return &idx.Versions[i]
+		}
+	}
+	return nil
+}
+
+// findLiveID returns the highest live version using a schema ID, or nil
+func (idx *subjectIndex) findLiveID(id int) *versionEntry {
+	for i := len(idx.Versions) - 1; i >= 0; i-- {
+		if idx.Versions[i].ID == id && !idx.Versions[i].Deleted {
+			return &idx.Versions[i]
+		}
+	}
+	return nil
+}
+
+// put adds or replaces the entry for a version
+func (idx *subjectIndex) put(entry versionEntry) {
+	if existing := idx.find(entry.Version); existing != nil {
+		*existing = entry
+		return
+	}
+	idx.Versions = append(idx.Versions, entry)
+	sort.Slice(idx.Versions, func(i, j int) bool {
+		return idx.Versions[i].Version < idx.Versions[j].Version
+	})
+}
+
+// remove drops the entry for a version
+func (idx *subjectIndex) remove(version int) {
+	for i := range idx.Versions {
+		if idx.Versions[i].Version == version {
+			idx.Versions = append(idx.Versions[:i], idx.Versions[i+1:]...)
+			return
+		}
+	}
+}
+
+// schemaUsage identifies a subject version that uses a schema ID
+type schemaUsage struct {
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+}
+
+// schemaHash returns the content address of a schema, a digest of its type,
+// text and references
+func schemaHash(schemaStr string, schemaType types.SchemaType, references []types.SchemaReference) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", schemaType, schemaStr)
+	for _, ref := range references {
+		fmt.Fprintf(h, "%s\x00%s\x00%d\x00", ref.Name, ref.Subject, ref.Version)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// updateKey applies fn to the current value of a key and writes the result with
+// compare-and-set, retrying when another writer got there first. fn receives nil
+// if the key does not exist. If fn returns nil the key is deleted.
+func (r *Registry) updateKey(ctx context.Context, key string, fn func(value []byte) ([]byte, error)) error {
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		var current []byte
+		var revision uint64
+		entry, err := r.schemaStore(ctx).Get(key)
+		switch {
+		case err == nil:
+			current = entry.Value
+			revision = entry.Revision
+		case err != ErrKeyNotFound:
+			return fmt.Errorf("get %s: %w", key, err)
+		}
+
+		value, err := fn(current)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case value == nil && current == nil:
+			return nil
+		case value == nil:
+			err = r.schemaStore(ctx).Delete(key, revision)
+		case current == nil:
+			_, err = r.schemaStore(ctx).Create(key, value)
+		default:
+			_, err = r.schemaStore(ctx).Update(key, value, revision)
+		}
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrKeyExists) {
+			return fmt.Errorf("update %s: %w", key, err)
+		}
+	}
+
+	return fmt.Errorf("update %s: too many concurrent updates", key)
+}
+
+// getSubjectIndex gets the version index of a subject. A subject without
+// versions yields an empty index. The index is derived from the version
+// records: if it looks stale, because a writer failed to update it after
+// storing a version, the versions it misses are added back from the records.
+func (r *Registry) getSubjectIndex(ctx context.Context, subject string) (*subjectIndex, error) {
+	idx, err := r.readSubjectIndex(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cache tells which versions to check once hydrated. Until then, all
+	// of them are if there is a version after the latest indexed one.
+	suspects, ok := r.cacheDisagrees(subject, idx)
+	if !ok {
+		_, err := r.schemaStore(ctx).Get(versionKey(subject, idx.latest(true)+1))
+		if err == ErrKeyNotFound {
+			return idx, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get subject version: %w", err)
+		}
+
+		prefix := subjectKey(keyPrefixSubjects, subject) + "/versions/"
+		keys, err := r.schemaStore(ctx).List(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("get version keys: %w", err)
+		}
+		for _, key := range keys {
+			if version, err := strconv.Atoi(strings.TrimPrefix(key, prefix)); err == nil {
+				suspects = append(suspects, version)
+			}
+		}
+	}
+	r.repairSubjectIndex(ctx, subject, idx, suspects)
+	return idx, nil
+}
+
+// repairSubjectIndex brings the index of a subject in line with the records of
+// some of its versions, as buildIndexes does at startup, updating idx in place.
+// Indexes that cannot be written now are left to be repaired by the next reader.
+func (r *Registry) repairSubjectIndex(ctx context.Context, subject string, idx *subjectIndex, versions []int) {
+	for _, version := range versions {
+		key := versionKey(subject, version)
+		entry, err := r.schemaStore(ctx).Get(key)
+		if err != nil {
+			// Deleted meanwhile
+			continue
+		}
+		var schema types.Schema
+		if err := json.Unmarshal(entry.Value, &schema); err != nil {
+			slog.Warn("Skipping unreadable record", "key", key, "error", err)
+			continue
+		}
+		if indexed := idx.find(version); indexed != nil && indexed.Deleted == schema.Deleted {
+			continue
+		}
+
+		slog.Info("Repairing subject index", "subject", subject, "version", version)
+		if err := r.indexSubjectVersion(ctx, &schema); err != nil {
+			slog.Warn("Failed to repair subject index", "subject", subject, "version", version, "error", err)
+		}
+		idx.put(versionEntry{Version: schema.Version, ID: schema.ID, Deleted: schema.Deleted})
+	}
+}
+
+// readSubjectIndex reads the stored version index of a subject
+func (r *Registry) readSubjectIndex(ctx context.Context, subject string) (*subjectIndex, error) {
+	var idx subjectIndex
+	entry, err := r.schemaStore(ctx).Get(subjectKey(keyPrefixSubjectIndex, subject))
+	if err == ErrKeyNotFound {
+		return &idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get subject index: %w", err)
+	}
+
+	if err := json.Unmarshal(entry.Value, &idx); err != nil {
+		return nil, fmt.Errorf("unmarshal subject index: %w", err)
+	}
+	return &idx, nil
+}
+
+// updateSubjectIndex modifies the version index of a subject. The index key is
+// removed once it holds no versions.
+func (r *Registry) updateSubjectIndex(ctx context.Context, subject string, fn func(idx *subjectIndex)) error {
+	return r.updateKey(ctx, subjectKey(keyPrefixSubjectIndex, subject), func(value []byte) ([]byte, error) {
+		var idx subjectIndex
+		if value != nil {
+			if err := json.Unmarshal(value, &idx); err != nil {
+				return nil, fmt.Errorf("unmarshal subject index: %w", err)
+			}
+		}
+
+		fn(&idx)
+		if len(idx.Versions) == 0 {
+			return nil, nil
+		}
+		return json.Marshal(&idx)
+	})
+}
+
+// updateIDIndex modifies the list of subject versions using a schema ID of a
+// context. The index key is removed once no version uses the ID.
+func (r *Registry) updateIDIndex(ctx context.Context, context string, id int, fn func(usages []schemaUsage) []schemaUsage) error {
+	return r.updateKey(ctx, idIndexKey(context, id), func(value []byte) ([]byte, error) {
+		var usages []schemaUsage
+		if value != nil {
+			if err := json.Unmarshal(value, &usages); err != nil {
+				return nil, fmt.Errorf("unmarshal ID index: %w", err)
+			}
+		}
+
+		usages = fn(usages)
+		if len(usages) == 0 {
+			return nil, nil
+		}
+		return json.Marshal(usages)
+	})
+}
+
+// idIndexKey returns the key listing the subject versions using a schema ID of a context
+func idIndexKey(context string, id int) string {
+	return contextPrefix(context) + keyPrefixIDIndex + strconv.Itoa(id)
+}
+
+// refIndexKey returns the key listing the subject versions that reference a subject version
+func refIndexKey(subject string, version int) string {
+	return fmt.Sprintf("%s/%d", subjectKey(keyPrefixRefIndex, subject), version)
+}
+
+// updateRefIndex modifies the list of subject versions referencing a subject
+// version. The index key is removed once nothing references the version.
+func (r *Registry) updateRefIndex(ctx context.Context, ref types.SchemaReference, fn func(usages []schemaUsage) []schemaUsage) error {
+	return r.updateKey(ctx, refIndexKey(ref.Subject, ref.Version), func(value []byte) ([]byte, error) {
+		var usages []schemaUsage
+		if value != nil {
+			if err := json.Unmarshal(value, &usages); err != nil {
+				return nil, fmt.Errorf("unmarshal reference index: %w", err)
+			}
+		}
+
+		usages = fn(usages)
+		if len(usages) == 0 {
+			return nil, nil
+		}
+		return json.Marshal(usages)
+	})
+}
+
+// getReferrers gets the subject versions, live or soft-deleted, referencing a subject version
+func (r *Registry) getReferrers(ctx context.Context, subject string, version int) ([]schemaUsage, error) {
+	entry, err := r.schemaStore(ctx).Get(refIndexKey(subject, version))
+	if err == ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get reference index: %w", err)
+	}
+
+	var usages []schemaUsage
+	if err := json.Unmarshal(entry.Value, &usages); err != nil {
+		return nil, fmt.Errorf("unmarshal reference index: %w", err)
+	}
+	return usages, nil
+}
+
+// getIDUsages gets the subject versions using a schema ID of a context
+func (r *Registry) getIDUsages(ctx context.Context, context string, id int) ([]schemaUsage, error) {
+	entry, err := r.schemaStore(ctx).Get(idIndexKey(context, id))
+	if err == ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get ID index: %w", err)
+	}
+
+	var usages []schemaUsage
+	if err := json.Unmarshal(entry.Value, &usages); err != nil {
+		return nil, fmt.Errorf("unmarshal ID index: %w", err)
+	}
+	return usages, nil
+}
+
+// lookupSchemaID returns the ID registered for a schema's content in a context, or 0
+func (r *Registry) lookupSchemaID(ctx context.Context, context, hash string) (int, error) {
+	entry, err := r.schemaStore(ctx).Get(hashIndexKey(context, hash))
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get hash index: %w", err)
+	}
+
+	id, err := strconv.Atoi(string(entry.Value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid hash index entry: %w", err)
+	}
+	return id, nil
+}
+
+// indexSubjectVersion records a subject version in the ID, reference and
+// subject indexes. The subject index is written last, so that a version it
+// lists is also in the other indexes, and one it misses is indexed again when
+// the subject index is rebuilt. Indexing is idempotent.
+func (r *Registry) indexSubjectVersion(ctx context.Context, schema *types.Schema) error {
+	context := ContextOf(schema.Subject)
+	usage := schemaUsage{Subject: schema.Subject, Version: schema.Version}
+	if err := r.updateIDIndex(ctx, context, schema.ID, addUsage(usage)); err != nil {
+		return err
+	}
+	for _, ref := range schema.References {
+		ref.Subject = qualifyReference(context, ref.Subject)
+		if err := r.updateRefIndex(ctx, ref, addUsage(usage)); err != nil {
+			return err
+		}
+	}
+	return r.updateSubjectIndex(ctx, schema.Subject, func(idx *subjectIndex) {
+		idx.put(versionEntry{Version: schema.Version, ID: schema.ID, Deleted: schema.Deleted})
+	})
+}
+
+// unindexSubjectVersion removes a permanently deleted subject version from the subject, ID and reference indexes
+func (r *Registry) unindexSubjectVersion(ctx context.Context, schema *types.Schema) error {
+	if err := r.updateSubjectIndex(ctx, schema.Subject, func(idx *subjectIndex) {
+		idx.remove(schema.Version)
+	}); err != nil {
+		return err
+	}
+
+	context := ContextOf(schema.Subject)
+	usage := schemaUsage{Subject: schema.Subject, Version: schema.Version}
+	if err := r.updateIDIndex(ctx, context, schema.ID, removeUsage(usage)); err != nil {
+		return err
+	}
+	for _, ref := range schema.References {
+		ref.Subject = qualifyReference(context, ref.Subject)
+		if err := r.updateRefIndex(ctx, ref, removeUsage(usage)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addUsage returns an index update adding a subject version once
+func addUsage(usage schemaUsage) func(usages []schemaUsage) []schemaUsage {
+	return func(usages []schemaUsage) []schemaUsage {
+		for _, u := range usages {
+			if u == usage {
+				return usages
+			}
+		}
+		return append(usages, usage)
+	}
+}
+
+// removeUsage returns an index update removing a subject version
+func removeUsage(usage schemaUsage) func(usages []schemaUsage) []schemaUsage {
+	return func(usages []schemaUsage) []schemaUsage {
+		for i, u := range usages {
+			if u == usage {
+				return append(usages[:i], usages[i+1:]...)
+			}
+		}
+		return usages
+	}
+}
+
+// buildIndexes populates the indexes from the schema and version records the
+// first time a bucket is opened by a registry that maintains them, and again
+// whenever the layout of the indexes changes. Building is idempotent.
+func (r *Registry) buildIndexes(ctx context.Context) error {
+	if entry, err := r.schemaStore(ctx).Get(keyIndexVersion); err == nil {
+		if string(entry.Value) == indexLayoutVersion {
+			return nil
+		}
+	} else if err != ErrKeyNotFound {
+		return fmt.Errorf("get index version: %w", err)
+	}
+
+	keys, err := r.schemaStore(ctx).List("")
+	if err != nil {
+		return fmt.Errorf("get schema keys: %w", err)
+	}
+
+	slog.Info("Building schema indexes", "keys", len(keys))
+	for _, key := range keys {
+		context, rest := splitKey(key)
+		isSchema := strings.HasPrefix(rest, keyPrefixSchemas)
+		if !isSchema && !strings.HasPrefix(rest, keyPrefixSubjects) {
+			continue
+		}
+
+		entry, err := r.schemaStore(ctx).Get(key)
+		if err != nil {
+			continue
+		}
+		var schema types.Schema
+		if err := json.Unmarshal(entry.Value, &schema); err != nil {
+			slog.Warn("Skipping unreadable record", "key", key, "error", err)
+			continue
+		}
+
+		if isSchema {
+			hash := schemaHash(schema.Schema, schema.Type, schema.References)
+			if _, err := r.schemaStore(ctx).Create(hashIndexKey(context, hash), []byte(strconv.Itoa(schema.ID))); err != nil && !errors.Is(err, ErrKeyExists) {
+				return fmt.Errorf("index schema %d: %w", schema.ID, err)
+			}
+			continue
+		}
+		if err := r.indexSubjectVersion(ctx, &schema); err != nil {
+			return fmt.Errorf("index %s: %w", key, err)
+		}
+	}
+
+	if _, err := r.schemaStore(ctx).Put(keyIndexVersion, []byte(indexLayoutVersion)); err != nil {
+		return fmt.Errorf("store index version: %w", err)
+	}
+	return nil
+}
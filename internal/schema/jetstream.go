@@ -0,0 +1,198 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// JetStreamStore is a Store backed by a NATS JetStream KeyValue bucket
+type JetStreamStore struct {
+	kv nats.KeyValue
+}
+
+// NewJetStreamStore creates a store on top of a JetStream KeyValue bucket
+func NewJetStreamStore(kv nats.KeyValue) *JetStreamStore {
+	return &JetStreamStore{kv: kv}
+}
+
+// Name returns the name of the bucket
+func (s *JetStreamStore) Name() string {
+	return s.kv.Bucket()
+}
+
+// Get returns the current value of a key
+func (s *JetStreamStore) Get(key string) (*Entry, error) {
+	entry, err := s.kv.Get(key)
+	if err != nil {
+		return nil, jetStreamError(err)
+	}
+	return newJetStreamEntry(entry), nil
+}
+
+// Put writes the value of a key
+func (s *JetStreamStore) Put(key string, value []byte) (uint64, error) {
+	revision, err := s.kv.Put(key, value)
+	return revision, jetStreamError(err)
+}
+
+// Create writes the value of a key that does not exist yet
+func (s *JetStreamStore) Create(key string, value []byte) (uint64, error) {
+	revision, err := s.kv.Create(key, value)
+	return revision, jetStreamError(err)
+}
+
+// Update writes the value of a key whose current value has the given revision
+func (s *JetStreamStore) Update(key string, value []byte, revision uint64) (uint64, error) {
+	revision, err := s.kv.Update(key, value, revision)
+	return revision, jetStreamError(err)
+}
+
+// Delete removes a key, only if its current value has the given revision
+// unless revision is 0
+func (s *JetStreamStore) Delete(key string, revision uint64) error {
+	var opts []nats.DeleteOpt
+	if revision > 0 {
+		opts = append(opts, nats.LastRevision(revision))
+	}
+	return jetStreamError(s.kv.Delete(key, opts...))
+}
+
+// List returns the keys that start with prefix
+func (s *JetStreamStore) List(prefix string) ([]string, error) {
+	keys, err := s.kv.Keys()
+	if err == nats.ErrNoKeysFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	matching := keys[:0]
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			matching = append(matching, key)
+		}
+	}
+	return matching, nil
+}
+
+// Watch streams the current value of every key, then a nil entry, then every change
+func (s *JetStreamStore) Watch() (Watcher, error) {
+	watcher, err := s.kv.WatchAll()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &jetStreamWatcher{
+		watcher: watcher,
+		updates: make(chan *Entry),
+		stop:    make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Revision returns the revision of the latest change to the bucket
+func (s *JetStreamStore) Revision() (uint64, error) {
+	status, err := s.kv.Status()
+	if err != nil {
+		return 0, err
+	}
+	js, ok := status.(interface{ StreamInfo() *nats.StreamInfo })
+	if !ok || js.StreamInfo() == nil {
+		return 0, fmt.Errorf("bucket %s does not report its revision", s.kv.Bucket())
+	}
+	return js.StreamInfo().State.LastSeq, nil
+}
+
+// Stats returns the size of the bucket as reported by JetStream
+func (s *JetStreamStore) Stats() (*StoreStats, error) {
+	status, err := s.kv.Status()
+	if err != nil {
+		return nil, err
+	}
+	return &StoreStats{
+		Backend: status.BackingStore(),
+		Values:  status.Values(),
+		Bytes:   status.Bytes(),
+		History: status.History(),
+		TTL:     status.TTL(),
+	}, nil
+}
+
+// jetStreamWatcher converts the entries of a KeyValue watcher
+type jetStreamWatcher struct {
+	watcher nats.KeyWatcher
+	updates chan *Entry
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// run forwards entries until the KeyValue watcher is closed. The delete
+// markers of removed keys are dropped from the initial values, and entries
+// that arrive once the watcher is stopped are dropped so that the KeyValue
+// watcher never blocks.
+func (w *jetStreamWatcher) run() {
+	defer close(w.updates)
+
+	stopped, initial := false, true
+	for entry := range w.watcher.Updates() {
+		if stopped {
+			continue
+		}
+		var update *Entry
+		if entry != nil {
+			update = newJetStreamEntry(entry)
+			if initial && update.Deleted {
+				continue
+			}
+		} else {
+			initial = false
+		}
+		select {
+		case w.updates <- update:
+		case <-w.stop:
+			stopped = true
+		}
+	}
+}
+
+// Updates returns the channel changes are delivered on
+func (w *jetStreamWatcher) Updates() <-chan *Entry {
+	return w.updates
+}
+
+// Stop stops delivering changes
+func (w *jetStreamWatcher) Stop() error {
+	w.once.Do(func() { close(w.stop) })
+	return w.watcher.Stop()
+}
+
+// newJetStreamEntry converts a KeyValue entry
+func newJetStreamEntry(entry nats.KeyValueEntry) *Entry {
+	op := entry.Operation()
+	return &Entry{
+		Key:      entry.Key(),
+		Value:    entry.Value(),
+		Revision: entry.Revision(),
+		Deleted:  op == nats.KeyValueDelete || op == nats.KeyValuePurge,
+	}
+}
+
+// jetStreamError maps KeyValue errors to the errors of Store
+func jetStreamError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, nats.ErrKeyNotFound):
+		return ErrKeyNotFound
+	case errors.Is(err, nats.ErrKeyExists):
+		return ErrKeyExists
+	default:
+		return err
+	}
+}
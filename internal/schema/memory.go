@@ -0,0 +1,237 @@
+package schema
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore is a Store that keeps its bucket in memory. It behaves like a
+// JetStream bucket, revisions and watches included, but its content is lost
+// when the process exits.
+type MemoryStore struct {
+	name     string
+	mu       sync.RWMutex
+	entries  map[string]*Entry // key -> current value
+	revision uint64            // revision of the latest change
+	watchers map[*memoryWatcher]struct{}
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore(name string) *MemoryStore {
+	return &MemoryStore{
+		name:     name,
+		entries:  make(map[string]*Entry),
+		watchers: make(map[*memoryWatcher]struct{}),
+	}
+}
+
+// Name returns the name of the bucket
+func (s *MemoryStore) Name() string {
+	return s.name
+}
+
+// Get returns the current value of a key
+func (s *MemoryStore) Get(key string) (*Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return entry, nil
+}
+
+// Put writes the value of a key
+func (s *MemoryStore) Put(key string, value []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.apply(key, value, false), nil
+}
+
+// Create writes the value of a key that does not exist yet
+func (s *MemoryStore) Create(key string, value []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[key]; ok {
+		return 0, ErrKeyExists
+	}
+	return s.apply(key, value, false), nil
+}
+
+// Update writes the value of a key whose current value has the given revision
+func (s *MemoryStore) Update(key string, value []byte, revision uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; !ok || entry.Revision != revision {
+		return 0, ErrKeyExists
+	}
+	return s.apply(key, value, false), nil
+}
+
+// Delete removes a key, only if its current value has the given revision
+// unless revision is 0
+func (s *MemoryStore) Delete(key string, revision uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if revision > 0 && (!ok || entry.Revision != revision) {
+		return ErrKeyExists
+	}
+	s.apply(key, nil, true)
+	return nil
+}
+
+// List returns the keys that start with prefix, in order
+func (s *MemoryStore) List(prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	for key := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Watch streams the current value of every key, then a nil entry, then every change
+func (s *MemoryStore) Watch() (Watcher, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := &memoryWatcher{
+		store:   s,
+		notify:  make(chan struct{}, 1),
+		updates: make(chan *Entry),
+		stop:    make(chan struct{}),
+	}
+
+	// Current values are delivered in the order they were written
+	current := make([]*Entry, 0, len(s.entries)+1)
+	for _, entry := range s.entries {
+		current = append(current, entry)
+	}
+	sort.Slice(current, func(i, j int) bool {
+		return current[i].Revision < current[j].Revision
+	})
+	w.push(append(current, nil)...)
+
+	s.watchers[w] = struct{}{}
+	go w.run()
+	return w, nil
+}
+
+// Revision returns the revision of the latest change to the bucket
+func (s *MemoryStore) Revision() (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.revision, nil
+}
+
+// Stats returns the size of the bucket. Only current values are kept.
+func (s *MemoryStore) Stats() (*StoreStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := &StoreStats{Backend: "memory", Values: uint64(len(s.entries)), History: 1}
+	for _, entry := range s.entries {
+		stats.Bytes += uint64(len(entry.Key) + len(entry.Value))
+	}
+	return stats, nil
+}
+
+// apply records a change under the next revision and hands it to the
+// watchers. Must be called with mu held.
+func (s *MemoryStore) apply(key string, value []byte, deleted bool) uint64 {
+	s.revision++
+	entry := &Entry{Key: key, Revision: s.revision, Deleted: deleted}
+	if deleted {
+		delete(s.entries, key)
+	} else {
+		entry.Value = append([]byte(nil), value...)
+		s.entries[key] = entry
+	}
+
+	for w := range s.watchers {
+		w.push(entry)
+	}
+	return s.revision
+}
+
+// memoryWatcher delivers the changes of a MemoryStore. Changes are queued so
+// that writes never wait for a slow reader.
+type memoryWatcher struct {
+	store   *MemoryStore
+	mu      sync.Mutex
+	pending []*Entry
+	notify  chan struct{} // signalled when changes are queued
+	updates chan *Entry
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// push queues changes for delivery
+func (w *memoryWatcher) push(entries ...*Entry) {
+	w.mu.Lock()
+	w.pending = append(w.pending, entries...)
+	w.mu.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers queued changes until the watcher is stopped
+func (w *memoryWatcher) run() {
+	defer close(w.updates)
+
+	for {
+		w.mu.Lock()
+		batch := w.pending
+		w.pending = nil
+		w.mu.Unlock()
+
+		for _, entry := range batch {
+			select {
+			case w.updates <- entry:
+			case <-w.stop:
+				return
+			}
+		}
+		if len(batch) > 0 {
+			continue
+		}
+
+		select {
+		case <-w.notify:
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Updates returns the channel changes are delivered on
+func (w *memoryWatcher) Updates() <-chan *Entry {
+	return w.updates
+}
+
+// Stop stops delivering changes
+func (w *memoryWatcher) Stop() error {
+	w.once.Do(func() {
+		w.store.mu.Lock()
+		delete(w.store.watchers, w)
+		w.store.mu.Unlock()
+		close(w.stop)
+	})
+	return nil
+}
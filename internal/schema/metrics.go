@@ -0,0 +1,194 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"schemaregistry/internal/schema/types"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outcomes of RegisterSchema
+const (
+	registerNewID        = "new_id"       // the schema was stored under a new ID
+	registerReusedID     = "reused_id"    // the schema already had an ID
+	registerIncompatible = "incompatible" // the schema breaks the compatibility level
+	registerInvalid      = "invalid"      // the schema or its references are invalid
+	registerError        = "error"        // any other failure
+)
+
+var (
+	registrations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "schemaregistry",
+		Name:      "register_schema_total",
+		Help:      "Schema registrations by outcome.",
+	}, []string{"outcome"})
+
+	compatibilityChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "schemaregistry",
+		Name:      "compatibility_checks_total",
+		Help:      "Compatibility checks against a stored version by schema format, compatibility level and result.",
+	}, []string{"format", "level", "result"})
+
+	storeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "schemaregistry",
+		Name:      "kv_operation_duration_seconds",
+		Help:      "Latency of key-value store operations by bucket and operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms to 4s
+	}, []string{"bucket", "operation"})
+
+	storeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "schemaregistry",
+		Name:      "kv_operation_errors_total",
+		Help:      "Failed key-value store operations by bucket and operation.",
+	}, []string{"bucket", "operation"})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "schemaregistry",
+		Name:      "cache_lookups_total",
+		Help:      "Cache lookups by cache and result, hit or miss.",
+	}, []string{"cache", "result"})
+
+	subjectsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "schemaregistry",
+		Name:      "subjects",
+		Help:      "Number of subjects in the cache, soft-deleted ones included.",
+	})
+
+	schemasGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "schemaregistry",
+		Name:      "schemas",
+		Help:      "Number of schema IDs in the cache, across contexts.",
+	})
+)
+
+// registerOutcome classifies the result of a registration
+func registerOutcome(createdID int, err error) string {
+	switch {
+	case err == nil && createdID > 0:
+		return registerNewID
+	case err == nil:
+		return registerReusedID
+	case errors.Is(err, ErrIncompatible):
+		return registerIncompatible
+	case errors.Is(err, ErrInvalidSchema), errors.Is(err, ErrReferenceNotFound):
+		return registerInvalid
+	default:
+		return registerError
+	}
+}
+
+// recordCompatibilityCheck counts a check of a schema against a stored version
+func recordCompatibilityCheck(schemaType types.SchemaType, level types.CompatibilityLevel, violations []types.Violation, err error) {
+	result := "compatible"
+	switch {
+	case err != nil:
+		result = "error"
+	case len(violations) > 0:
+		result = "incompatible"
+	}
+	compatibilityChecks.WithLabelValues(string(schemaType), string(level), result).Inc()
+}
+
+// instrumentedStore records a span, the latency and the errors of the
+// operations of a Store. Missing keys and lost races are expected outcomes,
+// not errors.
+type instrumentedStore struct {
+	Store
+	ctx context.Context // context of the operations
+}
+
+// schemaStore returns the schemas bucket, instrumented for operations made on behalf of ctx
+func (r *Registry) schemaStore(ctx context.Context) Store {
+	return instrumentedStore{Store: r.kvSchemas, ctx: ctx}
+}
+
+// configStore returns the config bucket, instrumented for operations made on behalf of ctx
+func (r *Registry) configStore(ctx context.Context) Store {
+	return instrumentedStore{Store: r.kvConfig, ctx: ctx}
+}
+
+// start starts recording an operation on a key and returns the function that
+// completes the record with the operation's error
+func (s instrumentedStore) start(operation, key string) func(err error) {
+	bucket := s.Store.Name()
+	_, span := startSpan(s.ctx, "KV."+operation, attrBucket.String(bucket), attrKey.String(key))
+	start := time.Now()
+
+	return func(err error) {
+		storeDuration.WithLabelValues(bucket, operation).Observe(time.Since(start).Seconds())
+		if err != nil && !errors.Is(err, ErrKeyNotFound) && !errors.Is(err, ErrKeyExists) {
+			storeErrors.WithLabelValues(bucket, operation).Inc()
+			endSpan(span, err)
+			return
+		}
+		span.End()
+	}
+}
+
+// Get records Store.Get
+func (s instrumentedStore) Get(key string) (*Entry, error) {
+	done := s.start("get", key)
+	entry, err := s.Store.Get(key)
+	done(err)
+	return entry, err
+}
+
+// Put records Store.Put
+func (s instrumentedStore) Put(key string, value []byte) (uint64, error) {
+	done := s.start("put", key)
+	revision, err := s.Store.Put(key, value)
+	done(err)
+	return revision, err
+}
+
+// Create records Store.Create
+func (s instrumentedStore) Create(key string, value []byte) (uint64, error) {
+	done := s.start("create", key)
+	revision, err := s.Store.Create(key, value)
+	done(err)
+	return revision, err
+}
+
+// Update records Store.Update
+func (s instrumentedStore) Update(key string, value []byte, revision uint64) (uint64, error) {
+	done := s.start("update", key)
+	revision, err := s.Store.Update(key, value, revision)
+	done(err)
+	return revision, err
+}
+
+// Delete records Store.Delete
+func (s instrumentedStore) Delete(key string, revision uint64) error {
+	done := s.start("delete", key)
+	err := s.Store.Delete(key, revision)
+	done(err)
+	return err
+}
+
+// List records Store.List
+func (s instrumentedStore) List(prefix string) ([]string, error) {
+	done := s.start("list", prefix)
+	keys, err := s.Store.List(prefix)
+	done(err)
+	return keys, err
+}
+
+// Revision records Store.Revision
+func (s instrumentedStore) Revision() (uint64, error) {
+	done := s.start("revision", "")
+	revision, err := s.Store.Revision()
+	done(err)
+	return revision, err
+}
+
+// Stats records Store.Stats
+func (s instrumentedStore) Stats() (*StoreStats, error) {
+	done := s.start("stats", "")
+	stats, err := s.Store.Stats()
+	done(err)
+	return stats, err
+}
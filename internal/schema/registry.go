@@ -3,80 +3,168 @@ package schema
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"schemaregistry/internal/schema/formats/avro"
 	jsonformat "schemaregistry/internal/schema/formats/json"
 	"schemaregistry/internal/schema/formats/protobuf"
 	"schemaregistry/internal/schema/types"
-
-	"github.com/nats-io/nats.go"
 )
 
 const (
 	MagicByte = 0x0
 
-	// Key prefixes for NATS KeyValue store
-	keyPrefixSubjects      = "subjects/"        // subjects/{subject}/versions/{version}
-	keyPrefixSchemas       = "schemas/"         // schemas/{id}
-	keyPrefixGlobalConfig  = "config/global"    // global config
-	keyPrefixSubjectConfig = "config/subjects/" // config/subjects/{subject}
+	// Key prefixes in the stores
+	keyPrefixSubjects         = "subjects/"           // subjects/{subject}/versions/{version}
+	keyPrefixSchemas          = "schemas/"            // schemas/{id}
+	keyPrefixGlobalConfig     = "config/global"       // global config
+	keyPrefixSubjectConfig    = "config/subjects/"    // config/subjects/{subject}
+	keyPrefixGlobalMode       = "mode/global"         // global mode
+	keyPrefixSubjectMode      = "mode/subjects/"      // mode/subjects/{subject}
+	keyPrefixGlobalNormalize  = "normalize/global"    // global normalize setting
+	keyPrefixSubjectNormalize = "normalize/subjects/" // normalize/subjects/{subject}
+
+	// Counter key holding the last allocated schema ID
+	keySchemaIDCounter = "counters/schema-id"
+
+	// Maximum number of compare-and-set attempts before giving up on a write
+	// that keeps conflicting with other registry instances
+	maxWriteAttempts = 50
 
 	// Default compatibility level
 	defaultCompatibilityLevel = types.Backward
+
+	// Default mode
+	defaultMode = types.ReadWrite
 )
 
-// WireFormat represents the serialized format of a message
-type WireFormat struct {
-	MagicByte byte
-	SchemaID  int32
-	Data      []byte
+// CompatibilityError is returned when a schema breaks the compatibility level of a subject
+type CompatibilityError struct {
+	Subject    string
+	Violations []types.Violation
+}
+
+// Unwrap makes CompatibilityError match ErrIncompatible
+func (e *CompatibilityError) Unwrap() error {
+	return ErrIncompatible
 }
 
-// cacheEntry represents a cached schema with its metadata
-type cacheEntry struct {
-	schema *types.Schema
-	mu     sync.RWMutex
+func (e *CompatibilityError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.String())
+	}
+	return fmt.Sprintf("incompatible schema for subject %s: %s", e.Subject, strings.Join(messages, "; "))
+}
+
+var (
+	// ErrIncompatible is returned when a schema breaks the compatibility level of a subject
+	ErrIncompatible = errors.New("incompatible schema")
+	// ErrInvalidSchema is returned for schemas that cannot be parsed or have an unsupported type
+	ErrInvalidSchema = errors.New("invalid schema")
+	// ErrInvalidVersion is returned for version numbers that are malformed or out of range
+	ErrInvalidVersion = errors.New("invalid version")
+	// ErrInvalidCompatibilityLevel is returned when an unknown compatibility level is requested
+	ErrInvalidCompatibilityLevel = errors.New("invalid compatibility level")
+	// ErrSubjectNotFound is returned when a subject has no versions
+	ErrSubjectNotFound = errors.New("subject not found")
+	// ErrVersionNotFound is returned when a subject exists but the requested version does not
+	ErrVersionNotFound = errors.New("version not found")
+	// ErrSchemaNotFound is returned when no schema matches an ID or content
+	ErrSchemaNotFound = errors.New("schema not found")
+	// ErrReferenceNotFound is returned when a schema reference cannot be resolved
+	ErrReferenceNotFound = errors.New("reference not found")
+	// ErrInvalidMode is returned when an unknown mode is requested
+	ErrInvalidMode = errors.New("invalid mode")
+	// ErrModeNotFound is returned when no mode is set for a subject
+	ErrModeNotFound = errors.New("mode not found")
+	// ErrOperationNotPermitted is returned when the effective mode forbids an operation
+	ErrOperationNotPermitted = errors.New("operation not permitted")
+	// ErrSubjectSoftDeleted is returned when soft deleting a subject that is already soft-deleted
+	ErrSubjectSoftDeleted = errors.New("subject was soft deleted, set permanent=true to delete permanently")
+	// ErrSubjectNotSoftDeleted is returned when permanently deleting a subject that was not soft-deleted first
+	ErrSubjectNotSoftDeleted = errors.New("subject must be soft deleted first")
+	// ErrVersionSoftDeleted is returned when soft deleting a version that is already soft-deleted
+	ErrVersionSoftDeleted = errors.New("version was soft deleted, set permanent=true to delete permanently")
+	// ErrVersionNotSoftDeleted is returned when permanently deleting a version that was not soft-deleted first
+	ErrVersionNotSoftDeleted = errors.New("version must be soft deleted first")
+	// ErrReferenceExists is returned when deleting a version that other schemas still reference
+	ErrReferenceExists = errors.New("one or more references exist to the schema")
+	// ErrInvalidACL is returned for access control lists with malformed grants
+	ErrInvalidACL = errors.New("invalid access control list")
+	// ErrStorageUnavailable is returned for writes while the storage cannot be reached
+	ErrStorageUnavailable = errors.New("storage unavailable, registry is read-only")
+)
+
+// WireFormat represents the serialized format of a message
+type WireFormat struct {
+	MagicByte      byte
+	SchemaID       int32
+	MessageIndexes []int // Location of the message in a Protobuf schema
+	Data           []byte
 }
 
 // Registry manages schema registration and compatibility checking
 type Registry struct {
 	formats   map[types.SchemaType]types.SchemaFormat
-	kvSchemas nats.KeyValue
-	kvConfig  nats.KeyValue
+	kvSchemas Store
+	kvConfig  Store
 	mu        sync.RWMutex
 
-	// Cache layer
-	schemaCache  map[int]*cacheEntry    // schema ID -> schema
-	subjectCache map[string][]int       // subject -> version list
-	versionCache map[string]map[int]int // subject -> version -> schema ID
-	configCache  map[string][]byte      // subject -> compatibility level
-	watchSub     *nats.Subscription     // NATS subscription for updates
-	stopWatch    chan struct{}          // Channel to stop watching
-	ready        chan struct{}          // Channel to signal when ready
+	// Cache layer, guarded by cacheMu
+	cacheMu        sync.RWMutex
+	schemaCache    map[string]*cacheEntry         // schema key -> schema
+	subjectCache   map[string][]int               // subject -> version list, including soft-deleted versions
+	versionCache   map[string]map[int]*cacheEntry // subject -> version -> subject version
+	configCache    map[string][]byte              // config key -> value
+	acl            *ACL                           // parsed access control list, nil if none is stored
+	cacheRevisions map[string]uint64              // key -> revision of the last applied change
+	schemasView    bucketView                     // progress of the schemas watcher
+	configView     bucketView                     // progress of the config watcher
+	cacheApplied   chan struct{}                  // closed whenever the watcher applies changes
+	cacheCounters  map[string]*cacheCounter       // cache name -> hit and miss counts
+	stopWatch      chan struct{}                  // Channel to stop watching
+	stopOnce       sync.Once                      // Guards closing stopWatch
+	ready          chan struct{}                  // Channel to signal when ready
+	degraded       atomic.Bool                    // Storage unreachable, only reads are served
 }
 
-// New creates a new schema registry
-func New(kvSchemas, kvConfig nats.KeyValue) *Registry {
+// New creates a new schema registry keeping schemas and config in the given stores
+func New(kvSchemas, kvConfig Store) *Registry {
 	r := &Registry{
 		formats: map[types.SchemaType]types.SchemaFormat{
 			types.JSON:     jsonformat.New(),
 			types.Avro:     avro.New(),
 			types.Protobuf: protobuf.New(),
 		},
-		kvSchemas:    kvSchemas,
-		kvConfig:     kvConfig,
-		schemaCache:  make(map[int]*cacheEntry),
-		subjectCache: make(map[string][]int),
-		versionCache: make(map[string]map[int]int),
-		configCache:  make(map[string][]byte),
-		stopWatch:    make(chan struct{}),
-		ready:        make(chan struct{}),
+		kvSchemas:      kvSchemas,
+		kvConfig:       kvConfig,
+		schemaCache:    make(map[string]*cacheEntry),
+		subjectCache:   make(map[string][]int),
+		versionCache:   make(map[string]map[int]*cacheEntry),
+		configCache:    make(map[string][]byte),
+		cacheRevisions: make(map[string]uint64),
+		cacheApplied:   make(chan struct{}),
+		cacheCounters: map[string]*cacheCounter{
+			cacheSchemas:  newCacheCounter(cacheSchemas),
+			cacheVersions: newCacheCounter(cacheVersions),
+			cacheSubjects: newCacheCounter(cacheSubjects),
+			cacheConfig:   newCacheCounter(cacheConfig),
+		},
+		stopWatch: make(chan struct{}),
+		ready:     make(chan struct{}),
+	}
+
+	// Index records written before the indexes existed
+	if err := r.buildIndexes(context.Background()); err != nil {
+		slog.Error("Failed to build schema indexes", "error", err)
 	}
 
 	// Start watching for updates
@@ -85,6 +173,33 @@ func New(kvSchemas, kvConfig nats.KeyValue) *Registry {
 	return r
 }
 
+// Close stops watching the stores
+func (r *Registry) Close() {
+	r.stopOnce.Do(func() { close(r.stopWatch) })
+}
+
+// SetDegraded marks the storage as unreachable, which makes the registry
+// read-only until it is marked reachable again. Reads are served from the
+// cache meanwhile.
+func (r *Registry) SetDegraded(degraded bool) {
+	if r.degraded.Swap(degraded) != degraded {
+		slog.Warn("Storage availability changed", "degraded", degraded)
+	}
+}
+
+// Degraded reports whether the registry is read-only because its storage is unreachable
+func (r *Registry) Degraded() bool {
+	return r.degraded.Load()
+}
+
+// checkAvailable returns ErrStorageUnavailable while the registry is degraded
+func (r *Registry) checkAvailable() error {
+	if r.degraded.Load() {
+		return ErrStorageUnavailable
+	}
+	return nil
+}
+
 // WaitReady waits for the registry to be ready
 func (r *Registry) WaitReady(ctx context.Context) error {
 	select {
@@ -95,326 +210,439 @@ func (r *Registry) WaitReady(ctx context.Context) error {
 	}
 }
 
-// watchUpdates watches for changes in the NATS KeyValue store
-func (r *Registry) watchUpdates() {
-	// Watch for schema updates
-	schemaWatcher, err := r.kvSchemas.WatchAll()
-	if err != nil {
-		slog.Error("Failed to watch schema updates", "error", err)
-		return
-	}
-	defer schemaWatcher.Stop()
+// RegisterSchema registers a new schema under a subject. The schema is
+// normalized first if normalize is set or the subject is configured to
+// normalize schemas.
+func (r *Registry) RegisterSchema(ctx context.Context, subject string, schemaStr string, schemaType types.SchemaType, references []types.SchemaReference, normalize bool) (_ int, err error) {
+	ctx, span := startSpan(ctx, "Registry.RegisterSchema", attrSubject.String(subject), attrSchemaType.String(string(schemaType)))
+	defer func() { endSpan(span, err) }()
+
+	var createdID int
+	id, err := r.registerSchema(ctx, subject, schemaStr, schemaType, references, normalize, &createdID)
+	registrations.WithLabelValues(registerOutcome(createdID, err)).Inc()
+	return id, err
+}
 
-	// Watch for config updates
-	configWatcher, err := r.kvConfig.WatchAll()
+// registerSchema registers a schema for RegisterSchema. A schema ID allocated
+// along the way is reported through createdID.
+func (r *Registry) registerSchema(ctx context.Context, subject string, schemaStr string, schemaType types.SchemaType, references []types.SchemaReference, normalize bool, createdID *int) (int, error) {
+	// Check that the subject accepts writes
+	mode, err := r.checkWritable(ctx, subject)
 	if err != nil {
-		slog.Error("Failed to watch config updates", "error", err)
-		return
+		return 0, err
 	}
-	defer configWatcher.Stop()
-
-	// Signal that we're ready to process updates
-	close(r.ready)
-
-	for {
-		select {
-		case <-r.stopWatch:
-			return
-		case update := <-schemaWatcher.Updates():
-			if update == nil {
-				continue
-			}
-			r.handleSchemaUpdate(update)
-		case update := <-configWatcher.Updates():
-			if update == nil {
-				continue
-			}
-			r.handleConfigUpdate(update)
-		}
+	if mode == types.Import {
+		return 0, fmt.Errorf("%w: subject %s is in %s mode, schema ID must be specified", ErrOperationNotPermitted, subject, mode)
 	}
-}
 
-// handleSchemaUpdate processes schema updates from NATS
-func (r *Registry) handleSchemaUpdate(update nats.KeyValueEntry) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	// Validate schema format
+	format, ok := r.formats[schemaType]
+	if !ok {
+		return 0, fmt.Errorf("%w: unsupported schema type %s", ErrInvalidSchema, schemaType)
+	}
 
-	key := update.Key()
-	value := update.Value()
+	// Resolve references and validate the schema against them
+	refs, err := r.resolveReferences(ctx, ContextOf(subject), schemaType, references)
+	if err != nil {
+		return 0, err
+	}
+	if err := validateSchema(ctx, schemaType, format, schemaStr, refs); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	if schemaStr, err = r.normalizeSchema(ctx, subject, format, schemaStr, refs, normalize); err != nil {
+		return 0, err
+	}
 
-	// Handle schema deletion
-	if update.Operation() == nats.KeyValueDelete {
-		if strings.HasPrefix(key, keyPrefixSchemas) {
-			// Remove from schema cache
-			idStr := strings.TrimPrefix(key, keyPrefixSchemas)
-			id, err := strconv.Atoi(idStr)
-			if err == nil {
-				delete(r.schemaCache, id)
-			}
-		} else if strings.HasPrefix(key, keyPrefixSubjects) {
-			// Remove from subject and version caches
-			parts := strings.Split(key, "/")
-			if len(parts) >= 4 {
-				subject := parts[1]
-				versionStr := parts[3]
-				version, err := strconv.Atoi(versionStr)
-				if err == nil {
-					if versions, ok := r.versionCache[subject]; ok {
-						delete(versions, version)
-					}
-					// Update subject cache
-					if versions, ok := r.subjectCache[subject]; ok {
-						for i, v := range versions {
-							if v == version {
-								r.subjectCache[subject] = append(versions[:i], versions[i+1:]...)
-								break
-							}
-						}
-					}
-				}
-			}
+	// Versions are claimed with a create-if-absent write so that concurrent
+	// registrations, possibly from other registry instances, never share a
+	// version. The loser of a race re-checks compatibility against the new
+	// latest version and tries the next one.
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		id, err := r.registerVersion(ctx, subject, schemaStr, schemaType, references, refs, format, createdID)
+		if err == nil {
+			// Make the new version visible to reads through this registry
+			r.syncCache(r.schemaStore(ctx), &r.schemasView)
+			return id, nil
 		}
-		return
-	}
-
-	// Handle schema updates
-	if strings.HasPrefix(key, keyPrefixSchemas) {
-		var schema types.Schema
-		if err := json.Unmarshal(value, &schema); err != nil {
-			slog.Error("Failed to unmarshal schema update", "error", err)
-			return
-		}
-		r.schemaCache[schema.ID] = &cacheEntry{schema: &schema}
-	} else if strings.HasPrefix(key, keyPrefixSubjects) {
-		var schema types.Schema
-		if err := json.Unmarshal(value, &schema); err != nil {
-			slog.Error("Failed to unmarshal subject update", "error", err)
-			return
-		}
-		// Update version cache
-		if _, ok := r.versionCache[schema.Subject]; !ok {
-			r.versionCache[schema.Subject] = make(map[int]int)
-		}
-		r.versionCache[schema.Subject][schema.Version] = schema.ID
-		// Update subject cache
-		versions := r.subjectCache[schema.Subject]
-		found := false
-		for _, v := range versions {
-			if v == schema.Version {
-				found = true
-				break
+		if !errors.Is(err, ErrKeyExists) {
+			// Errors only happen before the version is stored, so a schema
+			// created along the way is not used by any version yet
+			if *createdID > 0 {
+				// Don't leave behind a schema no version refers to
+				if err := r.purgeUnusedSchema(ctx, ContextOf(subject), *createdID); err != nil {
+					slog.Warn("Failed to purge unused schema", "id", *createdID, "error", err)
+				}
 			}
+			return 0, err
 		}
-		if !found {
-			r.subjectCache[schema.Subject] = append(versions, schema.Version)
-			sort.Ints(r.subjectCache[schema.Subject])
-		}
-	}
-}
-
-// handleConfigUpdate processes config updates from NATS
-func (r *Registry) handleConfigUpdate(update nats.KeyValueEntry) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	key := update.Key()
-	value := update.Value()
-
-	// Handle config deletion
-	if update.Operation() == nats.KeyValueDelete {
-		if key == keyPrefixGlobalConfig {
-			delete(r.configCache, "global")
-		} else if strings.HasPrefix(key, keyPrefixSubjectConfig) {
-			subject := strings.TrimPrefix(key, keyPrefixSubjectConfig)
-			delete(r.configCache, subject)
-		}
-		return
+		slog.Debug("Version already taken, retrying", "subject", subject, "attempt", attempt+1)
 	}
 
-	// Handle config updates
-	if key == keyPrefixGlobalConfig {
-		r.configCache["global"] = value
-	} else if strings.HasPrefix(key, keyPrefixSubjectConfig) {
-		subject := strings.TrimPrefix(key, keyPrefixSubjectConfig)
-		r.configCache[subject] = value
-	}
+	return 0, fmt.Errorf("register schema: too many concurrent updates to subject %s", subject)
 }
 
-// RegisterSchema registers a new schema under a subject
-func (r *Registry) RegisterSchema(subject string, schemaStr string, schemaType types.SchemaType, references []types.SchemaReference) (int, error) {
-	// Validate schema format
-	format, ok := r.formats[schemaType]
-	if !ok {
-		return 0, fmt.Errorf("unsupported schema type: %s", schemaType)
-	}
-
-	// Validate the schema
-	if err := format.Validate(schemaStr); err != nil {
-		return 0, fmt.Errorf("validate schema: %w", err)
+// registerVersion makes a single attempt at adding a schema as the next version
+// of a subject. It returns an error matching ErrKeyExists if another writer
+// claimed that version first. A schema ID allocated along the way is reported
+// through createdID so that retries reuse it.
+func (r *Registry) registerVersion(ctx context.Context, subject string, schemaStr string, schemaType types.SchemaType, references []types.SchemaReference, refs []types.ResolvedReference, format types.SchemaFormat, createdID *int) (int, error) {
+	idx, err := r.getSubjectIndex(ctx, subject)
+	if err != nil {
+		return 0, err
 	}
 
-	// Validate references
-	for _, ref := range references {
-		// Check if referenced schema exists
-		refSchema, err := r.getSchemaByVersion(ref.Subject, ref.Version)
-		if err != nil {
-			return 0, fmt.Errorf("referenced schema not found: %s version %d", ref.Subject, ref.Version)
-		}
-
-		// Check if referenced schema type matches
-		if refSchema.Type != schemaType {
-			return 0, fmt.Errorf("referenced schema type mismatch: expected %s, got %s", schemaType, refSchema.Type)
-		}
+	// Reuse the ID if the schema content already exists in the subject's context
+	hash := schemaHash(schemaStr, schemaType, references)
+	id, err := r.lookupSchemaID(ctx, ContextOf(subject), hash)
+	if err != nil {
+		return 0, err
 	}
 
-	// Check if schema already exists for this subject
-	latestVersion, err := r.getLatestVersion(subject)
-	if err != nil && err.Error() != "no versions found" {
-		return 0, fmt.Errorf("get latest version: %w", err)
+	// Registering a schema that is already a live version of the subject is a no-op
+	if id > 0 && idx.findLiveID(id) != nil {
+		return id, nil
 	}
 
-	if latestVersion > 0 {
-		slog.Debug("Checking compatibility for schema", "subject", subject, "latestVersion", latestVersion)
+	// Soft-deleted versions are not checked for compatibility but still count
+	// towards numbering
+	if liveVersion := idx.latest(false); liveVersion > 0 {
+		slog.Debug("Checking compatibility for schema", "subject", subject, "latestVersion", liveVersion)
 
 		// If schema exists, check compatibility
-		level, err := r.GetCompatibilityLevel(subject)
+		level, err := r.GetCompatibilityLevel(ctx, subject)
 		if err != nil {
 			return 0, fmt.Errorf("get compatibility level: %w", err)
 		}
 		slog.Debug("Compatibility level", "subject", subject, "level", level)
 
-		// Get the latest schema for compatibility check
-		latestSchema, err := r.getSchemaByVersion(subject, latestVersion)
-		if err != nil {
-			return 0, fmt.Errorf("get latest schema: %w", err)
-		}
-
-		// Check if schema content is identical
-		if latestSchema.Schema == schemaStr && latestSchema.Type == schemaType {
-			return latestSchema.ID, nil
+		var liveVersions []int
+		for _, entry := range idx.Versions {
+			if !entry.Deleted {
+				liveVersions = append(liveVersions, entry.Version)
+			}
 		}
 
 		// Check compatibility
-		compatible, err := format.CheckCompatibility(latestSchema.Schema, schemaStr, level)
-		if err != nil || !compatible {
-			return 0, fmt.Errorf("incompatible schema: %w", err)
+		violations, err := r.checkVersions(ctx, subject, liveVersions, schemaType, format, schemaStr, refs, level)
+		if err != nil {
+			return 0, fmt.Errorf("check compatibility: %w", err)
+		}
+		if len(violations) > 0 {
+			return 0, &CompatibilityError{Subject: subject, Violations: violations}
 		}
 	}
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	schema := &types.Schema{
+		Schema:     schemaStr,
+		Subject:    subject,
+		Version:    idx.latest(true) + 1,
+		ID:         id,
+		Type:       schemaType,
+		References: references,
+	}
+	if schema.ID == 0 {
+		created := false
+		if schema.ID, created, err = r.createSchema(ctx, schema, hash); err != nil {
+			return 0, err
+		}
+		if created {
+			*createdID = schema.ID
+		}
+	}
 
-	// Check if schema content already exists globally
-	keys, err := r.kvSchemas.Keys()
-	if err != nil && err != nats.ErrNoKeysFound {
-		return 0, fmt.Errorf("get schema keys: %w", err)
+	// Store schema by subject and version
+	schemaBytes, err := json.Marshal(schema)
+	if err != nil {
+		return 0, fmt.Errorf("marshal schema: %w", err)
 	}
 
-	var existingID int
-	for _, key := range keys {
-		if !strings.HasPrefix(key, keyPrefixSchemas) {
-			continue
+	key := versionKey(subject, schema.Version)
+	if _, err := r.schemaStore(ctx).Create(key, schemaBytes); err != nil {
+		if errors.Is(err, ErrKeyExists) {
+			// The version exists but the index may not know about it yet, for
+			// example if its writer failed before indexing it
+			if existing, getErr := r.getSchemaByVersion(ctx, subject, schema.Version); getErr == nil {
+				if err := r.indexSubjectVersion(ctx, existing); err != nil {
+					slog.Warn("Failed to repair subject index", "subject", subject, "version", schema.Version, "error", err)
+				}
+			}
 		}
+		return 0, fmt.Errorf("store schema by subject/version: %w", err)
+	}
+
+	// The version is registered once stored. The indexes are derived from the
+	// versions and repaired by later writers if they cannot be updated now.
+	if err := r.indexSubjectVersion(ctx, schema); err != nil {
+		slog.Warn("Failed to index schema, leaving the index to be repaired", "subject", subject, "version", schema.Version, "error", err)
+	}
+
+	return schema.ID, nil
+}
 
-		entry, err := r.kvSchemas.Get(key)
+// createSchema allocates a new schema ID in the context of the schema's
+// subject, stores the schema under it and records it in the content index. If
+// another writer registered the same content concurrently, that writer's ID is
+// returned instead and created is false.
+func (r *Registry) createSchema(ctx context.Context, schema *types.Schema, hash string) (id int, created bool, err error) {
+	context := ContextOf(schema.Subject)
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		id, err = r.nextSchemaID(ctx, context)
 		if err != nil {
-			continue
+			return 0, false, fmt.Errorf("get next schema ID: %w", err)
 		}
 
-		var schema types.Schema
-		if err := json.Unmarshal(entry.Value(), &schema); err != nil {
-			continue
+		record := *schema
+		record.ID = id
+		schemaBytes, err := json.Marshal(&record)
+		if err != nil {
+			return 0, false, fmt.Errorf("marshal schema: %w", err)
 		}
 
-		if schema.Schema == schemaStr && schema.Type == schemaType {
-			existingID = schema.ID
+		// The ID may already be taken by an imported schema
+		_, err = r.schemaStore(ctx).Create(schemaKey(context, id), schemaBytes)
+		if err == nil {
 			break
 		}
+		if !errors.Is(err, ErrKeyExists) {
+			return 0, false, fmt.Errorf("store schema by ID: %w", err)
+		}
+		id = 0
+	}
+	if id == 0 {
+		return 0, false, fmt.Errorf("store schema by ID: too many conflicting schema IDs")
 	}
 
-	// If schema exists, reuse its ID
-	if existingID > 0 {
-		// Create new version for this subject
-		newVersion := latestVersion + 1
-		schema := &types.Schema{
-			Schema:     schemaStr,
-			Subject:    subject,
-			Version:    newVersion,
-			ID:         existingID,
-			Type:       schemaType,
-			References: references,
-		}
+	_, err = r.schemaStore(ctx).Create(hashIndexKey(context, hash), []byte(strconv.Itoa(id)))
+	if err == nil {
+		return id, true, nil
+	}
+	if !errors.Is(err, ErrKeyExists) {
+		return 0, false, fmt.Errorf("index schema: %w", err)
+	}
 
-		// Store schema by subject and version
-		schemaBytes, err := json.Marshal(schema)
-		if err != nil {
-			return 0, fmt.Errorf("marshal schema: %w", err)
-		}
+	// Lost the race for this content, use the winner's ID and drop ours
+	existingID, err := r.lookupSchemaID(ctx, context, hash)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := r.schemaStore(ctx).Delete(schemaKey(context, id), 0); err != nil {
+		slog.Warn("Failed to delete duplicate schema", "id", id, "error", err)
+	}
+	return existingID, false, nil
+}
 
-		if _, err := r.kvSchemas.Put(
-			fmt.Sprintf("%s%s/versions/%d", keyPrefixSubjects, subject, newVersion),
-			schemaBytes,
-		); err != nil {
-			return 0, fmt.Errorf("store schema by subject/version: %w", err)
-		}
+// ImportSchema registers a schema under a subject with an explicit ID and version.
+// It is only allowed while the subject is in IMPORT mode and skips compatibility checks.
+// A version of 0 assigns the next version of the subject. The schema is
+// normalized like in RegisterSchema.
+func (r *Registry) ImportSchema(ctx context.Context, subject string, schemaStr string, schemaType types.SchemaType, references []types.SchemaReference, id int, version int, normalize bool) (_ int, err error) {
+	ctx, span := startSpan(ctx, "Registry.ImportSchema", attrSubject.String(subject), attrSchemaType.String(string(schemaType)), attrSchemaID.Int(id), attrVersion.Int(version))
+	defer func() { endSpan(span, err) }()
 
-		return existingID, nil
+	mode, err := r.checkWritable(ctx, subject)
+	if err != nil {
+		return 0, err
+	}
+	if mode != types.Import {
+		return 0, fmt.Errorf("%w: subject %s is not in %s mode", ErrOperationNotPermitted, subject, types.Import)
+	}
+	if id <= 0 {
+		return 0, fmt.Errorf("invalid schema ID: %d", id)
+	}
+	if version < 0 {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidVersion, version)
 	}
 
-	// Get the next schema ID for new schema
-	nextID, err := r.getNextSchemaID()
+	format, ok := r.formats[schemaType]
+	if !ok {
+		return 0, fmt.Errorf("%w: unsupported schema type %s", ErrInvalidSchema, schemaType)
+	}
+	refs, err := r.resolveReferences(ctx, ContextOf(subject), schemaType, references)
 	if err != nil {
-		return 0, fmt.Errorf("get next schema ID: %w", err)
+		return 0, err
+	}
+	if err := validateSchema(ctx, schemaType, format, schemaStr, refs); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	if schemaStr, err = r.normalizeSchema(ctx, subject, format, schemaStr, refs, normalize); err != nil {
+		return 0, err
+	}
+
+	// Keep allocated IDs clear of the imported one
+	context := ContextOf(subject)
+	if err := r.reserveSchemaID(ctx, context, id); err != nil {
+		return 0, err
+	}
+
+	if version == 0 {
+		latestVersion, err := r.getLatestVersion(ctx, subject)
+		if err != nil {
+			return 0, fmt.Errorf("get latest version: %w", err)
+		}
+		version = latestVersion + 1
+	}
+
+	// An imported version must either be new or already point at the same ID
+	key := versionKey(subject, version)
+	if entry, err := r.schemaStore(ctx).Get(key); err == nil {
+		var existing types.Schema
+		if err := json.Unmarshal(entry.Value, &existing); err != nil {
+			return 0, fmt.Errorf("unmarshal schema: %w", err)
+		}
+		if existing.ID != id {
+			return 0, fmt.Errorf("%w: version %d of subject %s already exists with schema ID %d", ErrOperationNotPermitted, version, subject, existing.ID)
+		}
+		return id, nil
+	} else if err != ErrKeyNotFound {
+		return 0, fmt.Errorf("get schema by subject/version: %w", err)
 	}
 
-	// Create new schema
-	newVersion := latestVersion + 1
 	schema := &types.Schema{
 		Schema:     schemaStr,
 		Subject:    subject,
-		Version:    newVersion,
-		ID:         nextID,
+		Version:    version,
+		ID:         id,
 		Type:       schemaType,
 		References: references,
 	}
-
-	// Store schema by ID
 	schemaBytes, err := json.Marshal(schema)
 	if err != nil {
 		return 0, fmt.Errorf("marshal schema: %w", err)
 	}
 
-	if _, err := r.kvSchemas.Put(keyPrefixSchemas+strconv.Itoa(nextID), schemaBytes); err != nil {
-		return 0, fmt.Errorf("store schema by ID: %w", err)
+	// An imported ID must either be new or already hold the same schema
+	if _, err := r.schemaStore(ctx).Create(schemaKey(context, id), schemaBytes); err != nil {
+		if !errors.Is(err, ErrKeyExists) {
+			return 0, fmt.Errorf("store schema by ID: %w", err)
+		}
+		existing, err := r.getSchemaRecord(ctx, context, id)
+		if err != nil {
+			return 0, err
+		}
+		if existing.Schema != schemaStr || existing.Type != schemaType {
+			return 0, fmt.Errorf("%w: schema ID %d is already in use by a different schema", ErrOperationNotPermitted, id)
+		}
+	}
+
+	// The same content may have been imported under another ID before, in
+	// which case the first ID stays the one used for deduplication
+	hash := schemaHash(schemaStr, schemaType, references)
+	if _, err := r.schemaStore(ctx).Create(hashIndexKey(context, hash), []byte(strconv.Itoa(id))); err != nil && !errors.Is(err, ErrKeyExists) {
+		return 0, fmt.Errorf("index schema: %w", err)
 	}
 
-	// Store schema by subject and version
-	if _, err := r.kvSchemas.Put(
-		fmt.Sprintf("%s%s/versions/%d", keyPrefixSubjects, subject, newVersion),
-		schemaBytes,
-	); err != nil {
+	if _, err := r.schemaStore(ctx).Create(key, schemaBytes); err != nil {
+		if errors.Is(err, ErrKeyExists) {
+			return 0, fmt.Errorf("%w: version %d of subject %s was created concurrently", ErrOperationNotPermitted, version, subject)
+		}
 		return 0, fmt.Errorf("store schema by subject/version: %w", err)
 	}
 
-	return nextID, nil
+	if err := r.indexSubjectVersion(ctx, schema); err != nil {
+		slog.Warn("Failed to index schema, leaving the index to be repaired", "subject", subject, "version", version, "error", err)
+	}
+
+	r.syncCache(r.schemaStore(ctx), &r.schemasView)
+	return id, nil
+}
+
+// nextSchemaID atomically allocates a new schema ID from the counter key of a context
+func (r *Registry) nextSchemaID(ctx context.Context, context string) (int, error) {
+	counterKey := contextPrefix(context) + keySchemaIDCounter
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		entry, err := r.schemaStore(ctx).Get(counterKey)
+		if err == ErrKeyNotFound {
+			// Seed the counter from the schemas already stored so that buckets
+			// written before the counter existed keep their IDs
+			highestID, err := r.getHighestSchemaID(ctx, context)
+			if err != nil {
+				return 0, err
+			}
+			_, err = r.schemaStore(ctx).Create(counterKey, []byte(strconv.Itoa(highestID+1)))
+			if err == nil {
+				return highestID + 1, nil
+			}
+			if !errors.Is(err, ErrKeyExists) {
+				return 0, err
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		lastID, err := strconv.Atoi(string(entry.Value))
+		if err != nil {
+			return 0, fmt.Errorf("invalid schema ID counter: %w", err)
+		}
+		_, err = r.schemaStore(ctx).Update(counterKey, []byte(strconv.Itoa(lastID+1)), entry.Revision)
+		if err == nil {
+			return lastID + 1, nil
+		}
+		if !errors.Is(err, ErrKeyExists) {
+			return 0, err
+		}
+	}
+
+	return 0, fmt.Errorf("too many concurrent schema ID allocations")
+}
+
+// reserveSchemaID raises the schema ID counter of a context to at least id so
+// that it is never handed out by nextSchemaID
+func (r *Registry) reserveSchemaID(ctx context.Context, context string, id int) error {
+	counterKey := contextPrefix(context) + keySchemaIDCounter
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		entry, err := r.schemaStore(ctx).Get(counterKey)
+		if err == ErrKeyNotFound {
+			highestID, err := r.getHighestSchemaID(ctx, context)
+			if err != nil {
+				return err
+			}
+			_, err = r.schemaStore(ctx).Create(counterKey, []byte(strconv.Itoa(max(highestID, id))))
+			if err == nil {
+				return nil
+			}
+			if !errors.Is(err, ErrKeyExists) {
+				return fmt.Errorf("reserve schema ID: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reserve schema ID: %w", err)
+		}
+
+		lastID, err := strconv.Atoi(string(entry.Value))
+		if err != nil {
+			return fmt.Errorf("invalid schema ID counter: %w", err)
+		}
+		if lastID >= id {
+			return nil
+		}
+		_, err = r.schemaStore(ctx).Update(counterKey, []byte(strconv.Itoa(id)), entry.Revision)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrKeyExists) {
+			return fmt.Errorf("reserve schema ID: %w", err)
+		}
+	}
+
+	return fmt.Errorf("reserve schema ID: too many concurrent schema ID allocations")
 }
 
-// getNextSchemaID gets the next available schema ID
-func (r *Registry) getNextSchemaID() (int, error) {
-	// Get all schemas
-	keys, err := r.kvSchemas.Keys()
-	if err != nil && err != nats.ErrNoKeysFound {
+// getHighestSchemaID gets the highest schema ID currently stored in a context
+func (r *Registry) getHighestSchemaID(ctx context.Context, context string) (int, error) {
+	// Get all schemas of the context
+	prefix := contextPrefix(context) + keyPrefixSchemas
+	keys, err := r.schemaStore(ctx).List(prefix)
+	if err != nil {
 		return 0, err
 	}
 
 	// Find the highest ID
 	highestID := 0
 	for _, key := range keys {
-		if !strings.HasPrefix(key, keyPrefixSchemas) {
-			continue
-		}
-
-		idStr := strings.TrimPrefix(key, keyPrefixSchemas)
+		idStr := strings.TrimPrefix(key, prefix)
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			continue
@@ -425,199 +653,267 @@ func (r *Registry) getNextSchemaID() (int, error) {
 		}
 	}
 
-	// Return next ID
-	return highestID + 1, nil
+	return highestID, nil
+}
+
+// getLatestVersion gets the latest version for a subject, including soft-deleted versions
+func (r *Registry) getLatestVersion(ctx context.Context, subject string) (int, error) {
+	idx, err := r.getSubjectIndex(ctx, subject)
+	if err != nil {
+		return 0, err
+	}
+	return idx.latest(true), nil
 }
 
-// getLatestVersion gets the latest version for a subject
-func (r *Registry) getLatestVersion(subject string) (int, error) {
-	prefix := fmt.Sprintf("%s%s/versions/", keyPrefixSubjects, subject)
-	keys, err := r.kvSchemas.Keys()
-	if err != nil && err != nats.ErrNoKeysFound {
+// getLatestLiveVersion gets the latest version of a subject that is not soft-deleted
+func (r *Registry) getLatestLiveVersion(ctx context.Context, subject string) (int, error) {
+	idx, err := r.getSubjectIndex(ctx, subject)
+	if err != nil {
 		return 0, err
 	}
+	return idx.latest(false), nil
+}
 
-	// Find highest version
-	highestVersion := 0
-	for _, key := range keys {
-		if !strings.HasPrefix(key, prefix) {
-			continue
-		}
+// getSubjectVersions gets the stored records of all versions of a subject,
+// including soft-deleted ones, ordered by version
+func (r *Registry) getSubjectVersions(ctx context.Context, subject string) ([]*types.Schema, error) {
+	idx, err := r.getSubjectIndex(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
 
-		versionStr := strings.TrimPrefix(key, prefix)
-		version, err := strconv.Atoi(versionStr)
+	schemas := make([]*types.Schema, 0, len(idx.Versions))
+	for _, entry := range idx.Versions {
+		schema, err := r.getSchemaByVersion(ctx, subject, entry.Version)
 		if err != nil {
+			// Deleted after reading the index
 			continue
 		}
+		schemas = append(schemas, schema)
+	}
+	return schemas, nil
+}
 
-		if version > highestVersion {
-			highestVersion = version
-		}
+// getSchemaByVersion gets a schema by subject and version
+func (r *Registry) getSchemaByVersion(ctx context.Context, subject string, version int) (*types.Schema, error) {
+	if schema, ok := r.cachedVersion(subject, version); ok {
+		return schema, nil
 	}
 
-	if highestVersion == 0 {
-		return 0, nil // Return 0 for first version
+	key := versionKey(subject, version)
+	entry, err := r.schemaStore(ctx).Get(key)
+	if err == ErrKeyNotFound {
+		return nil, fmt.Errorf("%w: %s version %d", ErrVersionNotFound, subject, version)
 	}
+	if err != nil {
+		return nil, fmt.Errorf("get schema by subject/version: %w", err)
+	}
+
+	var schema types.Schema
+	if err := json.Unmarshal(entry.Value, &schema); err != nil {
+		return nil, fmt.Errorf("unmarshal schema: %w", err)
+	}
+	r.fillSchemaCache(entry)
 
-	return highestVersion, nil
+	return &schema, nil
 }
 
-// getSchemaByVersion gets a schema by subject and version
-func (r *Registry) getSchemaByVersion(subject string, version int) (*types.Schema, error) {
-	key := fmt.Sprintf("%s%s/versions/%d", keyPrefixSubjects, subject, version)
-	entry, err := r.kvSchemas.Get(key)
+// getSchemaRecord gets the schema stored under an ID of a context, bypassing the cache
+func (r *Registry) getSchemaRecord(ctx context.Context, context string, id int) (*types.Schema, error) {
+	entry, err := r.schemaStore(ctx).Get(schemaKey(context, id))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %d", ErrSchemaNotFound, id)
 	}
 
 	var schema types.Schema
-	if err := json.Unmarshal(entry.Value(), &schema); err != nil {
+	if err := json.Unmarshal(entry.Value, &schema); err != nil {
 		return nil, fmt.Errorf("unmarshal schema: %w", err)
 	}
 
 	return &schema, nil
 }
 
-// GetSchema retrieves a schema by ID
-func (r *Registry) GetSchema(id int) (*types.Schema, error) {
+// GetSchema retrieves a schema by ID from the default context. The span is
+// that of GetSchemaInContext.
+func (r *Registry) GetSchema(ctx context.Context, id int) (*types.Schema, error) {
+	return r.GetSchemaInContext(ctx, DefaultContext, id)
+}
+
+// GetSchemaInContext retrieves a schema by its ID within a context
+func (r *Registry) GetSchemaInContext(ctx context.Context, context string, id int) (_ *types.Schema, err error) {
+	ctx, span := startSpan(ctx, "Registry.GetSchemaInContext", attrContext.String(context), attrSchemaID.Int(id))
+	defer func() { endSpan(span, err) }()
+
+	key := schemaKey(context, id)
+
 	// Try cache first
-	if entry, ok := r.schemaCache[id]; ok {
-		entry.mu.RLock()
-		schema := entry.schema
-		entry.mu.RUnlock()
+	if schema, ok := r.cachedSchema(key); ok {
 		return schema, nil
 	}
 
 	// Cache miss, get from store
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	key := keyPrefixSchemas + strconv.Itoa(id)
-	entry, err := r.kvSchemas.Get(key)
+	entry, err := r.schemaStore(ctx).Get(key)
 	if err != nil {
-		return nil, fmt.Errorf("schema not found: %d", id)
+		return nil, fmt.Errorf("%w: %d", ErrSchemaNotFound, id)
 	}
 
 	var schema types.Schema
-	if err := json.Unmarshal(entry.Value(), &schema); err != nil {
+	if err := json.Unmarshal(entry.Value, &schema); err != nil {
 		return nil, fmt.Errorf("unmarshal schema: %w", err)
 	}
-
-	// Update cache
-	r.schemaCache[id] = &cacheEntry{schema: &schema}
+	r.fillSchemaCache(entry)
 
 	return &schema, nil
 }
 
-// GetSchemaBySubjectVersion retrieves a schema by subject and version
-func (r *Registry) GetSchemaBySubjectVersion(subject string, version string) (*types.Schema, error) {
+// GetSchemaBySubjectVersion retrieves a schema by subject and version.
+// Soft-deleted versions are only returned if includeDeleted is set.
+func (r *Registry) GetSchemaBySubjectVersion(ctx context.Context, subject string, version string, includeDeleted bool) (_ *types.Schema, err error) {
+	ctx, span := startSpan(ctx, "Registry.GetSchemaBySubjectVersion", attrSubject.String(subject), attrVersion.String(version))
+	defer func() { endSpan(span, err) }()
+
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	var versionNum int
-	var err error
 
 	// Handle "latest" version
 	if version == "latest" {
-		versionNum, err = r.getLatestVersion(subject)
+		if includeDeleted {
+			versionNum, err = r.getLatestVersion(ctx, subject)
+		} else {
+			versionNum, err = r.getLatestLiveVersion(ctx, subject)
+		}
 		if err != nil {
 			return nil, err
 		}
+		if versionNum == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+		}
 	} else {
-		versionNum, err = strconv.Atoi(version)
+		versionNum, err = parseVersion(version)
 		if err != nil {
-			return nil, fmt.Errorf("invalid version: %s", version)
+			return nil, err
 		}
 	}
 
-	return r.getSchemaByVersion(subject, versionNum)
-}
-
-// GetVersions returns all versions for a subject
-func (r *Registry) GetVersions(subject string) ([]int, error) {
-	// Try cache first
-	if versions, ok := r.subjectCache[subject]; ok {
-		return versions, nil
+	schema, err := r.getSchemaByVersion(ctx, subject, versionNum)
+	if err == nil && schema.Deleted && !includeDeleted {
+		err = fmt.Errorf("%w: %s version %d", ErrVersionNotFound, subject, versionNum)
+	}
+	if errors.Is(err, ErrVersionNotFound) {
+		// Tell a missing subject apart from a missing version
+		if latest, latestErr := r.getLatestVersion(ctx, subject); latestErr == nil && latest == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+		}
 	}
-
-	// Cache miss, get from store
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	slog.Debug("GetVersions: getting versions for subject", "subject", subject)
-	prefix := fmt.Sprintf("%s%s/versions/", keyPrefixSubjects, subject)
-	keys, err := r.kvSchemas.Keys()
 	if err != nil {
 		return nil, err
 	}
+	return schema, nil
+}
 
-	var versions []int
-	for _, key := range keys {
-		if !strings.HasPrefix(key, prefix) {
-			continue
+// parseVersion parses a version number given as a string
+func parseVersion(version string) (int, error) {
+	versionNum, err := strconv.Atoi(version)
+	if err != nil || versionNum <= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidVersion, version)
+	}
+	return versionNum, nil
+}
+
+// GetSubjects returns all subjects with at least one version, those of
+// contexts other than the default one qualified with their context. Subjects
+// whose versions are all soft-deleted are only returned if includeDeleted is set.
+func (r *Registry) GetSubjects(ctx context.Context, includeDeleted bool) (_ []string, err error) {
+	ctx, span := startSpan(ctx, "Registry.GetSubjects")
+	defer func() { endSpan(span, err) }()
+
+	// Try cache first
+	subjects, ok := r.cachedSubjects(includeDeleted)
+	if !ok {
+		// Cache not available, get from store
+		indexed, err := r.indexedSubjects(ctx)
+		if err != nil {
+			return nil, err
 		}
+		for _, subject := range indexed {
+			if !includeDeleted {
+				idx, err := r.getSubjectIndex(ctx, subject)
+				if err != nil || idx.latest(false) == 0 {
+					continue
+				}
+			}
+			subjects = append(subjects, subject)
+		}
+	}
+	if subjects == nil {
+		subjects = make([]string, 0)
+	}
+	sort.Strings(subjects)
 
-		versionStr := strings.TrimPrefix(key, prefix)
-		version, err := strconv.Atoi(versionStr)
+	return subjects, nil
+}
+
+// GetVersions returns all versions for a subject. Soft-deleted versions are
+// only returned if includeDeleted is set.
+func (r *Registry) GetVersions(ctx context.Context, subject string, includeDeleted bool) (_ []int, err error) {
+	ctx, span := startSpan(ctx, "Registry.GetVersions", attrSubject.String(subject))
+	defer func() { endSpan(span, err) }()
+
+	// Try cache first
+	versions, ok := r.cachedVersions(subject, includeDeleted)
+	if !ok {
+		// Cache not available, get from store
+		slog.Debug("GetVersions: getting versions for subject", "subject", subject)
+		idx, err := r.getSubjectIndex(ctx, subject)
 		if err != nil {
-			continue
+			return nil, err
 		}
 
-		versions = append(versions, version)
+		for _, entry := range idx.Versions {
+			if entry.Deleted && !includeDeleted {
+				continue
+			}
+			versions = append(versions, entry.Version)
+		}
 	}
 
 	if len(versions) == 0 {
-		return nil, fmt.Errorf("no versions found")
+		return nil, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
 	}
 
-	// Update cache
-	sort.Ints(versions)
-	r.subjectCache[subject] = versions
-
 	slog.Debug("GetVersions: versions", "versions", versions)
 	return versions, nil
 }
 
-// GetCompatibilityLevel gets the compatibility level for a subject
-func (r *Registry) GetCompatibilityLevel(subject string) (types.CompatibilityLevel, error) {
-	// Try cache first
-	if level, ok := r.configCache[subject]; ok {
-		return types.CompatibilityLevel(level), nil
-	}
-	if level, ok := r.configCache["global"]; ok {
-		return types.CompatibilityLevel(level), nil
-	}
-
-	// Cache miss, get from store
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+// GetCompatibilityLevel gets the compatibility level for a subject, falling
+// back to the level of its context and then to the global level
+func (r *Registry) GetCompatibilityLevel(ctx context.Context, subject string) (_ types.CompatibilityLevel, err error) {
+	ctx, span := startSpan(ctx, "Registry.GetCompatibilityLevel", attrSubject.String(subject))
+	defer func() { endSpan(span, err) }()
 
 	slog.Debug("Getting compatibility level", "subject", subject)
-	// First try subject-specific config
-	if subject != "global" {
-		key := keyPrefixSubjectConfig + subject
-		entry, err := r.kvConfig.Get(key)
-		if err == nil {
-			level := entry.Value()
-			r.configCache[subject] = level
+	for _, key := range configKeys(subject, keyPrefixSubjectConfig, keyPrefixGlobalConfig) {
+		level, found, err := r.getConfigValue(ctx, key)
+		if err != nil {
+			return "", fmt.Errorf("get config %s: %w", key, err)
+		}
+		if found {
 			return types.CompatibilityLevel(level), nil
 		}
 	}
 
-	// Fallback to global config
-	entry, err := r.kvConfig.Get(keyPrefixGlobalConfig)
-	if err != nil {
-		// Use default if global config not found
-		return defaultCompatibilityLevel, nil
-	}
-
-	level := entry.Value()
-	r.configCache["global"] = level
-	return types.CompatibilityLevel(level), nil
+	// Use default if no config is set
+	return defaultCompatibilityLevel, nil
 }
 
-// SetCompatibilityLevel sets the compatibility level for a subject
-func (r *Registry) SetCompatibilityLevel(subject string, level types.CompatibilityLevel) error {
+// SetCompatibilityLevel sets the compatibility level for a subject, a context
+// given as :.{context}: or "global"
+func (r *Registry) SetCompatibilityLevel(ctx context.Context, subject string, level types.CompatibilityLevel) (err error) {
+	ctx, span := startSpan(ctx, "Registry.SetCompatibilityLevel", attrSubject.String(subject), attrLevel.String(string(level)))
+	defer func() { endSpan(span, err) }()
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -626,279 +922,823 @@ func (r *Registry) SetCompatibilityLevel(subject string, level types.Compatibili
 	case types.Backward, types.Forward, types.Full, types.None, types.BackwardTransitive, types.ForwardTransitive, types.FullTransitive:
 		// Valid
 	default:
-		return fmt.Errorf("invalid compatibility level: %s", level)
+		return fmt.Errorf("%w: %s", ErrInvalidCompatibilityLevel, level)
+	}
+	if err := r.checkAvailable(); err != nil {
+		return err
 	}
 
-	var key string
-	if subject == "global" {
-		key = keyPrefixGlobalConfig
-	} else {
-		key = keyPrefixSubjectConfig + subject
+	key := configKeys(subject, keyPrefixSubjectConfig, keyPrefixGlobalConfig)[0]
+	if _, err := r.configStore(ctx).Put(key, []byte(level)); err != nil {
+		return err
+	}
+	r.syncCache(r.configStore(ctx), &r.configView)
+	return nil
+}
+
+// GetNormalize reports whether schemas registered under a subject are
+// normalized, falling back to the setting of its context and then to the
+// global setting
+func (r *Registry) GetNormalize(ctx context.Context, subject string) (_ bool, err error) {
+	ctx, span := startSpan(ctx, "Registry.GetNormalize", attrSubject.String(subject))
+	defer func() { endSpan(span, err) }()
+
+	for _, key := range configKeys(subject, keyPrefixSubjectNormalize, keyPrefixGlobalNormalize) {
+		value, found, err := r.getConfigValue(ctx, key)
+		if err != nil {
+			return false, fmt.Errorf("get normalize %s: %w", key, err)
+		}
+		if found {
+			return string(value) == "true", nil
+		}
+	}
+	return false, nil
+}
+
+// SetNormalize sets whether schemas registered under a subject are normalized
+func (r *Registry) SetNormalize(ctx context.Context, subject string, normalize bool) (err error) {
+	ctx, span := startSpan(ctx, "Registry.SetNormalize", attrSubject.String(subject))
+	defer func() { endSpan(span, err) }()
+
+	if err := r.checkAvailable(); err != nil {
+		return err
 	}
 
-	_, err := r.kvConfig.Put(key, []byte(level))
-	return err
+	key := configKeys(subject, keyPrefixSubjectNormalize, keyPrefixGlobalNormalize)[0]
+	if _, err := r.configStore(ctx).Put(key, []byte(strconv.FormatBool(normalize))); err != nil {
+		return err
+	}
+	r.syncCache(r.configStore(ctx), &r.configView)
+	return nil
 }
 
-// CheckCompatibility checks if a new schema is compatible with an existing schema
-func (r *Registry) CheckCompatibility(subject string, newSchema string, schemaType types.SchemaType, level types.CompatibilityLevel) (bool, error) {
+// normalizeSchema returns the canonical form of a schema if normalization is
+// requested or configured for the subject, and the schema unchanged otherwise
+func (r *Registry) normalizeSchema(ctx context.Context, subject string, format types.SchemaFormat, schemaStr string, refs []types.ResolvedReference, normalize bool) (string, error) {
+	if !normalize {
+		configured, err := r.GetNormalize(ctx, subject)
+		if err != nil {
+			return "", err
+		}
+		if !configured {
+			return schemaStr, nil
+		}
+	}
+
+	normalized, err := format.Normalize(schemaStr, refs)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	return normalized, nil
+}
+
+// GetMode gets the mode for a subject. If no subject mode is set and defaultToGlobal
+// is true, the mode of its context or else the global mode is returned instead,
+// otherwise ErrModeNotFound.
+func (r *Registry) GetMode(ctx context.Context, subject string, defaultToGlobal bool) (_ types.Mode, err error) {
+	ctx, span := startSpan(ctx, "Registry.GetMode", attrSubject.String(subject))
+	defer func() { endSpan(span, err) }()
+
+	for i, key := range configKeys(subject, keyPrefixSubjectMode, keyPrefixGlobalMode) {
+		mode, found, err := r.getConfigValue(ctx, key)
+		if err != nil {
+			return "", fmt.Errorf("get mode %s: %w", key, err)
+		}
+		if found {
+			return types.Mode(mode), nil
+		}
+		if i == 0 && subject != "global" && !defaultToGlobal {
+			return "", fmt.Errorf("%w: %s", ErrModeNotFound, subject)
+		}
+	}
+	return defaultMode, nil
+}
+
+// SetMode sets the mode for a subject. Switching to IMPORT mode requires the subject
+// (or, globally, the whole registry) to be empty unless force is set.
+func (r *Registry) SetMode(ctx context.Context, subject string, mode types.Mode, force bool) (err error) {
+	ctx, span := startSpan(ctx, "Registry.SetMode", attrSubject.String(subject))
+	defer func() { endSpan(span, err) }()
+
+	switch mode {
+	case types.ReadWrite, types.ReadOnly, types.ReadOnlyOverride, types.Import:
+		// Valid
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidMode, mode)
+	}
+	if err := r.checkAvailable(); err != nil {
+		return err
+	}
+
+	if mode == types.Import && !force {
+		empty, err := r.isEmpty(ctx, subject)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return fmt.Errorf("%w: cannot import since found existing subjects", ErrOperationNotPermitted)
+		}
+	}
+
+	key := configKeys(subject, keyPrefixSubjectMode, keyPrefixGlobalMode)[0]
+	if _, err := r.configStore(ctx).Put(key, []byte(mode)); err != nil {
+		return err
+	}
+	r.syncCache(r.configStore(ctx), &r.configView)
+	return nil
+}
+
+// DeleteMode removes the mode for a subject so it reverts to the global mode.
+// It returns the mode that was removed.
+func (r *Registry) DeleteMode(ctx context.Context, subject string) (_ types.Mode, err error) {
+	ctx, span := startSpan(ctx, "Registry.DeleteMode", attrSubject.String(subject))
+	defer func() { endSpan(span, err) }()
+
+	if err := r.checkAvailable(); err != nil {
+		return "", err
+	}
+	mode, err := r.GetMode(ctx, subject, false)
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.configStore(ctx).Delete(configKeys(subject, keyPrefixSubjectMode, keyPrefixGlobalMode)[0], 0); err != nil {
+		return "", fmt.Errorf("delete subject mode: %w", err)
+	}
+	r.syncCache(r.configStore(ctx), &r.configView)
+	return mode, nil
+}
+
+// effectiveMode resolves the mode that applies to writes on a subject.
+// A global READONLY_OVERRIDE takes precedence over any subject mode.
+func (r *Registry) effectiveMode(ctx context.Context, subject string) (types.Mode, error) {
+	global, err := r.GetMode(ctx, "global", true)
+	if err != nil {
+		return "", err
+	}
+	if global == types.ReadOnlyOverride {
+		return global, nil
+	}
+	return r.GetMode(ctx, subject, true)
+}
+
+// checkWritable returns the effective mode of a subject, or ErrOperationNotPermitted
+// if the subject is read-only
+func (r *Registry) checkWritable(ctx context.Context, subject string) (types.Mode, error) {
+	if err := r.checkAvailable(); err != nil {
+		return "", err
+	}
+	mode, err := r.effectiveMode(ctx, subject)
+	if err != nil {
+		return "", fmt.Errorf("get mode: %w", err)
+	}
+	if mode == types.ReadOnly || mode == types.ReadOnlyOverride {
+		return mode, fmt.Errorf("%w: subject %s is in %s mode", ErrOperationNotPermitted, subject, mode)
+	}
+	return mode, nil
+}
+
+// isEmpty reports whether a subject, a context given as :.{context}: or the
+// whole registry for "global" has no versions
+func (r *Registry) isEmpty(ctx context.Context, subject string) (bool, error) {
+	context, name := SplitSubject(subject)
+	if subject != "global" && name != "" {
+		idx, err := r.getSubjectIndex(ctx, subject)
+		if err != nil {
+			return false, err
+		}
+		return len(idx.Versions) == 0, nil
+	}
+
+	subjects, ok := r.cachedSubjects(true)
+	if !ok {
+		var err error
+		if subjects, err = r.indexedSubjects(ctx); err != nil {
+			return false, err
+		}
+	}
+	for _, s := range subjects {
+		if subject == "global" || ContextOf(s) == context {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// indexedSubjects lists the subjects with a version index, walking the keys
+// of the schemas bucket. It serves reads until the cache is hydrated.
+func (r *Registry) indexedSubjects(ctx context.Context) ([]string, error) {
+	keys, err := r.schemaStore(ctx).List("")
+	if err != nil {
+		return nil, fmt.Errorf("get schema keys: %w", err)
+	}
+
+	var subjects []string
+	for _, key := range keys {
+		context, rest := splitKey(key)
+		if strings.HasPrefix(rest, keyPrefixSubjectIndex) {
+			subjects = append(subjects, QualifySubject(context, strings.TrimPrefix(rest, keyPrefixSubjectIndex)))
+		}
+	}
+	return subjects, nil
+}
+
+// CheckCompatibility checks if a new schema is compatible with the existing
+// versions of a subject and returns the violations found, none if it is compatible
+func (r *Registry) CheckCompatibility(ctx context.Context, subject string, newSchema string, schemaType types.SchemaType, references []types.SchemaReference, level types.CompatibilityLevel) (_ []types.Violation, err error) {
+	ctx, span := startSpan(ctx, "Registry.CheckCompatibility", attrSubject.String(subject), attrSchemaType.String(string(schemaType)), attrLevel.String(string(level)))
+	defer func() { endSpan(span, err) }()
+
 	format, ok := r.formats[schemaType]
 	if !ok {
-		return false, fmt.Errorf("unsupported schema type: %s", schemaType)
+		return nil, fmt.Errorf("%w: unsupported schema type %s", ErrInvalidSchema, schemaType)
+	}
+	refs, err := r.resolveReferences(ctx, ContextOf(subject), schemaType, references)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get all versions for the subject
-	versions, err := r.GetVersions(subject)
+	versions, err := r.GetVersions(ctx, subject, false)
 	if err != nil {
-		if err.Error() == "no versions found" {
+		if errors.Is(err, ErrSubjectNotFound) {
 			// No existing schema, so any schema is compatible
-			return true, nil
+			return nil, nil
 		}
-		return false, err
+		return nil, err
+	}
+
+	return r.checkVersions(ctx, subject, versions, schemaType, format, newSchema, refs, level)
+}
+
+// checkVersions checks a new schema against the latest of the given versions of
+// a subject, or against all of them for transitive levels
+func (r *Registry) checkVersions(ctx context.Context, subject string, versions []int, schemaType types.SchemaType, format types.SchemaFormat, newSchema string, newRefs []types.ResolvedReference, level types.CompatibilityLevel) ([]types.Violation, error) {
+	if len(versions) == 0 {
+		return nil, nil
 	}
 
 	// Sort versions to ensure we check in order
 	sort.Ints(versions)
 
 	// For transitive compatibility, we need to check against all previous versions
-	if level == types.BackwardTransitive || level == types.ForwardTransitive || level == types.FullTransitive {
-		for _, version := range versions {
-			schema, err := r.getSchemaByVersion(subject, version)
-			if err != nil {
-				return false, err
-			}
+	if level != types.BackwardTransitive && level != types.ForwardTransitive && level != types.FullTransitive {
+		versions = versions[len(versions)-1:]
+	}
 
-			// Check compatibility with this version
-			compatible, err := format.CheckCompatibility(schema.Schema, newSchema, level)
-			if err != nil {
-				return false, err
-			}
-			if !compatible {
-				return false, nil
-			}
+	var violations []types.Violation
+	for _, version := range versions {
+		schema, err := r.getSchemaByVersion(ctx, subject, version)
+		if err != nil {
+			return nil, err
 		}
-		return true, nil
-	}
 
-	// For non-transitive compatibility, only check against the latest version
-	latestVersion := versions[len(versions)-1]
-	schema, err := r.getSchemaByVersion(subject, latestVersion)
-	if err != nil {
-		return false, err
-	}
+		oldRefs, err := r.resolveReferences(ctx, ContextOf(subject), schema.Type, schema.References)
+		if err != nil {
+			return nil, err
+		}
 
-	// Check compatibility
-	return format.CheckCompatibility(schema.Schema, newSchema, level)
+		// Check compatibility with this version
+		found, err := checkFormatCompatibility(ctx, schemaType, format, schema.Schema, oldRefs, newSchema, newRefs, level)
+		recordCompatibilityCheck(schemaType, level, found, err)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, found...)
+	}
+	return violations, nil
 }
 
 // Serialize serializes data according to a schema
-func (r *Registry) Serialize(data interface{}, schemaID int) ([]byte, error) {
+func (r *Registry) Serialize(ctx context.Context, data interface{}, schemaID int) ([]byte, error) {
+	return r.SerializeMessage(ctx, data, schemaID, "")
+}
+
+// SerializeMessage serializes data as the message with the given
+// fully-qualified name of a Protobuf schema. Payloads of Protobuf schemas carry
+// the indexes of the message in the schema; the first message is used if the
+// name is empty.
+func (r *Registry) SerializeMessage(ctx context.Context, data interface{}, schemaID int, messageName string) (_ []byte, err error) {
+	ctx, span := startSpan(ctx, "Registry.SerializeMessage", attrSchemaID.Int(schemaID))
+	defer func() { endSpan(span, err) }()
+
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	// Get the schema by ID
-	schema, err := r.GetSchema(schemaID)
+	schema, err := r.GetSchema(ctx, schemaID)
 	if err != nil {
 		return nil, fmt.Errorf("get schema: %w", err)
 	}
 
 	format, ok := r.formats[schema.Type]
 	if !ok {
-		return nil, fmt.Errorf("unsupported schema type: %s", schema.Type)
+		return nil, fmt.Errorf("%w: unsupported schema type %s", ErrInvalidSchema, schema.Type)
 	}
 
-	// Serialize data
-	serialized, err := format.Serialize(data, schema.Schema)
+	refs, err := r.resolveReferences(ctx, ContextOf(schema.Subject), schema.Type, schema.References)
 	if err != nil {
 		return nil, fmt.Errorf("serialize: %w", err)
 	}
 
-	// Create wire format with magic byte and schema ID
 	wireFormat := WireFormat{
 		MagicByte: MagicByte,
 		SchemaID:  int32(schemaID),
-		Data:      serialized,
 	}
 
-	// Combine into final bytes
-	result := make([]byte, 5+len(serialized))
-	result[0] = wireFormat.MagicByte
-	// Schema ID as 4 bytes in big-endian format
-	result[1] = byte(wireFormat.SchemaID >> 24)
-	result[2] = byte(wireFormat.SchemaID >> 16)
-	result[3] = byte(wireFormat.SchemaID >> 8)
-	result[4] = byte(wireFormat.SchemaID)
-	copy(result[5:], wireFormat.Data)
+	// Serialize data
+	if messageFormat, ok := format.(types.MessageFormat); ok {
+		wireFormat.MessageIndexes, err = messageFormat.MessageIndexes(schema.Schema, refs, messageName)
+		if err != nil {
+			return nil, fmt.Errorf("serialize: %w", err)
+		}
+		wireFormat.Data, err = messageFormat.SerializeMessage(data, schema.Schema, refs, messageName)
+	} else {
+		if messageName != "" {
+			return nil, fmt.Errorf("serialize: %s schemas have no message types", schema.Type)
+		}
+		wireFormat.Data, err = format.Serialize(data, schema.Schema, refs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("serialize: %w", err)
+	}
 
-	return result, nil
+	return wireFormat.Bytes(), nil
 }
 
 // Deserialize deserializes data according to a schema
-func (r *Registry) Deserialize(data []byte) (interface{}, error) {
+func (r *Registry) Deserialize(ctx context.Context, data []byte) (_ interface{}, err error) {
+	ctx, span := startSpan(ctx, "Registry.Deserialize")
+	defer func() { endSpan(span, err) }()
+
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	// Parse wire format
-	if len(data) < 5 {
-		return nil, fmt.Errorf("data too short")
-	}
-
-	// Verify magic byte
-	if data[0] != MagicByte {
-		return nil, fmt.Errorf("invalid magic byte")
+	wireFormat, err := parseWireFormat(data)
+	if err != nil {
+		return nil, err
 	}
 
-	// Extract schema ID
-	schemaID := int(data[1])<<24 | int(data[2])<<16 | int(data[3])<<8 | int(data[4])
-	payload := data[5:]
-
 	// Get the schema by ID
-	schema, err := r.GetSchema(schemaID)
+	schema, err := r.GetSchema(ctx, int(wireFormat.SchemaID))
 	if err != nil {
 		return nil, fmt.Errorf("get schema: %w", err)
 	}
 
 	format, ok := r.formats[schema.Type]
 	if !ok {
-		return nil, fmt.Errorf("unsupported schema type: %s", schema.Type)
+		return nil, fmt.Errorf("%w: unsupported schema type %s", ErrInvalidSchema, schema.Type)
+	}
+
+	refs, err := r.resolveReferences(ctx, ContextOf(schema.Subject), schema.Type, schema.References)
+	if err != nil {
+		return nil, fmt.Errorf("deserialize: %w", err)
+	}
+
+	messageFormat, ok := format.(types.MessageFormat)
+	if !ok {
+		// Deserialize data
+		return format.Deserialize(wireFormat.Data, schema.Schema, refs)
+	}
+
+	// Select the message the payload was written with
+	if err := wireFormat.readMessageIndexes(); err != nil {
+		return nil, err
+	}
+	messageName, err := messageFormat.MessageName(schema.Schema, refs, wireFormat.MessageIndexes)
+	if err != nil {
+		return nil, err
+	}
+	return messageFormat.DeserializeMessage(wireFormat.Data, schema.Schema, refs, messageName)
+}
+
+// GetReferencedBy returns the IDs of the live schemas referencing a subject version
+func (r *Registry) GetReferencedBy(ctx context.Context, subject string, version string) (_ []int, err error) {
+	ctx, span := startSpan(ctx, "Registry.GetReferencedBy", attrSubject.String(subject), attrVersion.String(version))
+	defer func() { endSpan(span, err) }()
+
+	schema, err := r.GetSchemaBySubjectVersion(ctx, subject, version, false)
+	if err != nil {
+		return nil, err
 	}
 
-	// Deserialize data
-	return format.Deserialize(payload, schema.Schema)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	referrers, err := r.getReferrers(ctx, schema.Subject, schema.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int]bool)
+	ids := make([]int, 0, len(referrers))
+	for _, usage := range referrers {
+		referrer, err := r.getSchemaByVersion(ctx, usage.Subject, usage.Version)
+		if errors.Is(err, ErrSubjectNotFound) || errors.Is(err, ErrVersionNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if referrer.Deleted || seen[referrer.ID] {
+			continue
+		}
+		seen[referrer.ID] = true
+		ids = append(ids, referrer.ID)
+	}
+	sort.Ints(ids)
+	return ids, nil
 }
 
-// GetSchemaById is an alias for GetSchema to match the API naming
-func (r *Registry) GetSchemaById(id string) (*types.Schema, error) {
+// resolveReferences fetches the schemas referenced by a schema of a context
+// and, recursively, the schemas they reference. Every schema comes after the
+// schemas it references, and each is included once.
+func (r *Registry) resolveReferences(ctx context.Context, context string, schemaType types.SchemaType, references []types.SchemaReference) ([]types.ResolvedReference, error) {
+	var resolved []types.ResolvedReference
+	done := make(map[string]bool)
+	visiting := make(map[string]bool)
+
+	var visit func(context string, references []types.SchemaReference) error
+	visit = func(context string, references []types.SchemaReference) error {
+		for _, ref := range references {
+			subject := qualifyReference(context, ref.Subject)
+			key := fmt.Sprintf("%s/%d", subject, ref.Version)
+			if done[key] {
+				continue
+			}
+			if visiting[key] {
+				return fmt.Errorf("%w: reference cycle through %s version %d", ErrInvalidSchema, subject, ref.Version)
+			}
+			visiting[key] = true
+
+			refSchema, err := r.getSchemaByVersion(ctx, subject, ref.Version)
+			if errors.Is(err, ErrSubjectNotFound) || errors.Is(err, ErrVersionNotFound) {
+				return fmt.Errorf("%w: %s version %d", ErrReferenceNotFound, subject, ref.Version)
+			}
+			if err != nil {
+				return fmt.Errorf("get referenced schema: %w", err)
+			}
+			if refSchema.Type != schemaType {
+				return fmt.Errorf("%w: referenced schema type mismatch: expected %s, got %s", ErrInvalidSchema, schemaType, refSchema.Type)
+			}
+
+			if err := visit(ContextOf(subject), refSchema.References); err != nil {
+				return err
+			}
+			visiting[key] = false
+			done[key] = true
+
+			resolved = append(resolved, types.ResolvedReference{
+				Name:    ref.Name,
+				Subject: subject,
+				Version: refSchema.Version,
+				Schema:  refSchema.Schema,
+			})
+		}
+		return nil
+	}
+
+	if err := visit(context, references); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// GetSchemaById retrieves a schema by an ID given as a string, in the context
+// of subject, or in the default context if subject is empty. The span is that
+// of GetSchemaInContext.
+func (r *Registry) GetSchemaById(ctx context.Context, id string, subject string) (*types.Schema, error) {
 	idNum, err := strconv.Atoi(id)
 	if err != nil {
-		return nil, fmt.Errorf("invalid schema ID: %s", id)
+		return nil, fmt.Errorf("%w: invalid schema ID %s", ErrSchemaNotFound, id)
 	}
-	return r.GetSchema(idNum)
+	return r.GetSchemaInContext(ctx, ContextOf(subject), idNum)
 }
 
-// DeleteSchemaVersion deletes a specific version of a schema
-func (r *Registry) DeleteSchemaVersion(subject string, version string) error {
+// GetSubjectsById returns the subjects with a version, live or soft-deleted,
+// using a schema ID given as a string, in the context of subject, or in the
+// default context if subject is empty
+func (r *Registry) GetSubjectsById(ctx context.Context, id string, subject string) (_ []string, err error) {
+	ctx, span := startSpan(ctx, "Registry.GetSubjectsById", attrSubject.String(subject), attrSchemaID.String(id))
+	defer func() { endSpan(span, err) }()
+
+	idNum, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid schema ID %s", ErrSchemaNotFound, id)
+	}
+	usages, err := r.getIDUsages(ctx, ContextOf(subject), idNum)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(usages))
+	subjects := make([]string, 0, len(usages))
+	for _, usage := range usages {
+		if !seen[usage.Subject] {
+			seen[usage.Subject] = true
+			subjects = append(subjects, usage.Subject)
+		}
+	}
+	sort.Strings(subjects)
+	return subjects, nil
+}
+
+// DeleteSchemaVersion deletes a specific version of a schema and returns the
+// deleted version number. Without permanent the version is soft-deleted: it is
+// hidden from listings but its schema ID stays resolvable. A permanent delete is
+// only allowed after a soft delete and removes the version for good.
+func (r *Registry) DeleteSchemaVersion(ctx context.Context, subject string, version string, permanent bool) (_ int, err error) {
+	ctx, span := startSpan(ctx, "Registry.DeleteSchemaVersion", attrSubject.String(subject), attrVersion.String(version))
+	defer func() { endSpan(span, err) }()
+
+	if _, err := r.checkWritable(ctx, subject); err != nil {
+		return 0, err
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	var versionNum int
-	var err error
 
 	// Handle "latest" version
 	if version == "latest" {
-		versionNum, err = r.getLatestVersion(subject)
+		if permanent {
+			versionNum, err = r.getLatestVersion(ctx, subject)
+		} else {
+			versionNum, err = r.getLatestLiveVersion(ctx, subject)
+		}
 		if err != nil {
-			return err
+			return 0, err
+		}
+		if versionNum == 0 {
+			return 0, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
 		}
 	} else {
-		versionNum, err = strconv.Atoi(version)
+		versionNum, err = parseVersion(version)
 		if err != nil {
-			return fmt.Errorf("invalid version: %s", version)
+			return 0, err
 		}
 	}
 
 	// Check if version exists
-	key := fmt.Sprintf("%s%s/versions/%d", keyPrefixSubjects, subject, versionNum)
-	if _, err := r.kvSchemas.Get(key); err != nil {
-		return fmt.Errorf("version not found")
+	key := versionKey(subject, versionNum)
+	schema, err := r.getSchemaByVersion(ctx, subject, versionNum)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := r.checkNotReferenced(ctx, schema, permanent, false); err != nil {
+		return 0, err
+	}
+
+	if !permanent {
+		if schema.Deleted {
+			return 0, fmt.Errorf("%w: %s version %d", ErrVersionSoftDeleted, subject, versionNum)
+		}
+		if err := r.softDelete(ctx, key, schema); err != nil {
+			return 0, fmt.Errorf("delete version: %w", err)
+		}
+		r.syncCache(r.schemaStore(ctx), &r.schemasView)
+		return versionNum, nil
+	}
+
+	if !schema.Deleted {
+		return 0, fmt.Errorf("%w: %s version %d", ErrVersionNotSoftDeleted, subject, versionNum)
 	}
 
 	// Delete the version
-	if err := r.kvSchemas.Delete(key); err != nil {
-		return fmt.Errorf("delete version: %w", err)
+	if err := r.schemaStore(ctx).Delete(key, 0); err != nil {
+		return 0, fmt.Errorf("delete version: %w", err)
+	}
+	if err := r.unindexSubjectVersion(ctx, schema); err != nil {
+		// Left in the ID index, the schema is kept rather than purged
+		slog.Warn("Failed to unindex version", "subject", subject, "version", versionNum, "error", err)
+	}
+	if err := r.purgeUnusedSchema(ctx, ContextOf(subject), schema.ID); err != nil {
+		return 0, err
 	}
 
-	return nil
+	r.syncCache(r.schemaStore(ctx), &r.schemasView)
+	return versionNum, nil
 }
 
-// DeleteSubject deletes all versions of a subject
-func (r *Registry) DeleteSubject(subject string) ([]int, error) {
-	slog.Debug("DeleteSubject: deleting subject", "subject", subject)
-	// Get all versions
-	versions, err := r.GetVersions(subject)
+// DeleteSubject deletes all versions of a subject and returns the schema IDs of
+// the deleted versions. Without permanent the live versions are soft-deleted.
+// A permanent delete is only allowed once every version has been soft-deleted.
+func (r *Registry) DeleteSubject(ctx context.Context, subject string, permanent bool) (_ []int, err error) {
+	ctx, span := startSpan(ctx, "Registry.DeleteSubject", attrSubject.String(subject))
+	defer func() { endSpan(span, err) }()
+
+	slog.Debug("DeleteSubject: deleting subject", "subject", subject, "permanent", permanent)
+	if _, err := r.checkWritable(ctx, subject); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Get all versions, including soft-deleted ones
+	schemas, err := r.getSubjectVersions(ctx, subject)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(versions) == 0 {
-		return nil, fmt.Errorf("subject not found")
+	if len(schemas) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+	}
+	for _, schema := range schemas {
+		if err := r.checkNotReferenced(ctx, schema, permanent, true); err != nil {
+			return nil, err
+		}
 	}
 
-	slog.Debug("DeleteSubject: versions to delete", "subject", subject, "versions", versions)
-	deletedIDs := make([]int, 0, len(versions))
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	for _, version := range versions {
-		key := fmt.Sprintf("%s%s/versions/%d", keyPrefixSubjects, subject, version)
-		// Get schema before deleting
-		entry, err := r.kvSchemas.Get(key)
-		if err == nil {
-			var schema types.Schema
-			if err := json.Unmarshal(entry.Value(), &schema); err == nil {
-				slog.Debug("DeleteSubject: deleting schema version", "version", version, "id", schema.ID)
-				deletedIDs = append(deletedIDs, schema.ID)
-				// Delete schema by ID if it exists
-				schemaKey := keyPrefixSchemas + strconv.Itoa(schema.ID)
-				if err := r.kvSchemas.Delete(schemaKey); err != nil {
-					slog.Debug("DeleteSubject: failed to delete schema by ID", "id", schema.ID, "err", err)
-				} else {
-					slog.Debug("DeleteSubject: deleted schema by ID", "id", schema.ID)
-				}
-				delete(r.schemaCache, schema.ID)
+	deletedIDs := make([]int, 0, len(schemas))
+	if !permanent {
+		for _, schema := range schemas {
+			if schema.Deleted {
+				continue
 			}
+			key := versionKey(subject, schema.Version)
+			slog.Debug("DeleteSubject: soft deleting schema version", "version", schema.Version, "id", schema.ID)
+			if err := r.softDelete(ctx, key, schema); err != nil {
+				return nil, fmt.Errorf("delete version %d: %w", schema.Version, err)
+			}
+			deletedIDs = append(deletedIDs, schema.ID)
+		}
+		if len(deletedIDs) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrSubjectSoftDeleted, subject)
 		}
-		if err := r.kvSchemas.Delete(key); err != nil {
+
+		r.syncCache(r.schemaStore(ctx), &r.schemasView)
+		slog.Debug("DeleteSubject: deleted IDs", "ids", deletedIDs)
+		return deletedIDs, nil
+	}
+
+	for _, schema := range schemas {
+		if !schema.Deleted {
+			return nil, fmt.Errorf("%w: %s", ErrSubjectNotSoftDeleted, subject)
+		}
+	}
+
+	for _, schema := range schemas {
+		key := versionKey(subject, schema.Version)
+		slog.Debug("DeleteSubject: permanently deleting schema version", "version", schema.Version, "id", schema.ID)
+		if err := r.schemaStore(ctx).Delete(key, 0); err != nil {
 			slog.Debug("DeleteSubject: failed to delete version key", "key", key, "err", err)
-			return nil, fmt.Errorf("delete version %d: %w", version, err)
+			return nil, fmt.Errorf("delete version %d: %w", schema.Version, err)
+		}
+		if err := r.unindexSubjectVersion(ctx, schema); err != nil {
+			// Left in the ID index, the schema is kept rather than purged
+			slog.Warn("Failed to unindex version", "subject", subject, "version", schema.Version, "error", err)
+		}
+		deletedIDs = append(deletedIDs, schema.ID)
+	}
+
+	// Drop schema records no other version refers to anymore
+	for _, id := range deletedIDs {
+		if err := r.purgeUnusedSchema(ctx, ContextOf(subject), id); err != nil {
+			return nil, err
 		}
 	}
 
-	// Remove from cache
-	delete(r.subjectCache, subject)
-	delete(r.versionCache, subject)
+	r.syncCache(r.schemaStore(ctx), &r.schemasView)
 
 	slog.Debug("DeleteSubject: deleted IDs", "ids", deletedIDs)
 	return deletedIDs, nil
 }
 
-// LookupSchema checks if a schema is already registered under a subject
-func (r *Registry) LookupSchema(subject string, schemaStr string, schemaType types.SchemaType) (*types.Schema, error) {
+// checkNotReferenced returns ErrReferenceExists if other subjects reference a
+// version that is about to be deleted. Soft-deleted versions only block a
+// permanent delete, as their schemas stay resolvable by ID until then.
+// References from within the same subject don't count when the whole subject
+// is deleted, since they go along with the version.
+func (r *Registry) checkNotReferenced(ctx context.Context, schema *types.Schema, permanent, wholeSubject bool) error {
+	referrers, err := r.getReferrers(ctx, schema.Subject, schema.Version)
+	if err != nil {
+		return err
+	}
+
+	for _, usage := range referrers {
+		if wholeSubject && usage.Subject == schema.Subject {
+			continue
+		}
+		referrer, err := r.getSchemaByVersion(ctx, usage.Subject, usage.Version)
+		if errors.Is(err, ErrSubjectNotFound) || errors.Is(err, ErrVersionNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !referrer.Deleted || permanent {
+			return fmt.Errorf("%w: %s version %d is referenced by %s version %d", ErrReferenceExists, schema.Subject, schema.Version, usage.Subject, usage.Version)
+		}
+	}
+	return nil
+}
+
+// softDelete marks a subject version as deleted while keeping its record
+func (r *Registry) softDelete(ctx context.Context, key string, schema *types.Schema) error {
+	schema.Deleted = true
+	schemaBytes, err := json.Marshal(schema)
+	if err != nil {
+		return fmt.Errorf("marshal schema: %w", err)
+	}
+
+	if _, err := r.schemaStore(ctx).Put(key, schemaBytes); err != nil {
+		return err
+	}
+
+	// The record is the source of truth, a stale index is repaired by its next reader
+	if err := r.updateSubjectIndex(ctx, schema.Subject, func(idx *subjectIndex) {
+		idx.put(versionEntry{Version: schema.Version, ID: schema.ID, Deleted: true})
+	}); err != nil {
+		slog.Warn("Failed to index soft delete, leaving the index to be repaired", "subject", schema.Subject, "version", schema.Version, "error", err)
+	}
+	return nil
+}
+
+// purgeUnusedSchema deletes the schema record for an ID of a context once no
+// subject version, live or soft-deleted, refers to it anymore
+func (r *Registry) purgeUnusedSchema(ctx context.Context, context string, id int) error {
+	usages, err := r.getIDUsages(ctx, context, id)
+	if err != nil {
+		return err
+	}
+	if len(usages) > 0 {
+		return nil
+	}
+
+	// Only drop the content index entry if it still points at this ID
+	if schema, err := r.getSchemaRecord(ctx, context, id); err == nil {
+		hash := schemaHash(schema.Schema, schema.Type, schema.References)
+		if hashedID, err := r.lookupSchemaID(ctx, context, hash); err == nil && hashedID == id {
+			if err := r.schemaStore(ctx).Delete(hashIndexKey(context, hash), 0); err != nil && err != ErrKeyNotFound {
+				return fmt.Errorf("delete hash index for schema %d: %w", id, err)
+			}
+		}
+	}
+
+	if err := r.schemaStore(ctx).Delete(schemaKey(context, id), 0); err != nil && err != ErrKeyNotFound {
+		return fmt.Errorf("delete schema %d: %w", id, err)
+	}
+	slog.Debug("Purged unused schema", "id", id)
+	return nil
+}
+
+// LookupSchema checks if a schema is already registered under a subject. With
+// normalization requested or configured, the schema also matches a version
+// registered in normalized form.
+func (r *Registry) LookupSchema(ctx context.Context, subject string, schemaStr string, schemaType types.SchemaType, references []types.SchemaReference, normalize bool) (_ *types.Schema, err error) {
+	ctx, span := startSpan(ctx, "Registry.LookupSchema", attrSubject.String(subject), attrSchemaType.String(string(schemaType)))
+	defer func() { endSpan(span, err) }()
+
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	// Validate schema format
 	format, ok := r.formats[schemaType]
 	if !ok {
-		return nil, fmt.Errorf("unsupported schema type: %s", schemaType)
+		return nil, fmt.Errorf("%w: unsupported schema type %s", ErrInvalidSchema, schemaType)
 	}
 
-	// Validate the schema
-	if err := format.Validate(schemaStr); err != nil {
-		return nil, fmt.Errorf("validate schema: %w", err)
+	// Resolve references and validate the schema against them
+	refs, err := r.resolveReferences(ctx, ContextOf(subject), schemaType, references)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSchema(ctx, schemaType, format, schemaStr, refs); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
 	}
 
-	// Get all versions
-	versions, err := r.GetVersions(subject)
+	idx, err := r.getSubjectIndex(ctx, subject)
 	if err != nil {
 		return nil, err
 	}
+	if idx.latest(false) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+	}
 
-	// Check each version
-	for _, version := range versions {
-		schema, err := r.getSchemaByVersion(subject, version)
+	candidates := []string{schemaStr}
+	normalized, err := r.normalizeSchema(ctx, subject, format, schemaStr, refs, normalize)
+	if err != nil {
+		return nil, err
+	}
+	if normalized != schemaStr {
+		candidates = append([]string{normalized}, candidates...)
+	}
+
+	// Find the schema by content, then the subject version using it
+	for _, candidate := range candidates {
+		id, err := r.lookupSchemaID(ctx, ContextOf(subject), schemaHash(candidate, schemaType, references))
 		if err != nil {
-			continue
+			return nil, err
 		}
-
-		slog.Debug("Checking schema", "subject", subject, "version", version, "schema", schema.Schema, "schemaType", schema.Type)
-		slog.Debug("Schema", "schema", schema.Schema, "schemaType", schema.Type)
-		// Check if schemas are equal
-		if schema.Schema == schemaStr && schema.Type == schemaType {
-			return schema, nil
-		} else {
-			slog.Debug("Schema mismatch", "subject", subject, "version", version, "schema", schema.Schema, "schemaType", schema.Type)
+		if entry := idx.findLiveID(id); id > 0 && entry != nil {
+			return r.getSchemaByVersion(ctx, subject, entry.Version)
 		}
 	}
 
-	return nil, fmt.Errorf("schema not found")
+	slog.Debug("Schema not registered under subject", "subject", subject)
+	return nil, fmt.Errorf("%w: not registered under subject %s", ErrSchemaNotFound, subject)
 }
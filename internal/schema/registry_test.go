@@ -2,8 +2,13 @@ package schema
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,8 +16,12 @@ import (
 
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestMain(m *testing.M) {
@@ -21,7 +30,7 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func setupTestNATS(t *testing.T) (*server.Server, *nats.Conn, nats.KeyValue, nats.KeyValue) {
+func setupTestNATS(t *testing.T) (*server.Server, *nats.Conn, Store, Store) {
 	// Create a new NATS server with custom port and JetStream enabled
 	opts := &server.Options{
 		Port:      19999,
@@ -67,7 +76,7 @@ func setupTestNATS(t *testing.T) (*server.Server, *nats.Conn, nats.KeyValue, nat
 				})
 				require.NoError(t, err)
 
-				return ns, nc, kvSchemas, kvConfig
+				return ns, nc, NewJetStreamStore(kvSchemas), NewJetStreamStore(kvConfig)
 			}
 			time.Sleep(100 * time.Millisecond)
 		}
@@ -101,43 +110,48 @@ func TestRegistry_RegisterSchema(t *testing.T) {
 		subject    string
 		schema     string
 		schemaType types.SchemaType
-		wantErr    bool
+		wantErr    error
 	}{
 		{
 			name:       "Valid JSON Schema",
 			subject:    "test-subject",
 			schema:     `{"type": "object", "properties": {"name": {"type": "string"}}}`,
 			schemaType: types.JSON,
-			wantErr:    false,
 		},
 		{
 			name:       "Valid Avro Schema",
-			subject:    "test-subject",
+			subject:    "test-subject-avro",
 			schema:     `{"type": "record", "name": "User", "fields": [{"name": "name", "type": "string"}]}`,
 			schemaType: types.Avro,
-			wantErr:    false,
 		},
 		{
 			name:       "Invalid Schema",
 			subject:    "test-subject",
 			schema:     `{"invalid": "schema"`,
 			schemaType: types.JSON,
-			wantErr:    true,
+			wantErr:    ErrInvalidSchema,
+		},
+		{
+			name:       "Unsupported Schema Type",
+			subject:    "test-subject-xml",
+			schema:     `<schema/>`,
+			schemaType: types.SchemaType("XML"),
+			wantErr:    ErrInvalidSchema,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			id, err := registry.RegisterSchema(tt.subject, tt.schema, tt.schemaType)
-			if tt.wantErr {
-				assert.Error(t, err)
+			id, err := registry.RegisterSchema(t.Context(), tt.subject, tt.schema, tt.schemaType, nil, false)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
 				return
 			}
 			assert.NoError(t, err)
 			assert.Greater(t, id, 0)
 
 			// Verify schema was stored
-			schema, err := registry.GetSchema(id)
+			schema, err := registry.GetSchema(t.Context(), id)
 			assert.NoError(t, err)
 			assert.Equal(t, tt.schema, schema.Schema)
 			assert.Equal(t, tt.schemaType, schema.Type)
@@ -151,40 +165,50 @@ func TestRegistry_GetSchemaBySubjectVersion(t *testing.T) {
 
 	// Register a test schema
 	schema := `{"type": "object", "properties": {"name": {"type": "string"}}}`
-	id, err := registry.RegisterSchema("test-subject", schema, types.JSON)
+	id, err := registry.RegisterSchema(t.Context(), "test-subject", schema, types.JSON, nil, false)
 	require.NoError(t, err)
 
 	tests := []struct {
 		name    string
 		subject string
 		version string
-		wantErr bool
+		wantErr error
 	}{
 		{
 			name:    "Valid Version",
 			subject: "test-subject",
 			version: "1",
-			wantErr: false,
 		},
 		{
 			name:    "Latest Version",
 			subject: "test-subject",
 			version: "latest",
-			wantErr: false,
 		},
 		{
 			name:    "Non-existent Subject",
 			subject: "non-existent",
 			version: "1",
-			wantErr: true,
+			wantErr: ErrSubjectNotFound,
+		},
+		{
+			name:    "Non-existent Version",
+			subject: "test-subject",
+			version: "2",
+			wantErr: ErrVersionNotFound,
+		},
+		{
+			name:    "Invalid Version",
+			subject: "test-subject",
+			version: "first",
+			wantErr: ErrInvalidVersion,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			schema, err := registry.GetSchemaBySubjectVersion(tt.subject, tt.version)
-			if tt.wantErr {
-				assert.Error(t, err)
+			schema, err := registry.GetSchemaBySubjectVersion(t.Context(), tt.subject, tt.version, false)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
 				return
 			}
 			assert.NoError(t, err)
@@ -198,45 +222,52 @@ func TestRegistry_Compatibility(t *testing.T) {
 	registry, cleanup := setupRegistry(t)
 	defer cleanup()
 
-	// Register initial schema
-	initialSchema := `{"type": "object", "properties": {"name": {"type": "string"}}}`
-	_, err := registry.RegisterSchema("test-subject", initialSchema, types.JSON)
+	// Register initial schema, closed so that later versions can add properties
+	initialSchema := `{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": false}`
+	_, err := registry.RegisterSchema(t.Context(), "test-subject", initialSchema, types.JSON, nil, false)
 	require.NoError(t, err)
 
 	tests := []struct {
-		name       string
-		newSchema  string
-		level      types.CompatibilityLevel
-		wantCompat bool
-		wantErr    bool
+		name           string
+		newSchema      string
+		level          types.CompatibilityLevel
+		wantViolations []types.Violation
 	}{
 		{
-			name:       "Compatible Schema - Backward",
-			newSchema:  `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}}`,
-			level:      types.Backward,
-			wantCompat: true,
-			wantErr:    false,
+			name:      "Compatible Schema - Backward",
+			newSchema: `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}}`,
+			level:     types.Backward,
 		},
 		{
-			name:       "Incompatible Schema - Backward",
-			newSchema:  `{"type": "object", "properties": {"name": {"type": "integer"}}}`,
-			level:      types.Backward,
-			wantCompat: false,
-			wantErr:    true,
+			name:      "Incompatible Schema - Backward",
+			newSchema: `{"type": "object", "properties": {"name": {"type": "integer"}}}`,
+			level:     types.Backward,
+			wantViolations: []types.Violation{{
+				Path:    "#/properties/name",
+				OldType: "string",
+				NewType: "integer",
+				Rule:    "TYPE_CHANGED",
+				Message: "writer type string cannot be read as integer",
+			}},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			compat, err := registry.CheckCompatibility("test-subject", tt.newSchema, types.JSON, tt.level)
-			if tt.wantErr {
-				assert.Error(t, err)
-				return
-			}
-			assert.NoError(t, err)
-			assert.Equal(t, tt.wantCompat, compat)
+			violations, err := registry.CheckCompatibility(t.Context(), "test-subject", tt.newSchema, types.JSON, nil, tt.level)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantViolations, violations)
 		})
 	}
+
+	t.Run("Registration Reports Violations", func(t *testing.T) {
+		_, err := registry.RegisterSchema(t.Context(), "test-subject", `{"type": "object", "properties": {"name": {"type": "integer"}}}`, types.JSON, nil, false)
+		assert.ErrorIs(t, err, ErrIncompatible)
+		var compatErr *CompatibilityError
+		require.ErrorAs(t, err, &compatErr)
+		require.Len(t, compatErr.Violations, 1)
+		assert.Equal(t, "#/properties/name", compatErr.Violations[0].Path)
+	})
 }
 
 func TestRegistry_DeleteOperations(t *testing.T) {
@@ -244,31 +275,908 @@ func TestRegistry_DeleteOperations(t *testing.T) {
 	defer cleanup()
 
 	// Register test schemas
-	schema1 := `{"type": "object", "properties": {"name": {"type": "string"}}}`
+	schema1 := `{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": false}`
 	schema2 := `{"type": "object", "properties": {"age": {"type": "integer"}}}`
 
-	_, err := registry.RegisterSchema("test-subject", schema1, types.JSON)
+	_, err := registry.RegisterSchema(t.Context(), "test-subject", schema1, types.JSON, nil, false)
 	require.NoError(t, err)
-	id2, err := registry.RegisterSchema("test-subject", schema2, types.JSON)
+	id2, err := registry.RegisterSchema(t.Context(), "test-subject", schema2, types.JSON, nil, false)
 	require.NoError(t, err)
 
 	t.Run("Delete Schema Version", func(t *testing.T) {
-		err := registry.DeleteSchemaVersion("test-subject", "1")
+		_, err := registry.DeleteSchemaVersion(t.Context(), "test-subject", "1", false)
 		assert.NoError(t, err)
 
 		// Verify schema is deleted
-		_, err = registry.GetSchemaBySubjectVersion("test-subject", "1")
+		_, err = registry.GetSchemaBySubjectVersion(t.Context(), "test-subject", "1", false)
 		assert.Error(t, err)
 	})
 
 	t.Run("Delete Subject", func(t *testing.T) {
-		deletedIDs, err := registry.DeleteSubject("test-subject")
+		deletedIDs, err := registry.DeleteSubject(t.Context(), "test-subject", false)
 		assert.NoError(t, err)
 		assert.Equal(t, []int{id2}, deletedIDs)
 
 		// Verify subject is deleted
-		versions, err := registry.GetVersions("test-subject")
+		versions, err := registry.GetVersions(t.Context(), "test-subject", false)
 		assert.Error(t, err)
 		assert.Nil(t, versions)
 	})
 }
+
+func TestRegistry_Mode(t *testing.T) {
+	registry, cleanup := setupRegistry(t)
+	defer cleanup()
+
+	schema1 := `{"type": "object", "properties": {"name": {"type": "string"}}}`
+	schema2 := `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}}`
+
+	_, err := registry.RegisterSchema(t.Context(), "test-subject", schema1, types.JSON, nil, false)
+	require.NoError(t, err)
+
+	t.Run("Default Mode", func(t *testing.T) {
+		mode, err := registry.GetMode(t.Context(), "global", true)
+		assert.NoError(t, err)
+		assert.Equal(t, types.ReadWrite, mode)
+
+		_, err = registry.GetMode(t.Context(), "test-subject", false)
+		assert.ErrorIs(t, err, ErrModeNotFound)
+	})
+
+	t.Run("Invalid Mode", func(t *testing.T) {
+		err := registry.SetMode(t.Context(), "test-subject", types.Mode("BOGUS"), false)
+		assert.ErrorIs(t, err, ErrInvalidMode)
+	})
+
+	t.Run("Read Only Subject", func(t *testing.T) {
+		require.NoError(t, registry.SetMode(t.Context(), "test-subject", types.ReadOnly, false))
+
+		_, err := registry.RegisterSchema(t.Context(), "test-subject", schema2, types.JSON, nil, false)
+		assert.ErrorIs(t, err, ErrOperationNotPermitted)
+		_, err = registry.DeleteSubject(t.Context(), "test-subject", false)
+		assert.ErrorIs(t, err, ErrOperationNotPermitted)
+
+		// Other subjects are unaffected
+		_, err = registry.RegisterSchema(t.Context(), "other-subject", schema1, types.JSON, nil, false)
+		assert.NoError(t, err)
+
+		old, err := registry.DeleteMode(t.Context(), "test-subject")
+		assert.NoError(t, err)
+		assert.Equal(t, types.ReadOnly, old)
+	})
+
+	t.Run("Read Only Override", func(t *testing.T) {
+		require.NoError(t, registry.SetMode(t.Context(), "test-subject", types.ReadWrite, false))
+		require.NoError(t, registry.SetMode(t.Context(), "global", types.ReadOnlyOverride, false))
+
+		_, err := registry.RegisterSchema(t.Context(), "test-subject", schema2, types.JSON, nil, false)
+		assert.ErrorIs(t, err, ErrOperationNotPermitted)
+
+		require.NoError(t, registry.SetMode(t.Context(), "global", types.ReadWrite, false))
+		_, err = registry.DeleteMode(t.Context(), "test-subject")
+		require.NoError(t, err)
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		// Existing subjects block IMPORT unless forced
+		err := registry.SetMode(t.Context(), "test-subject", types.Import, false)
+		assert.ErrorIs(t, err, ErrOperationNotPermitted)
+
+		_, err = registry.ImportSchema(t.Context(), "imported", schema2, types.JSON, nil, 100, 5, false)
+		assert.ErrorIs(t, err, ErrOperationNotPermitted)
+
+		require.NoError(t, registry.SetMode(t.Context(), "imported", types.Import, false))
+		id, err := registry.ImportSchema(t.Context(), "imported", schema2, types.JSON, nil, 100, 5, false)
+		require.NoError(t, err)
+		assert.Equal(t, 100, id)
+
+		schema, err := registry.GetSchemaBySubjectVersion(t.Context(), "imported", "5", false)
+		require.NoError(t, err)
+		assert.Equal(t, 100, schema.ID)
+
+		// Reusing an ID for a different schema is rejected
+		_, err = registry.ImportSchema(t.Context(), "imported", schema1, types.JSON, nil, 100, 6, false)
+		assert.ErrorIs(t, err, ErrOperationNotPermitted)
+
+		// Plain registration requires an explicit ID in IMPORT mode
+		_, err = registry.RegisterSchema(t.Context(), "imported", schema1, types.JSON, nil, false)
+		assert.ErrorIs(t, err, ErrOperationNotPermitted)
+	})
+}
+
+func TestRegistry_SoftAndPermanentDelete(t *testing.T) {
+	registry, cleanup := setupRegistry(t)
+	defer cleanup()
+
+	schema1 := `{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": false}`
+	schema2 := `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}}`
+
+	id1, err := registry.RegisterSchema(t.Context(), "test-subject", schema1, types.JSON, nil, false)
+	require.NoError(t, err)
+	_, err = registry.RegisterSchema(t.Context(), "test-subject", schema2, types.JSON, nil, false)
+	require.NoError(t, err)
+	// The same schema under another subject shares the ID
+	shared, err := registry.RegisterSchema(t.Context(), "other-subject", schema1, types.JSON, nil, false)
+	require.NoError(t, err)
+	require.Equal(t, id1, shared)
+
+	t.Run("Permanent Delete Requires Soft Delete", func(t *testing.T) {
+		_, err := registry.DeleteSchemaVersion(t.Context(), "test-subject", "1", true)
+		assert.ErrorIs(t, err, ErrVersionNotSoftDeleted)
+		_, err = registry.DeleteSubject(t.Context(), "test-subject", true)
+		assert.ErrorIs(t, err, ErrSubjectNotSoftDeleted)
+	})
+
+	t.Run("Soft Delete Version", func(t *testing.T) {
+		version, err := registry.DeleteSchemaVersion(t.Context(), "test-subject", "1", false)
+		require.NoError(t, err)
+		assert.Equal(t, 1, version)
+
+		_, err = registry.DeleteSchemaVersion(t.Context(), "test-subject", "1", false)
+		assert.ErrorIs(t, err, ErrVersionSoftDeleted)
+
+		// Hidden by default, visible with deleted
+		_, err = registry.GetSchemaBySubjectVersion(t.Context(), "test-subject", "1", false)
+		assert.Error(t, err)
+		schema, err := registry.GetSchemaBySubjectVersion(t.Context(), "test-subject", "1", true)
+		require.NoError(t, err)
+		assert.True(t, schema.Deleted)
+
+		versions, err := registry.GetVersions(t.Context(), "test-subject", true)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, versions)
+
+		// The ID stays resolvable
+		_, err = registry.GetSchema(t.Context(), id1)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Soft Delete Subject", func(t *testing.T) {
+		ids, err := registry.DeleteSubject(t.Context(), "test-subject", false)
+		require.NoError(t, err)
+		assert.Len(t, ids, 1)
+
+		_, err = registry.DeleteSubject(t.Context(), "test-subject", false)
+		assert.ErrorIs(t, err, ErrSubjectSoftDeleted)
+
+		subjects, err := registry.GetSubjects(t.Context(), false)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"other-subject"}, subjects)
+		subjects, err = registry.GetSubjects(t.Context(), true)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"other-subject", "test-subject"}, subjects)
+	})
+
+	t.Run("Permanent Delete Subject", func(t *testing.T) {
+		_, err := registry.DeleteSubject(t.Context(), "test-subject", true)
+		require.NoError(t, err)
+
+		_, err = registry.GetVersions(t.Context(), "test-subject", true)
+		assert.Error(t, err)
+
+		// Still referenced by other-subject
+		_, err = registry.GetSchema(t.Context(), id1)
+		assert.NoError(t, err)
+	})
+}
+
+func TestRegistry_ConcurrentRegistration(t *testing.T) {
+	ns, nc, kvSchemas, kvConfig := setupTestNATS(t)
+	defer ns.Shutdown()
+	defer nc.Close()
+
+	// Simulate several registry replicas sharing the same buckets
+	const replicas = 3
+	registries := []*Registry{New(kvSchemas, kvConfig)}
+	for i := 1; i < replicas; i++ {
+		conn, err := nats.Connect(ns.ClientURL())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		js, err := conn.JetStream()
+		require.NoError(t, err)
+		schemas, err := js.KeyValue("schemas")
+		require.NoError(t, err)
+		config, err := js.KeyValue("config")
+		require.NoError(t, err)
+		registries = append(registries, New(NewJetStreamStore(schemas), NewJetStreamStore(config)))
+	}
+	require.NoError(t, registries[0].SetCompatibilityLevel(t.Context(), "shared-subject", types.None))
+
+	type result struct {
+		id  int
+		err error
+	}
+
+	const workers = 24
+	results := make(chan result, workers*2)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			registry := registries[i%replicas]
+
+			// Racing on the same subject must not hand out the same version twice
+			schema := fmt.Sprintf(`{"type": "object", "properties": {"shared%d": {"type": "string"}}}`, i)
+			id, err := registry.RegisterSchema(t.Context(), "shared-subject", schema, types.JSON, nil, false)
+			results <- result{id, err}
+
+			// Racing on different subjects must not hand out the same ID twice
+			schema = fmt.Sprintf(`{"type": "object", "properties": {"own%d": {"type": "string"}}}`, i)
+			id, err = registry.RegisterSchema(t.Context(), fmt.Sprintf("subject-%d", i), schema, types.JSON, nil, false)
+			results <- result{id, err}
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	ids := make(map[int]bool)
+	for res := range results {
+		require.NoError(t, res.err)
+		assert.False(t, ids[res.id], "schema ID %d allocated twice", res.id)
+		ids[res.id] = true
+	}
+	assert.Len(t, ids, workers*2)
+
+	schemas, err := registries[0].getSubjectVersions(t.Context(), "shared-subject")
+	require.NoError(t, err)
+	require.Len(t, schemas, workers)
+	for i, schema := range schemas {
+		assert.Equal(t, i+1, schema.Version)
+	}
+}
+
+func TestRegistry_SchemaIndex(t *testing.T) {
+	registry, cleanup := setupRegistry(t)
+	defer cleanup()
+
+	schema1 := `{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": false}`
+	schema2 := `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}}`
+
+	id1, err := registry.RegisterSchema(t.Context(), "subject-a", schema1, types.JSON, nil, false)
+	require.NoError(t, err)
+	id2, err := registry.RegisterSchema(t.Context(), "subject-a", schema2, types.JSON, nil, false)
+	require.NoError(t, err)
+	shared, err := registry.RegisterSchema(t.Context(), "subject-b", schema1, types.JSON, nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, id1, shared)
+
+	t.Run("Lookup", func(t *testing.T) {
+		schema, err := registry.LookupSchema(t.Context(), "subject-a", schema2, types.JSON, nil, false)
+		require.NoError(t, err)
+		assert.Equal(t, id2, schema.ID)
+		assert.Equal(t, 2, schema.Version)
+
+		_, err = registry.LookupSchema(t.Context(), "subject-b", schema2, types.JSON, nil, false)
+		assert.Error(t, err)
+		_, err = registry.LookupSchema(t.Context(), "missing-subject", schema1, types.JSON, nil, false)
+		assert.Error(t, err)
+	})
+
+	t.Run("Indexes Track Versions", func(t *testing.T) {
+		idx, err := registry.getSubjectIndex(t.Context(), "subject-a")
+		require.NoError(t, err)
+		assert.Equal(t, []versionEntry{{Version: 1, ID: id1}, {Version: 2, ID: id2}}, idx.Versions)
+
+		usages, err := registry.getIDUsages(t.Context(), DefaultContext, id1)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []schemaUsage{{Subject: "subject-a", Version: 1}, {Subject: "subject-b", Version: 1}}, usages)
+	})
+
+	t.Run("Indexes Follow Deletes", func(t *testing.T) {
+		_, err := registry.DeleteSubject(t.Context(), "subject-b", false)
+		require.NoError(t, err)
+		idx, err := registry.getSubjectIndex(t.Context(), "subject-b")
+		require.NoError(t, err)
+		assert.Equal(t, []versionEntry{{Version: 1, ID: id1, Deleted: true}}, idx.Versions)
+
+		_, err = registry.DeleteSubject(t.Context(), "subject-b", true)
+		require.NoError(t, err)
+		idx, err = registry.getSubjectIndex(t.Context(), "subject-b")
+		require.NoError(t, err)
+		assert.Empty(t, idx.Versions)
+
+		// The schema is still used by subject-a so it keeps its ID
+		usages, err := registry.getIDUsages(t.Context(), DefaultContext, id1)
+		require.NoError(t, err)
+		assert.Equal(t, []schemaUsage{{Subject: "subject-a", Version: 1}}, usages)
+		id, err := registry.lookupSchemaID(t.Context(), DefaultContext, schemaHash(schema1, types.JSON, nil))
+		require.NoError(t, err)
+		assert.Equal(t, id1, id)
+	})
+
+	t.Run("Rebuild Indexes", func(t *testing.T) {
+		// Drop the indexes and rebuild them from the records
+		keys, err := registry.kvSchemas.List("index/")
+		require.NoError(t, err)
+		for _, key := range keys {
+			require.NoError(t, registry.kvSchemas.Delete(key, 0))
+		}
+		require.NoError(t, registry.buildIndexes(t.Context()))
+
+		schema, err := registry.LookupSchema(t.Context(), "subject-a", schema1, types.JSON, nil, false)
+		require.NoError(t, err)
+		assert.Equal(t, id1, schema.ID)
+		versions, err := registry.GetVersions(t.Context(), "subject-a", true)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, versions)
+	})
+}
+
+func TestRegistry_Cache(t *testing.T) {
+	ns, nc, kvSchemas, kvConfig := setupTestNATS(t)
+	defer ns.Shutdown()
+	defer nc.Close()
+
+	registry := New(kvSchemas, kvConfig)
+	other := New(kvSchemas, kvConfig)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, registry.WaitReady(ctx))
+	require.NoError(t, other.WaitReady(ctx))
+
+	schema1 := `{"type": "object", "properties": {"name": {"type": "string"}}}`
+	schema2 := `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}}`
+
+	t.Run("Read Your Writes", func(t *testing.T) {
+		id, err := registry.RegisterSchema(t.Context(), "test-subject", schema1, types.JSON, nil, false)
+		require.NoError(t, err)
+
+		before := registry.CacheStats()
+		schema, err := registry.GetSchema(t.Context(), id)
+		require.NoError(t, err)
+		assert.Equal(t, schema1, schema.Schema)
+		versions, err := registry.GetVersions(t.Context(), "test-subject", false)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1}, versions)
+
+		after := registry.CacheStats()
+		assert.True(t, after.Hydrated)
+		assert.Equal(t, before.Caches["schemas"].Hits+1, after.Caches["schemas"].Hits)
+		assert.Equal(t, before.Caches["subjects"].Hits+1, after.Caches["subjects"].Hits)
+
+		require.NoError(t, registry.SetCompatibilityLevel(t.Context(), "test-subject", types.None))
+		level, err := registry.GetCompatibilityLevel(t.Context(), "test-subject")
+		require.NoError(t, err)
+		assert.Equal(t, types.None, level)
+
+		_, err = registry.DeleteSchemaVersion(t.Context(), "test-subject", "1", false)
+		require.NoError(t, err)
+		_, err = registry.GetVersions(t.Context(), "test-subject", false)
+		assert.Error(t, err)
+	})
+
+	t.Run("Writes From Other Instances", func(t *testing.T) {
+		id, err := other.RegisterSchema(t.Context(), "other-subject", schema2, types.JSON, nil, false)
+		require.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			versions, err := registry.GetVersions(t.Context(), "other-subject", false)
+			return err == nil && len(versions) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		schema, err := registry.GetSchema(t.Context(), id)
+		require.NoError(t, err)
+		assert.Equal(t, schema2, schema.Schema)
+	})
+
+	t.Run("Falls Back To Store Without Watcher", func(t *testing.T) {
+		close(registry.stopWatch)
+		assert.Eventually(t, func() bool {
+			return !registry.CacheStats().Hydrated
+		}, 5*time.Second, 10*time.Millisecond)
+
+		_, err := registry.RegisterSchema(t.Context(), "test-subject", schema2, types.JSON, nil, false)
+		require.NoError(t, err)
+		versions, err := registry.GetVersions(t.Context(), "test-subject", true)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, versions)
+	})
+}
+
+func TestRegistry_SerializeProtobuf(t *testing.T) {
+	registry, cleanup := setupRegistry(t)
+	defer cleanup()
+
+	schema := `syntax = "proto3";
+package com.example;
+
+message User {
+  string name = 1;
+}
+
+message Order {
+  string id = 1;
+
+  message Line {
+    string sku = 1;
+  }
+}
+`
+	id, err := registry.RegisterSchema(t.Context(), "orders-value", schema, types.Protobuf, nil, false)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		messageName string
+		data        map[string]interface{}
+		indexes     []byte
+	}{
+		{
+			name:    "First Message",
+			data:    map[string]interface{}{"name": "Ada"},
+			indexes: []byte{0x00},
+		},
+		{
+			name:        "Second Message",
+			messageName: "com.example.Order",
+			data:        map[string]interface{}{"id": "o-1"},
+			indexes:     []byte{0x02, 0x02},
+		},
+		{
+			name:        "Nested Message",
+			messageName: "com.example.Order.Line",
+			data:        map[string]interface{}{"sku": "s-1"},
+			indexes:     []byte{0x04, 0x02, 0x00},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := registry.SerializeMessage(t.Context(), tt.data, id, tt.messageName)
+			require.NoError(t, err)
+			assert.Equal(t, []byte{MagicByte, 0, 0, 0, byte(id)}, payload[:5])
+			assert.Equal(t, tt.indexes, payload[5:5+len(tt.indexes)])
+
+			data, err := registry.Deserialize(t.Context(), payload)
+			require.NoError(t, err)
+			assert.Equal(t, tt.data, data)
+		})
+	}
+
+	t.Run("Unknown Message Index", func(t *testing.T) {
+		_, err := registry.Deserialize(t.Context(), []byte{MagicByte, 0, 0, 0, byte(id), 0x02, 0x08})
+		assert.Error(t, err)
+	})
+}
+
+func TestRegistry_References(t *testing.T) {
+	registry, cleanup := setupRegistry(t)
+	defer cleanup()
+
+	zip := `{"type": "fixed", "name": "Zip", "namespace": "com.example", "size": 5}`
+	address := `{"type": "record", "name": "Address", "namespace": "com.example", "fields": [{"name": "zip", "type": "Zip"}]}`
+	user := `{"type": "record", "name": "User", "namespace": "com.example", "fields": [{"name": "name", "type": "string"}, {"name": "home", "type": "Address"}]}`
+
+	_, err := registry.RegisterSchema(t.Context(), "zip", zip, types.Avro, nil, false)
+	require.NoError(t, err)
+	_, err = registry.RegisterSchema(t.Context(), "address", address, types.Avro, []types.SchemaReference{
+		{Name: "com.example.Zip", Subject: "zip", Version: 1},
+	}, false)
+	require.NoError(t, err)
+
+	userRefs := []types.SchemaReference{{Name: "com.example.Address", Subject: "address", Version: 1}}
+	id, err := registry.RegisterSchema(t.Context(), "user", user, types.Avro, userRefs, false)
+	require.NoError(t, err)
+
+	t.Run("Resolved Transitively", func(t *testing.T) {
+		refs, err := registry.resolveReferences(t.Context(), DefaultContext, types.Avro, userRefs)
+		require.NoError(t, err)
+		require.Len(t, refs, 2)
+		assert.Equal(t, "zip", refs[0].Subject)
+		assert.Equal(t, "address", refs[1].Subject)
+	})
+
+	t.Run("Serialize Deserialize", func(t *testing.T) {
+		data := map[string]interface{}{"name": "Ada", "home": map[string]interface{}{"zip": [5]byte{'1', '2', '3', '4', '5'}}}
+		payload, err := registry.Serialize(t.Context(), data, id)
+		require.NoError(t, err)
+
+		result, err := registry.Deserialize(t.Context(), payload)
+		require.NoError(t, err)
+		assert.Equal(t, data, result)
+	})
+
+	t.Run("Unresolved Named Type", func(t *testing.T) {
+		_, err := registry.RegisterSchema(t.Context(), "other", user, types.Avro, nil, false)
+		assert.ErrorIs(t, err, ErrInvalidSchema)
+	})
+
+	t.Run("Missing Reference", func(t *testing.T) {
+		_, err := registry.RegisterSchema(t.Context(), "other", user, types.Avro, []types.SchemaReference{
+			{Name: "com.example.Address", Subject: "address", Version: 2},
+		}, false)
+		assert.ErrorIs(t, err, ErrReferenceNotFound)
+	})
+
+	t.Run("Type Mismatch", func(t *testing.T) {
+		_, err := registry.RegisterSchema(t.Context(), "other", `{"type": "object"}`, types.JSON, userRefs, false)
+		assert.ErrorIs(t, err, ErrInvalidSchema)
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		// The API never creates cycles since references must already exist, so
+		// make zip refer back to address in the store
+		key := keyPrefixSubjects + "zip/versions/1"
+		entry, err := registry.kvSchemas.Get(key)
+		require.NoError(t, err)
+		var stored types.Schema
+		require.NoError(t, json.Unmarshal(entry.Value, &stored))
+		stored.References = []types.SchemaReference{{Name: "com.example.Address", Subject: "address", Version: 1}}
+		value, err := json.Marshal(stored)
+		require.NoError(t, err)
+		_, err = registry.kvSchemas.Put(key, value)
+		require.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			_, err := registry.resolveReferences(t.Context(), DefaultContext, types.Avro, userRefs)
+			return errors.Is(err, ErrInvalidSchema) && strings.Contains(err.Error(), "reference cycle")
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestRegistry_ReferencedBy(t *testing.T) {
+	registry, cleanup := setupRegistry(t)
+	defer cleanup()
+
+	address := `{"type": "record", "name": "Address", "namespace": "com.example", "fields": [{"name": "zip", "type": "string"}]}`
+	addressRefs := []types.SchemaReference{{Name: "com.example.Address", Subject: "address", Version: 1}}
+
+	_, err := registry.RegisterSchema(t.Context(), "address", address, types.Avro, nil, false)
+	require.NoError(t, err)
+	userID, err := registry.RegisterSchema(t.Context(), "user", `{"type": "record", "name": "User", "namespace": "com.example", "fields": [{"name": "home", "type": "Address"}]}`, types.Avro, addressRefs, false)
+	require.NoError(t, err)
+	orderID, err := registry.RegisterSchema(t.Context(), "order", `{"type": "record", "name": "Order", "namespace": "com.example", "fields": [{"name": "shipTo", "type": "Address"}]}`, types.Avro, addressRefs, false)
+	require.NoError(t, err)
+
+	ids, err := registry.GetReferencedBy(t.Context(), "address", "1")
+	require.NoError(t, err)
+	assert.Equal(t, []int{userID, orderID}, ids)
+
+	t.Run("Index Built For Older Layouts", func(t *testing.T) {
+		require.NoError(t, registry.kvSchemas.Delete(refIndexKey("address", 1), 0))
+		_, err := registry.kvSchemas.Put(keyIndexVersion, []byte("1"))
+		require.NoError(t, err)
+		require.NoError(t, registry.buildIndexes(t.Context()))
+
+		ids, err := registry.GetReferencedBy(t.Context(), "address", "1")
+		require.NoError(t, err)
+		assert.Equal(t, []int{userID, orderID}, ids)
+	})
+
+	t.Run("Referenced Version Cannot Be Deleted", func(t *testing.T) {
+		_, err := registry.DeleteSchemaVersion(t.Context(), "address", "1", false)
+		assert.ErrorIs(t, err, ErrReferenceExists)
+		_, err = registry.DeleteSubject(t.Context(), "address", false)
+		assert.ErrorIs(t, err, ErrReferenceExists)
+	})
+
+	t.Run("Soft Deleted Referrers", func(t *testing.T) {
+		_, err := registry.DeleteSubject(t.Context(), "user", false)
+		require.NoError(t, err)
+		_, err = registry.DeleteSchemaVersion(t.Context(), "order", "1", false)
+		require.NoError(t, err)
+
+		ids, err := registry.GetReferencedBy(t.Context(), "address", "latest")
+		require.NoError(t, err)
+		assert.Empty(t, ids)
+
+		// Soft-deleted referrers still resolve by ID, so they only block a permanent delete
+		_, err = registry.DeleteSchemaVersion(t.Context(), "address", "1", false)
+		require.NoError(t, err)
+		_, err = registry.DeleteSchemaVersion(t.Context(), "address", "1", true)
+		assert.ErrorIs(t, err, ErrReferenceExists)
+	})
+
+	t.Run("Permanently Deleted Referrers", func(t *testing.T) {
+		_, err := registry.DeleteSubject(t.Context(), "user", true)
+		require.NoError(t, err)
+		_, err = registry.DeleteSchemaVersion(t.Context(), "order", "1", true)
+		require.NoError(t, err)
+
+		_, err = registry.DeleteSchemaVersion(t.Context(), "address", "1", true)
+		assert.NoError(t, err)
+	})
+}
+
+func TestRegistry_Normalize(t *testing.T) {
+	registry, cleanup := setupRegistry(t)
+	defer cleanup()
+
+	schema := `{"type": "object", "properties": {"name": {"type": "string"}}}`
+	reordered := `{
+		"properties": {"name": {"type": "string"}},
+		"type": "object"
+	}`
+	normalized := `{"properties":{"name":{"type":"string"}},"type":"object"}`
+
+	t.Run("Requested", func(t *testing.T) {
+		id, err := registry.RegisterSchema(t.Context(), "requested", schema, types.JSON, nil, true)
+		require.NoError(t, err)
+		same, err := registry.RegisterSchema(t.Context(), "requested", reordered, types.JSON, nil, true)
+		require.NoError(t, err)
+		assert.Equal(t, id, same)
+
+		stored, err := registry.GetSchema(t.Context(), id)
+		require.NoError(t, err)
+		assert.Equal(t, normalized, stored.Schema)
+
+		found, err := registry.LookupSchema(t.Context(), "requested", reordered, types.JSON, nil, true)
+		require.NoError(t, err)
+		assert.Equal(t, 1, found.Version)
+		_, err = registry.LookupSchema(t.Context(), "requested", reordered, types.JSON, nil, false)
+		assert.ErrorIs(t, err, ErrSchemaNotFound)
+	})
+
+	t.Run("Configured", func(t *testing.T) {
+		normalize, err := registry.GetNormalize(t.Context(), "configured")
+		require.NoError(t, err)
+		assert.False(t, normalize)
+
+		require.NoError(t, registry.SetNormalize(t.Context(), "configured", true))
+		normalize, err = registry.GetNormalize(t.Context(), "configured")
+		require.NoError(t, err)
+		assert.True(t, normalize)
+
+		id, err := registry.RegisterSchema(t.Context(), "configured", reordered, types.JSON, nil, false)
+		require.NoError(t, err)
+		stored, err := registry.GetSchema(t.Context(), id)
+		require.NoError(t, err)
+		assert.Equal(t, normalized, stored.Schema)
+
+		// Other subjects follow the global setting
+		normalize, err = registry.GetNormalize(t.Context(), "other")
+		require.NoError(t, err)
+		assert.False(t, normalize)
+	})
+
+	t.Run("Not Requested", func(t *testing.T) {
+		id, err := registry.RegisterSchema(t.Context(), "raw", reordered, types.JSON, nil, false)
+		require.NoError(t, err)
+		stored, err := registry.GetSchema(t.Context(), id)
+		require.NoError(t, err)
+		assert.Equal(t, reordered, stored.Schema)
+	})
+}
+
+func TestRegistry_Contexts(t *testing.T) {
+	registry, cleanup := setupRegistry(t)
+	defer cleanup()
+
+	schema := `{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": false}`
+	other := `{"type": "object", "properties": {"id": {"type": "integer"}}}`
+
+	defaultID, err := registry.RegisterSchema(t.Context(), "orders", schema, types.JSON, nil, false)
+	require.NoError(t, err)
+	_, err = registry.RegisterSchema(t.Context(), "orders", other, types.JSON, nil, false)
+	require.NoError(t, err)
+
+	// Each context allocates IDs of its own
+	teamID, err := registry.RegisterSchema(t.Context(), ":.team:orders", other, types.JSON, nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, defaultID)
+	assert.Equal(t, 1, teamID)
+
+	t.Run("Lookups", func(t *testing.T) {
+		stored, err := registry.GetSchema(t.Context(), 1)
+		require.NoError(t, err)
+		assert.Equal(t, schema, stored.Schema)
+
+		stored, err = registry.GetSchemaInContext(t.Context(), ".team", 1)
+		require.NoError(t, err)
+		assert.Equal(t, other, stored.Schema)
+
+		stored, err = registry.GetSchemaById(t.Context(), "1", ":.team:orders")
+		require.NoError(t, err)
+		assert.Equal(t, other, stored.Schema)
+
+		_, err = registry.GetSchemaInContext(t.Context(), ".team", 2)
+		assert.ErrorIs(t, err, ErrSchemaNotFound)
+
+		versions, err := registry.GetVersions(t.Context(), ":.team:orders", false)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1}, versions)
+	})
+
+	t.Run("Subjects And Contexts", func(t *testing.T) {
+		subjects, err := registry.GetSubjects(t.Context(), false)
+		require.NoError(t, err)
+		assert.Equal(t, []string{":.team:orders", "orders"}, subjects)
+
+		contexts, err := registry.GetContexts(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, []string{".", ".team"}, contexts)
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		require.NoError(t, registry.SetCompatibilityLevel(t.Context(), ":.team:", types.None))
+
+		level, err := registry.GetCompatibilityLevel(t.Context(), ":.team:orders")
+		require.NoError(t, err)
+		assert.Equal(t, types.None, level)
+		level, err = registry.GetCompatibilityLevel(t.Context(), "orders")
+		require.NoError(t, err)
+		assert.Equal(t, defaultCompatibilityLevel, level)
+
+		require.NoError(t, registry.SetCompatibilityLevel(t.Context(), ":.team:orders", types.Full))
+		level, err = registry.GetCompatibilityLevel(t.Context(), ":.team:orders")
+		require.NoError(t, err)
+		assert.Equal(t, types.Full, level)
+	})
+
+	t.Run("References Stay In Context", func(t *testing.T) {
+		address := `{"type": "record", "name": "Address", "fields": [{"name": "zip", "type": "string"}]}`
+		refs := []types.SchemaReference{{Name: "Address", Subject: "address", Version: 1}}
+		user := `{"type": "record", "name": "User", "fields": [{"name": "home", "type": "Address"}]}`
+
+		_, err := registry.RegisterSchema(t.Context(), ":.team:address", address, types.Avro, nil, false)
+		require.NoError(t, err)
+		_, err = registry.RegisterSchema(t.Context(), ":.team:user", user, types.Avro, refs, false)
+		require.NoError(t, err)
+		_, err = registry.RegisterSchema(t.Context(), "user", user, types.Avro, refs, false)
+		assert.ErrorIs(t, err, ErrReferenceNotFound)
+
+		_, err = registry.DeleteSubject(t.Context(), ":.team:address", false)
+		assert.ErrorIs(t, err, ErrReferenceExists)
+	})
+}
+
+func TestRegistry_Status(t *testing.T) {
+	ns, nc, kvSchemas, kvConfig := setupTestNATS(t)
+	defer ns.Shutdown()
+	registry := New(kvSchemas, kvConfig)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, registry.WaitReady(ctx))
+
+	_, err := registry.RegisterSchema(t.Context(), "status", `{"type": "string"}`, types.JSON, nil, false)
+	require.NoError(t, err)
+
+	status := registry.Status(t.Context())
+	assert.True(t, status.Ready, status.Reason)
+	assert.Equal(t, "JetStream", status.Schemas.Stats.Backend)
+	assert.NotZero(t, status.Schemas.Stats.Values)
+	assert.Equal(t, status.Schemas.Revision, status.Schemas.AppliedRevision)
+	assert.NotNil(t, status.Schemas.LastWatchEvent)
+
+	// Without a connection to JetStream the registry is no longer ready
+	nc.Close()
+	status = registry.Status(t.Context())
+	assert.False(t, status.Ready)
+	assert.NotEmpty(t, status.Schemas.Error)
+}
+
+func TestRegistry_Metrics(t *testing.T) {
+	registry, cleanup := setupRegistry(t)
+	defer cleanup()
+
+	count := func(outcome string) float64 {
+		return testutil.ToFloat64(registrations.WithLabelValues(outcome))
+	}
+	before := map[string]float64{}
+	for _, outcome := range []string{registerNewID, registerReusedID, registerIncompatible, registerInvalid} {
+		before[outcome] = count(outcome)
+	}
+	checks := testutil.ToFloat64(compatibilityChecks.WithLabelValues(string(types.JSON), string(types.Backward), "incompatible"))
+
+	schema := `{"type": "object", "properties": {"name": {"type": "string"}}}`
+	_, err := registry.RegisterSchema(t.Context(), "metrics", schema, types.JSON, nil, false)
+	require.NoError(t, err)
+	_, err = registry.RegisterSchema(t.Context(), "metrics", schema, types.JSON, nil, false)
+	require.NoError(t, err)
+	_, err = registry.RegisterSchema(t.Context(), "metrics", `{"type": "string"}`, types.JSON, nil, false)
+	require.Error(t, err)
+	_, err = registry.RegisterSchema(t.Context(), "metrics", `{"type":`, types.JSON, nil, false)
+	require.Error(t, err)
+
+	assert.Equal(t, before[registerNewID]+1, count(registerNewID))
+	assert.Equal(t, before[registerReusedID]+1, count(registerReusedID))
+	assert.Equal(t, before[registerIncompatible]+1, count(registerIncompatible))
+	assert.Equal(t, before[registerInvalid]+1, count(registerInvalid))
+	assert.Equal(t, checks+1, testutil.ToFloat64(compatibilityChecks.WithLabelValues(string(types.JSON), string(types.Backward), "incompatible")))
+
+	// Store operations are timed per bucket
+	assert.NotZero(t, testutil.CollectAndCount(storeDuration))
+}
+
+func TestRegistry_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	registry, cleanup := setupRegistry(t)
+	defer cleanup()
+
+	id, err := registry.RegisterSchema(t.Context(), "traced", `{"type": "string"}`, types.JSON, nil, false)
+	require.NoError(t, err)
+	_, err = registry.GetSchema(t.Context(), id)
+	require.NoError(t, err)
+
+	// Validation and KV operations are children of the registry operation
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	var kvSpans []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+		if strings.HasPrefix(span.Name(), "KV.") {
+			kvSpans = append(kvSpans, span)
+		}
+	}
+	register, ok := spans["Registry.RegisterSchema"]
+	require.True(t, ok)
+	require.Contains(t, spans, "SchemaFormat.Validate")
+	assert.Equal(t, register.SpanContext().SpanID(), spans["SchemaFormat.Validate"].Parent().SpanID())
+	require.NotEmpty(t, kvSpans)
+	for _, span := range kvSpans {
+		if span.Parent().IsValid() {
+			assert.Equal(t, register.SpanContext().TraceID(), span.SpanContext().TraceID())
+		}
+	}
+
+	// A lookup by ID in the default context opens a single span
+	require.Contains(t, spans, "Registry.GetSchemaInContext")
+	assert.False(t, spans["Registry.GetSchemaInContext"].Parent().IsValid())
+	assert.NotContains(t, spans, "Registry.GetSchema")
+}
+
+func TestRegistry_ACL(t *testing.T) {
+	ns, nc, kvSchemas, kvConfig := setupTestNATS(t)
+	defer func() {
+		ns.Shutdown()
+		nc.Close()
+	}()
+	registry := New(kvSchemas, kvConfig)
+	defer registry.Close()
+	other := New(kvSchemas, kvConfig)
+	defer other.Close()
+	for _, r := range []*Registry{registry, other} {
+		require.NoError(t, r.WaitReady(t.Context()))
+	}
+
+	acl, err := registry.GetACL(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, acl.Grants)
+
+	err = registry.SetACL(t.Context(), &ACL{Grants: []Grant{{Principal: "jwt:ci", Role: "owner"}}})
+	assert.ErrorIs(t, err, ErrInvalidACL)
+	err = registry.SetACL(t.Context(), &ACL{Grants: []Grant{{Principal: "ci", Role: RoleReader}}})
+	assert.ErrorIs(t, err, ErrInvalidACL, "principals are qualified with their authentication method")
+
+	require.NoError(t, registry.SetACL(t.Context(), &ACL{Grants: []Grant{
+		{Principal: "jwt:ci", Role: RoleDeveloper, SubjectPrefix: "orders-"},
+		{Principal: "jwt:ci", Role: RoleAdmin, Context: ".team"},
+		{Principal: "basic:ops", Role: RoleOperator},
+		{Principal: AnyPrincipal, Role: RoleReader, SubjectPrefix: "public-"},
+	}}))
+
+	// Other registries watching the config bucket pick up the change
+	require.Eventually(t, func() bool {
+		acl, err := other.GetACL(t.Context())
+		return err == nil && len(acl.Grants) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+	acl, err = other.GetACL(t.Context())
+	require.NoError(t, err)
+
+	assert.True(t, acl.Allows("jwt:ci", "orders-value", RoleReader))
+	assert.True(t, acl.Allows("jwt:ci", "orders-value", RoleDeveloper))
+	assert.False(t, acl.Allows("jwt:ci", "orders-value", RoleOperator))
+	assert.False(t, acl.Allows("jwt:ci", "payments-value", RoleReader))
+	assert.True(t, acl.Allows("jwt:ci", ":.team:payments-value", RoleAdmin))
+	assert.True(t, acl.Allows("jwt:ci", ":.team:", RoleOperator), "context config is covered by grants on the context")
+	assert.False(t, acl.Allows("jwt:ci", "global", RoleReader))
+	assert.False(t, acl.Allows("basic:ci", "orders-value", RoleReader), "grants apply to the method they name")
+	assert.True(t, acl.Allows("basic:ops", "global", RoleOperator))
+	assert.False(t, acl.Allows("basic:ops", "orders-value", RoleAdmin))
+	assert.True(t, acl.Allows("apikey:anyone", "public-value", RoleReader))
+	assert.False(t, acl.Allows("apikey:anyone", "public-value", RoleDeveloper))
+	assert.True(t, acl.AllowsContext("jwt:ci", ".team"))
+	assert.False(t, acl.AllowsContext("apikey:anyone", ".team"))
+
+	// A list that cannot be parsed denies everything
+	_, err = kvConfig.Put(keyACL, []byte(`{"grants": [{"role": "reader"}]}`))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		acl, err := other.GetACL(t.Context())
+		return err == nil && len(acl.Grants) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,83 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Status describes whether a registry can serve requests, and the state of
+// its buckets
+type Status struct {
+	Ready    bool         `json:"ready"`
+	Reason   string       `json:"reason,omitempty"` // why the registry is not ready
+	Degraded bool         `json:"degraded"`
+	Schemas  BucketStatus `json:"schemas"`
+	Config   BucketStatus `json:"config"`
+}
+
+// BucketStatus describes a bucket and how far the cache has caught up with it
+type BucketStatus struct {
+	Name            string      `json:"name"`
+	Stats           *StoreStats `json:"stats,omitempty"`
+	Error           string      `json:"error,omitempty"` // why the stats could not be read
+	Revision        uint64      `json:"revision"`        // latest change to the bucket
+	AppliedRevision uint64      `json:"appliedRevision"` // latest change applied to the cache
+	Watching        bool        `json:"watching"`
+	Hydrated        bool        `json:"hydrated"`
+	LastWatchEvent  *time.Time  `json:"lastWatchEvent,omitempty"`
+}
+
+// Status reads the state of the registry. The registry is ready once its
+// storage can be reached and its cache watcher has caught up with both buckets.
+func (r *Registry) Status(ctx context.Context) Status {
+	status := Status{Degraded: r.Degraded()}
+
+	r.cacheMu.RLock()
+	schemasView, configView := r.schemasView, r.configView
+	r.cacheMu.RUnlock()
+
+	status.Schemas = bucketStatus(r.schemaStore(ctx), schemasView)
+	status.Config = bucketStatus(r.configStore(ctx), configView)
+
+	switch {
+	case status.Degraded:
+		status.Reason = "storage unavailable"
+	case status.Schemas.Error != "":
+		status.Reason = fmt.Sprintf("bucket %s unavailable: %s", status.Schemas.Name, status.Schemas.Error)
+	case status.Config.Error != "":
+		status.Reason = fmt.Sprintf("bucket %s unavailable: %s", status.Config.Name, status.Config.Error)
+	case !schemasView.watching || !configView.watching:
+		status.Reason = "cache watcher not running"
+	case !schemasView.hydrated || !configView.hydrated:
+		status.Reason = "cache not hydrated"
+	default:
+		status.Ready = true
+	}
+	return status
+}
+
+// bucketStatus reads the state of a bucket and of its view in the cache
+func bucketStatus(kv Store, view bucketView) BucketStatus {
+	status := BucketStatus{
+		Name:            kv.Name(),
+		AppliedRevision: view.revision,
+		Watching:        view.watching,
+		Hydrated:        view.hydrated,
+	}
+	if !view.lastEvent.IsZero() {
+		lastEvent := view.lastEvent
+		status.LastWatchEvent = &lastEvent
+	}
+
+	stats, err := kv.Stats()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Stats = stats
+	if status.Revision, err = kv.Revision(); err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
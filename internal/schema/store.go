@@ -0,0 +1,80 @@
+package schema
+
+import (
+	"errors"
+	"time"
+)
+
+// Store is a key-value bucket holding the records of a registry. Every change
+// to a bucket is given a revision, higher than that of any change before it,
+// so that writers can detect concurrent updates and watchers can tell how far
+// they have caught up.
+type Store interface {
+	// Name returns the name of the bucket
+	Name() string
+
+	// Get returns the current value of a key, or ErrKeyNotFound
+	Get(key string) (*Entry, error)
+
+	// Put writes the value of a key and returns the revision of the change
+	Put(key string, value []byte) (uint64, error)
+
+	// Create writes the value of a key that does not exist yet, or returns ErrKeyExists
+	Create(key string, value []byte) (uint64, error)
+
+	// Update writes the value of a key whose current value has the given
+	// revision, or returns ErrKeyExists if it changed since
+	Update(key string, value []byte, revision uint64) (uint64, error)
+
+	// Delete removes a key. A revision other than 0 makes the delete
+	// conditional like Update.
+	Delete(key string, revision uint64) error
+
+	// List returns the keys that start with prefix
+	List(prefix string) ([]string, error)
+
+	// Watch streams the current value of every key, then a nil entry, then
+	// every change made to the bucket
+	Watch() (Watcher, error)
+
+	// Revision returns the revision of the latest change to the bucket
+	Revision() (uint64, error)
+
+	// Stats returns the size of the bucket. It fails when the backend cannot
+	// be reached.
+	Stats() (*StoreStats, error)
+}
+
+// StoreStats describes the content of a bucket
+type StoreStats struct {
+	Backend string        `json:"backend"` // technology storing the bucket
+	Values  uint64        `json:"values"`  // number of values, history included
+	Bytes   uint64        `json:"bytes"`
+	History int64         `json:"history"` // values kept per key
+	TTL     time.Duration `json:"ttl,omitempty"`
+}
+
+// Entry is the value of a key at a revision
+type Entry struct {
+	Key      string
+	Value    []byte
+	Revision uint64
+	Deleted  bool // the change removed the key
+}
+
+// Watcher delivers the changes made to a store
+type Watcher interface {
+	// Updates returns the channel changes are delivered on. It is closed once
+	// the watcher stops.
+	Updates() <-chan *Entry
+
+	// Stop stops delivering changes
+	Stop() error
+}
+
+var (
+	// ErrKeyNotFound is returned when reading a key that does not exist
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrKeyExists is returned when creating a key that exists or updating a key that changed
+	ErrKeyExists = errors.New("key exists")
+)
@@ -0,0 +1,312 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"schemaregistry/internal/schema/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"Memory": func(t *testing.T) Store {
+			return NewMemoryStore("test")
+		},
+		"JetStream": func(t *testing.T) Store {
+			ns, nc, kvSchemas, _ := setupTestNATS(t)
+			t.Cleanup(func() {
+				nc.Close()
+				ns.Shutdown()
+			})
+			return kvSchemas
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, newStore(t))
+		})
+	}
+}
+
+// testStore checks the behavior every Store implementation must share
+func testStore(t *testing.T, store Store) {
+	watcher, err := store.Watch()
+	require.NoError(t, err)
+	defer watcher.Stop()
+	next := func() *Entry {
+		select {
+		case entry := <-watcher.Updates():
+			return entry
+		case <-time.After(5 * time.Second):
+			t.Fatal("no update from watcher")
+			return nil
+		}
+	}
+	assert.Nil(t, next(), "empty store should only deliver the end of initial values")
+
+	_, err = store.Get("a/1")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	rev1, err := store.Create("a/1", []byte("one"))
+	require.NoError(t, err)
+	_, err = store.Create("a/1", []byte("again"))
+	assert.ErrorIs(t, err, ErrKeyExists)
+
+	rev2, err := store.Update("a/1", []byte("two"), rev1)
+	require.NoError(t, err)
+	assert.Greater(t, rev2, rev1)
+	_, err = store.Update("a/1", []byte("stale"), rev1)
+	assert.ErrorIs(t, err, ErrKeyExists)
+
+	entry, err := store.Get("a/1")
+	require.NoError(t, err)
+	assert.Equal(t, "two", string(entry.Value))
+	assert.Equal(t, rev2, entry.Revision)
+
+	_, err = store.Put("a/2", []byte("put"))
+	require.NoError(t, err)
+	_, err = store.Put("b/1", []byte("other"))
+	require.NoError(t, err)
+	keys, err := store.List("a/")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a/1", "a/2"}, keys)
+
+	assert.ErrorIs(t, store.Delete("a/1", rev1), ErrKeyExists)
+	require.NoError(t, store.Delete("a/1", rev2))
+	_, err = store.Get("a/1")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	revision, err := store.Revision()
+	require.NoError(t, err)
+	stats, err := store.Stats()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, stats.Values, uint64(2))
+	assert.NotZero(t, stats.Bytes)
+
+	// The watcher sees every change in order
+	for _, want := range []struct {
+		key     string
+		value   string
+		deleted bool
+	}{
+		{"a/1", "one", false},
+		{"a/1", "two", false},
+		{"a/2", "put", false},
+		{"b/1", "other", false},
+		{"a/1", "", true},
+	} {
+		entry := next()
+		require.NotNil(t, entry)
+		assert.Equal(t, want.key, entry.Key)
+		assert.Equal(t, want.deleted, entry.Deleted)
+		if !want.deleted {
+			assert.Equal(t, want.value, string(entry.Value))
+		}
+	}
+
+	// A new watcher starts from the current values
+	current, err := store.Watch()
+	require.NoError(t, err)
+	defer current.Stop()
+	var seen []string
+	for entry := range current.Updates() {
+		if entry == nil {
+			break
+		}
+		seen = append(seen, entry.Key)
+		assert.LessOrEqual(t, entry.Revision, revision)
+	}
+	assert.Equal(t, []string{"a/2", "b/1"}, seen)
+}
+
+func TestRegistry_MemoryStore(t *testing.T) {
+	registry := New(NewMemoryStore("schemas"), NewMemoryStore("config"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, registry.WaitReady(ctx))
+
+	schema := `{"type": "object", "properties": {"name": {"type": "string"}}}`
+	id, err := registry.RegisterSchema(t.Context(), "memory", schema, types.JSON, nil, false)
+	require.NoError(t, err)
+
+	// Reads are served from the cache kept current by the watcher
+	stored, err := registry.GetSchema(t.Context(), id)
+	require.NoError(t, err)
+	assert.Equal(t, schema, stored.Schema)
+	stats := registry.CacheStats()
+	assert.True(t, stats.Hydrated)
+	assert.Equal(t, uint64(1), stats.Caches[cacheSchemas].Hits)
+}
+
+func TestRegistry_Degraded(t *testing.T) {
+	registry := New(NewMemoryStore("schemas"), NewMemoryStore("config"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, registry.WaitReady(ctx))
+
+	schema := `{"type": "object", "properties": {"name": {"type": "string"}}, "additionalProperties": false}`
+	next := `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}}`
+	id, err := registry.RegisterSchema(t.Context(), "degraded", schema, types.JSON, nil, false)
+	require.NoError(t, err)
+
+	// Writes are rejected while storage is unavailable, reads still work
+	registry.SetDegraded(true)
+	assert.True(t, registry.Degraded())
+	_, err = registry.RegisterSchema(t.Context(), "degraded", next, types.JSON, nil, false)
+	assert.ErrorIs(t, err, ErrStorageUnavailable)
+	assert.ErrorIs(t, registry.SetCompatibilityLevel(t.Context(), "global", types.None), ErrStorageUnavailable)
+	stored, err := registry.GetSchema(t.Context(), id)
+	require.NoError(t, err)
+	assert.Equal(t, schema, stored.Schema)
+
+	registry.SetDegraded(false)
+	_, err = registry.RegisterSchema(t.Context(), "degraded", next, types.JSON, nil, false)
+	assert.NoError(t, err)
+}
+
+// listCountingStore counts the calls to List of a store
+type listCountingStore struct {
+	Store
+	lists atomic.Int64
+}
+
+func (s *listCountingStore) List(prefix string) ([]string, error) {
+	s.lists.Add(1)
+	return s.Store.List(prefix)
+}
+
+func TestRegistry_ListsFromCache(t *testing.T) {
+	schemas := &listCountingStore{Store: NewMemoryStore("schemas")}
+	config := &listCountingStore{Store: NewMemoryStore("config")}
+	registry := New(schemas, config)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, registry.WaitReady(ctx))
+
+	schema := `{"type": "object", "properties": {"name": {"type": "string"}}}`
+	_, err := registry.RegisterSchema(t.Context(), "orders", schema, types.JSON, nil, false)
+	require.NoError(t, err)
+	_, err = registry.RegisterSchema(t.Context(), ":.team:payments", schema, types.JSON, nil, false)
+	require.NoError(t, err)
+	before := schemas.lists.Load() + config.lists.Load()
+
+	// Once hydrated, subjects and contexts are listed without scanning the buckets
+	subjects, err := registry.GetSubjects(t.Context(), false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{":.team:payments", "orders"}, subjects)
+	_, err = registry.DeleteSubject(t.Context(), "orders", false)
+	require.NoError(t, err)
+	subjects, err = registry.GetSubjects(t.Context(), false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{":.team:payments"}, subjects)
+	subjects, err = registry.GetSubjects(t.Context(), true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{":.team:payments", "orders"}, subjects)
+	contexts, err := registry.GetContexts(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []string{".", ".team"}, contexts)
+
+	err = registry.SetMode(t.Context(), ":.team:", types.Import, false)
+	assert.ErrorIs(t, err, ErrOperationNotPermitted)
+	require.NoError(t, registry.SetMode(t.Context(), ":.empty:", types.Import, false))
+	err = registry.SetMode(t.Context(), "global", types.Import, false)
+	assert.ErrorIs(t, err, ErrOperationNotPermitted)
+
+	assert.Equal(t, before, schemas.lists.Load()+config.lists.Load())
+}
+
+// indexFailingStore fails the writes to the subject indexes while fail is set,
+// like a writer losing every race on them
+type indexFailingStore struct {
+	Store
+	fail atomic.Bool
+}
+
+func (s *indexFailingStore) failing(key string) bool {
+	return s.fail.Load() && strings.Contains(key, keyPrefixSubjectIndex)
+}
+
+func (s *indexFailingStore) Create(key string, value []byte) (uint64, error) {
+	if s.failing(key) {
+		return 0, errors.New("index write failed")
+	}
+	return s.Store.Create(key, value)
+}
+
+func (s *indexFailingStore) Update(key string, value []byte, revision uint64) (uint64, error) {
+	if s.failing(key) {
+		return 0, errors.New("index write failed")
+	}
+	return s.Store.Update(key, value, revision)
+}
+
+func TestRegistry_IndexFailure(t *testing.T) {
+	schemas := &indexFailingStore{Store: NewMemoryStore("schemas")}
+	registry := New(schemas, NewMemoryStore("config"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, registry.WaitReady(ctx))
+	require.NoError(t, registry.SetCompatibilityLevel(t.Context(), "orders", types.None))
+
+	// A stored version is registered even if its index cannot be updated
+	schemas.fail.Store(true)
+	id, err := registry.RegisterSchema(t.Context(), "orders", `{"type": "string"}`, types.JSON, nil, false)
+	require.NoError(t, err)
+	stored, err := registry.GetSchema(t.Context(), id)
+	require.NoError(t, err)
+	assert.Equal(t, `{"type": "string"}`, stored.Schema)
+
+	// The next writer repairs the index
+	schemas.fail.Store(false)
+	_, err = registry.RegisterSchema(t.Context(), "orders", `{"type": "integer"}`, types.JSON, nil, false)
+	require.NoError(t, err)
+	idx, err := registry.getSubjectIndex(t.Context(), "orders")
+	require.NoError(t, err)
+	assert.Equal(t, []versionEntry{{Version: 1, ID: id}, {Version: 2, ID: id + 1}}, idx.Versions)
+	usages, err := registry.getIDUsages(t.Context(), DefaultContext, id)
+	require.NoError(t, err)
+	assert.Equal(t, []schemaUsage{{Subject: "orders", Version: 1}}, usages)
+}
+
+func TestRegistry_SubjectIndexRepair(t *testing.T) {
+	schemas := &indexFailingStore{Store: NewMemoryStore("schemas")}
+	registry := New(schemas, NewMemoryStore("config"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, registry.WaitReady(ctx))
+
+	// A version whose indexing failed is added back by the next reader of the index
+	schemas.fail.Store(true)
+	id, err := registry.RegisterSchema(t.Context(), "orders", `{"type": "string"}`, types.JSON, nil, false)
+	require.NoError(t, err)
+	schemas.fail.Store(false)
+	idx, err := registry.getSubjectIndex(t.Context(), "orders")
+	require.NoError(t, err)
+	assert.Equal(t, []versionEntry{{Version: 1, ID: id}}, idx.Versions)
+	stored, err := registry.readSubjectIndex(t.Context(), "orders")
+	require.NoError(t, err)
+	assert.Equal(t, idx.Versions, stored.Versions)
+	usages, err := registry.getIDUsages(t.Context(), DefaultContext, id)
+	require.NoError(t, err)
+	assert.Equal(t, []schemaUsage{{Subject: "orders", Version: 1}}, usages)
+
+	// So is a soft delete
+	schemas.fail.Store(true)
+	_, err = registry.DeleteSchemaVersion(t.Context(), "orders", "1", false)
+	require.NoError(t, err)
+	schemas.fail.Store(false)
+	idx, err = registry.getSubjectIndex(t.Context(), "orders")
+	require.NoError(t, err)
+	assert.Equal(t, []versionEntry{{Version: 1, ID: id, Deleted: true}}, idx.Versions)
+	stored, err = registry.readSubjectIndex(t.Context(), "orders")
+	require.NoError(t, err)
+	assert.Equal(t, idx.Versions, stored.Versions)
+}
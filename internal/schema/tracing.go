@@ -0,0 +1,61 @@
+package schema
+
+import (
+	"context"
+
+	"schemaregistry/internal/schema/types"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName names the tracer recording the spans of registry operations
+const tracerName = "schemaregistry/internal/schema"
+
+// Span attributes
+var (
+	attrSubject    = attribute.Key("schemaregistry.subject")
+	attrContext    = attribute.Key("schemaregistry.context")
+	attrSchemaID   = attribute.Key("schemaregistry.schema_id")
+	attrVersion    = attribute.Key("schemaregistry.version")
+	attrSchemaType = attribute.Key("schemaregistry.schema_type")
+	attrLevel      = attribute.Key("schemaregistry.compatibility_level")
+	attrBucket     = attribute.Key("schemaregistry.kv.bucket")
+	attrKey        = attribute.Key("schemaregistry.kv.key")
+)
+
+// startSpan starts the span of a registry operation. The tracer is taken from
+// the provider installed by the application when the span starts, so that
+// spans follow a provider replaced after the package was loaded.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends a span, marking it as failed if err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// validateSchema validates a schema in a span of its own
+func validateSchema(ctx context.Context, schemaType types.SchemaType, format types.SchemaFormat, schemaStr string, refs []types.ResolvedReference) error {
+	_, span := startSpan(ctx, "SchemaFormat.Validate", attrSchemaType.String(string(schemaType)))
+	err := format.Validate(schemaStr, refs)
+	endSpan(span, err)
+	return err
+}
+
+// checkFormatCompatibility checks a schema against a stored one in a span of its own
+func checkFormatCompatibility(ctx context.Context, schemaType types.SchemaType, format types.SchemaFormat, oldSchema string, oldRefs []types.ResolvedReference, newSchema string, newRefs []types.ResolvedReference, level types.CompatibilityLevel) ([]types.Violation, error) {
+	_, span := startSpan(ctx, "SchemaFormat.CheckCompatibility",
+		attrSchemaType.String(string(schemaType)), attrLevel.String(string(level)))
+	violations, err := format.CheckCompatibility(oldSchema, oldRefs, newSchema, newRefs, level)
+	span.SetAttributes(attribute.Int("schemaregistry.violations", len(violations)))
+	endSpan(span, err)
+	return violations, err
+}
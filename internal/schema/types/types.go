@@ -1,5 +1,7 @@
 package types
 
+import "fmt"
+
 // SchemaType represents the type of schema
 type SchemaType string
 
@@ -32,6 +34,20 @@ const (
 	FullTransitive CompatibilityLevel = "FULL_TRANSITIVE"
 )
 
+// Mode represents the operating mode of the registry or of a single subject
+type Mode string
+
+const (
+	// ReadWrite allows both reads and writes
+	ReadWrite Mode = "READWRITE"
+	// ReadOnly rejects registrations and deletes
+	ReadOnly Mode = "READONLY"
+	// ReadOnlyOverride rejects writes and, when set globally, overrides subject-level modes
+	ReadOnlyOverride Mode = "READONLY_OVERRIDE"
+	// Import allows registering schemas with explicit IDs and versions
+	Import Mode = "IMPORT"
+)
+
 // SchemaReference represents a reference to another schema
 type SchemaReference struct {
 	Name    string `json:"name"`    // Reference name
@@ -39,6 +55,14 @@ type SchemaReference struct {
 	Version int    `json:"version"` // Version number of the referenced subject
 }
 
+// ResolvedReference is a schema reference together with the schema it refers to
+type ResolvedReference struct {
+	Name    string // Reference name, as used by the referring schema
+	Subject string // Name of the referenced subject
+	Version int    // Version number of the referenced subject
+	Schema  string // Text of the referenced schema
+}
+
 // Schema represents a stored schema
 type Schema struct {
 	Schema     string            `json:"schema"`
@@ -47,16 +71,62 @@ type Schema struct {
 	ID         int               `json:"id"`
 	Type       SchemaType        `json:"type"`
 	References []SchemaReference `json:"references,omitempty"`
+	Deleted    bool              `json:"deleted,omitempty"`
 }
 
-// SchemaFormat defines the interface for schema format implementations
+// Violation describes one way in which a new schema breaks compatibility with an old one
+type Violation struct {
+	Path    string `json:"path"`              // Location of the incompatible element
+	OldType string `json:"oldType,omitempty"` // Type of the element in the old schema
+	NewType string `json:"newType,omitempty"` // Type of the element in the new schema
+	Rule    string `json:"rule"`              // Compatibility rule that is broken
+	Message string `json:"message"`           // Description of the violation
+}
+
+func (v Violation) String() string {
+	s := fmt.Sprintf("%s at %s: %s", v.Rule, v.Path, v.Message)
+	if v.OldType != "" || v.NewType != "" {
+		s += fmt.Sprintf(" (old type %s, new type %s)", orNone(v.OldType), orNone(v.NewType))
+	}
+	return s
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// SchemaFormat defines the interface for schema format implementations. Every
+// schema comes with the schemas it references, resolved recursively and ordered
+// so that each referenced schema follows the schemas it references in turn.
 type SchemaFormat interface {
 	// Validate validates a schema string
-	Validate(schemaStr string) error
+	Validate(schemaStr string, refs []ResolvedReference) error
+	// Normalize returns the canonical form of a valid schema, so that schemas
+	// differing only in formatting have the same text
+	Normalize(schemaStr string, refs []ResolvedReference) (string, error)
 	// Serialize serializes data according to a schema
-	Serialize(data interface{}, schemaStr string) ([]byte, error)
+	Serialize(data interface{}, schemaStr string, refs []ResolvedReference) ([]byte, error)
 	// Deserialize deserializes data according to a schema
-	Deserialize(data []byte, schemaStr string) (interface{}, error)
-	// CheckCompatibility checks if a new schema is compatible with an old schema
-	CheckCompatibility(oldSchema, newSchema string, level CompatibilityLevel) (bool, error)
+	Deserialize(data []byte, schemaStr string, refs []ResolvedReference) (interface{}, error)
+	// CheckCompatibility checks if a new schema is compatible with an old schema and
+	// returns the violations found, none if it is compatible. An error is only
+	// returned if the check cannot be performed, for example on an invalid schema.
+	CheckCompatibility(oldSchema string, oldRefs []ResolvedReference, newSchema string, newRefs []ResolvedReference, level CompatibilityLevel) ([]Violation, error)
+}
+
+// MessageFormat is implemented by formats whose schemas declare several message
+// types, such as Protobuf. The message used for a payload is identified on the
+// wire by its indexes in the schema.
+type MessageFormat interface {
+	// SerializeMessage serializes data as the named message, or the first message if the name is empty
+	SerializeMessage(data interface{}, schemaStr string, refs []ResolvedReference, messageName string) ([]byte, error)
+	// DeserializeMessage deserializes data as the named message, or the first message if the name is empty
+	DeserializeMessage(data []byte, schemaStr string, refs []ResolvedReference, messageName string) (interface{}, error)
+	// MessageIndexes returns the path of indexes locating the named message in the schema
+	MessageIndexes(schemaStr string, refs []ResolvedReference, messageName string) ([]int, error)
+	// MessageName returns the fully-qualified name of the message at a path of indexes
+	MessageName(schemaStr string, refs []ResolvedReference, indexes []int) (string, error)
 }
@@ -0,0 +1,92 @@
+package schema
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// maxMessageIndexes bounds the message index array read from a payload
+const maxMessageIndexes = 100
+
+// Bytes encodes the wire format: the magic byte, the schema ID as 4 bytes in
+// big-endian format, the message indexes if any, then the data
+func (w *WireFormat) Bytes() []byte {
+	result := make([]byte, 5, 5+len(w.Data)+binary.MaxVarintLen64)
+	result[0] = w.MagicByte
+	binary.BigEndian.PutUint32(result[1:5], uint32(w.SchemaID))
+	if w.MessageIndexes != nil {
+		result = appendMessageIndexes(result, w.MessageIndexes)
+	}
+	return append(result, w.Data...)
+}
+
+// parseWireFormat decodes the magic byte and schema ID of a payload. The rest
+// of the payload is left in Data since reading message indexes depends on the
+// schema type.
+func parseWireFormat(data []byte) (*WireFormat, error) {
+	if len(data) < 5 {
+		return nil, fmt.Errorf("data too short")
+	}
+
+	// Verify magic byte
+	if data[0] != MagicByte {
+		return nil, fmt.Errorf("invalid magic byte")
+	}
+
+	return &WireFormat{
+		MagicByte: data[0],
+		SchemaID:  int32(binary.BigEndian.Uint32(data[1:5])),
+		Data:      data[5:],
+	}, nil
+}
+
+// readMessageIndexes moves the message indexes at the start of Data into MessageIndexes
+func (w *WireFormat) readMessageIndexes() error {
+	indexes, n, err := readMessageIndexes(w.Data)
+	if err != nil {
+		return err
+	}
+	w.MessageIndexes = indexes
+	w.Data = w.Data[n:]
+	return nil
+}
+
+// appendMessageIndexes encodes message indexes as a zig-zag varint count
+// followed by the indexes. The common case of the first message, [0], is
+// encoded as a count of 0.
+func appendMessageIndexes(b []byte, indexes []int) []byte {
+	if len(indexes) == 1 && indexes[0] == 0 {
+		return binary.AppendVarint(b, 0)
+	}
+	b = binary.AppendVarint(b, int64(len(indexes)))
+	for _, index := range indexes {
+		b = binary.AppendVarint(b, int64(index))
+	}
+	return b
+}
+
+// readMessageIndexes decodes message indexes and returns them with the number of bytes read
+func readMessageIndexes(b []byte) ([]int, int, error) {
+	count, n := binary.Varint(b)
+	if n <= 0 {
+		return nil, 0, errors.New("invalid message indexes")
+	}
+	if count == 0 {
+		return []int{0}, n, nil
+	}
+	if count < 0 || count > maxMessageIndexes {
+		return nil, 0, fmt.Errorf("invalid message index count %d", count)
+	}
+
+	indexes := make([]int, count)
+	for i := range indexes {
+		index, m := binary.Varint(b[n:])
+		if m <= 0 || index < 0 {
+			return nil, 0, errors.New("invalid message indexes")
+		}
+		indexes[i] = int(index)
+		n += m
+	}
+	return indexes, n, nil
+}